@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestAuditEvents(t *testing.T) {
+	account := createTestAccount(t, "test_audit", "audit_password")
+	if _, err := db.CreateGrant(&account.Id, nil, "/test_audit", model.PermissionAdmin); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+	secret, _, err := db.CreateToken(account, "audit", model.PermissionAdmin, nil)
+	if err != nil {
+		t.Fatalf("failed to create token: %+v", err)
+	}
+	const (
+		lockId      = `{"ID":"00000000-0000-0000-0000-000000000001"}`
+		otherLockId = `{"ID":"00000000-0000-0000-0000-000000000002"}`
+	)
+	tests := []struct {
+		method   string
+		password string
+		uri      url.URL
+		body     string
+		msg      string
+	}{
+		{"LOCK", "audit_password", url.URL{Path: "/test_audit"}, lockId, "lock"},
+		{"POST", "audit_password", url.URL{Path: "/test_audit", RawQuery: "ID=00000000-0000-0000-0000-000000000001"}, testState("test_audit", 1), "push"},
+		{"UNLOCK", "audit_password", url.URL{Path: "/test_audit"}, lockId, "unlock"},
+		{"LOCK", "audit_password", url.URL{Path: "/test_audit"}, otherLockId, "lock again"},
+		{"UNLOCK", secret, url.URL{Path: "/test_audit"}, otherLockId[:len(otherLockId)-1] + `,"Who":"someone else"}`, "fail to unlock with a wrong lock"},
+		{"UNLOCK", secret, url.URL{Path: "/test_audit"}, `{"ID":"00000000-0000-0000-0000-000000000002"}`, "unlock with the token"},
+		{"LOCK", secret, url.URL{Path: "/test_audit"}, lockId[:len(lockId)-1] + `,"Who":"someone else"}`, "lock with the token"},
+		{"UNLOCK", secret, url.URL{Path: "/test_audit"}, `{"ID":"00000000-0000-0000-0000-000000000001"}`, "force-unlock"},
+		{"DELETE", "audit_password", url.URL{Path: "/test_audit"}, "", "delete"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs(tt.method, "test_audit", tt.password, &tt.uri, strings.NewReader(tt.body), func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed to %s: %+v", tt.msg, err)
+			}
+			if tt.msg == "fail to unlock with a wrong lock" {
+				if r.StatusCode != http.StatusConflict {
+					t.Fatalf("%s should fail with a conflict, got %s", tt.msg, http.StatusText(r.StatusCode))
+				}
+			} else if r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to %s, got %s", tt.msg, http.StatusText(r.StatusCode))
+			}
+		})
+	}
+
+	events, err := db.LoadAuditEvents(&model.AuditFilter{Target: "/test_audit"})
+	if err != nil {
+		t.Fatalf("failed to load audit events: %+v", err)
+	}
+	actions := make([]string, 0, len(events))
+	for _, event := range events {
+		actions = append(actions, event.Action)
+		if event.Actor != "test_audit" || event.ActorId == nil || !event.ActorId.Equal(account.Id) {
+			t.Fatalf("%s event has an unexpected actor %s", event.Action, event.Actor)
+		}
+		if event.SourceIP != "127.0.0.1" {
+			t.Fatalf("%s event has an unexpected source ip %s", event.Action, event.SourceIP)
+		}
+		if event.TargetType != model.AuditTargetState || event.Target != "/test_audit" {
+			t.Fatalf("%s event has an unexpected target %s %s", event.Action, event.TargetType, event.Target)
+		}
+	}
+	expected := []string{
+		model.AuditStateDelete,
+		model.AuditStateForceUnlock,
+		model.AuditStateLock,
+		model.AuditStateUnlock,
+		model.AuditStateLock,
+		model.AuditStateUnlock,
+		model.AuditStatePush,
+		model.AuditStateLock,
+	}
+	if !slices.Equal(actions, expected) {
+		t.Fatalf("unexpected audit events, got %v, expected %v", actions, expected)
+	}
+	if events[1].TokenName == nil || *events[1].TokenName != "audit" {
+		t.Fatal("the force-unlock event should record the token")
+	}
+	if events[len(events)-2].TokenName != nil {
+		t.Fatal("the push event should not record a token")
+	}
+	var push struct {
+		Force  bool   `json:"force"`
+		LockId string `json:"lock_id"`
+		MD5    string `json:"md5"`
+	}
+	if err := json.Unmarshal(events[len(events)-2].After, &push); err != nil {
+		t.Fatalf("failed to unmarshal push event: %+v", err)
+	}
+	if push.Force || push.LockId != "00000000-0000-0000-0000-000000000001" || push.MD5 == "" {
+		t.Fatalf("unexpected push event values: %+v", push)
+	}
+
+	locks, err := db.LoadAuditEvents(&model.AuditFilter{Action: model.AuditStateLock, Limit: 2, Target: "/test_audit"})
+	if err != nil {
+		t.Fatalf("failed to load audit events: %+v", err)
+	}
+	if len(locks) != 2 || !locks[0].Id.Equal(events[2].Id) || !locks[1].Id.Equal(events[4].Id) {
+		t.Fatal("filtering audit events by action returned unexpected events")
+	}
+	older, err := db.LoadAuditEvents(&model.AuditFilter{Before: &locks[1].Id, Target: "/test_audit"})
+	if err != nil {
+		t.Fatalf("failed to load audit events: %+v", err)
+	}
+	if len(older) != 3 || !older[0].Id.Equal(events[5].Id) {
+		t.Fatal("paginating audit events returned unexpected events")
+	}
+
+	if _, err := db.Exec(`UPDATE audit_events SET actor = 'someone' WHERE id = ?;`, events[0].Id); err == nil {
+		t.Fatal("audit events should not be updatable")
+	}
+	if _, err := db.Exec(`DELETE FROM audit_events WHERE id = ?;`, events[0].Id); err == nil {
+		t.Fatal("audit events should not be deletable")
+	}
+}
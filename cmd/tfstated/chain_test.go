@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+func TestVersionsChain(t *testing.T) {
+	for serial := 1; serial <= 5; serial++ {
+		runHTTPRequest("POST", true, &url.URL{Path: "/test_chain"}, strings.NewReader(testState("test_chain", serial)), func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to post version %d: %+v", serial, err)
+			}
+		})
+	}
+	states, err := db.LoadStates()
+	if err != nil {
+		t.Fatalf("failed to load states: %+v", err)
+	}
+	var state *model.State
+	for i := range states {
+		if states[i].Path == "/test_chain" {
+			state = &states[i]
+		}
+	}
+	if state == nil {
+		t.Fatal("failed to find state")
+	}
+	verify := func(msg string) *model.ChainReport {
+		report, err := db.VerifyChain(state)
+		if err != nil {
+			t.Fatalf("failed to verify chain %s: %+v", msg, err)
+		}
+		return report
+	}
+
+	report := verify("after pruning")
+	if !report.Valid() || report.Versions != 3 || report.Unchained != 0 {
+		t.Fatalf("the chain should remain valid after pruning, got %+v", report)
+	}
+	var head []byte
+	if err := db.QueryRow(`SELECT chain_hash FROM versions WHERE state_id = ? ORDER BY id DESC LIMIT 1;`, state.Id).Scan(&head); err != nil {
+		t.Fatalf("failed to select head: %+v", err)
+	}
+	if !bytes.Equal(report.Head, head) {
+		t.Fatal("the report head should be the chain hash of the latest version")
+	}
+	if err := runCommand(db, []string{"verify-chain", "/test_chain"}); err != nil {
+		t.Fatalf("verify-chain should succeed: %+v", err)
+	}
+
+	var middle model.Version
+	var created int64
+	var middleHash []byte
+	err = db.QueryRow(`SELECT id, account_id, chain_hash, created FROM versions WHERE state_id = ? ORDER BY id LIMIT 1 OFFSET 1;`, state.Id).Scan(&middle.Id, &middle.AccountId, &middleHash, &created)
+	if err != nil {
+		t.Fatalf("failed to select middle version: %+v", err)
+	}
+	if _, err := db.Exec(`UPDATE versions SET created = created - 86400 WHERE id = ?;`, middle.Id); err != nil {
+		t.Fatalf("failed to tamper with version: %+v", err)
+	}
+	report = verify("after tampering")
+	if report.Valid() || len(report.Breaks) != 1 || !report.Breaks[0].VersionId.Equal(middle.Id) {
+		t.Fatalf("tampering with a version should break the chain at this version, got %+v", report)
+	}
+	if err := runCommand(db, []string{"verify-chain", "/test_chain"}); err == nil {
+		t.Fatal("verify-chain should fail when a chain is broken")
+	}
+	if _, err := db.Exec(`UPDATE versions SET created = ? WHERE id = ?;`, created, middle.Id); err != nil {
+		t.Fatalf("failed to restore version: %+v", err)
+	}
+	if report = verify("after restoring"); !report.Valid() {
+		t.Fatalf("the chain should be valid again, got %+v", report)
+	}
+
+	if _, err := db.Exec(`UPDATE versions SET base_id = NULL WHERE base_id = ?;`, middle.Id); err != nil {
+		t.Fatalf("failed to detach deltas: %+v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM versions WHERE id = ?;`, middle.Id); err != nil {
+		t.Fatalf("failed to delete version: %+v", err)
+	}
+	report = verify("after deleting a version")
+	if report.Valid() || report.Versions != 2 {
+		t.Fatalf("deleting a version should break the chain, got %+v", report)
+	}
+
+	// someone with write access to the database hides the deletion as a prune
+	pruneHash := sha256.Sum256(append(append(append([]byte{}, state.Id[:]...), middle.Id[:]...), middleHash...))
+	_, err = db.Exec(`INSERT INTO versions_prunes(id, state_id, version_id, chain_hash, prune_hash) VALUES (?, ?, ?, ?, ?);`,
+		uuid.MustGenerate(uuid.V7), state.Id, middle.Id, middleHash, pruneHash[:])
+	if err != nil {
+		t.Fatalf("failed to forge versions prune: %+v", err)
+	}
+	if report = verify("after forging a versions prune"); report.Valid() {
+		t.Fatalf("a forged versions prune should not hide a deleted version, got %+v", report)
+	}
+	if _, err := db.Exec(`DELETE FROM versions_prunes WHERE version_id = ?;`, middle.Id); err != nil {
+		t.Fatalf("failed to delete forged versions prune: %+v", err)
+	}
+
+	// or recomputes the chain hashes following the deleted version
+	rows, err := db.Query(`SELECT account_id, chain_hash, created, data_hash, id FROM versions WHERE state_id = ? ORDER BY id;`, state.Id)
+	if err != nil {
+		t.Fatalf("failed to select versions: %+v", err)
+	}
+	type row struct {
+		accountId uuid.UUID
+		chainHash []byte
+		created   int64
+		dataHash  []byte
+		id        uuid.UUID
+	}
+	versions := make([]row, 0)
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.accountId, &r.chainHash, &r.created, &r.dataHash, &r.id); err != nil {
+			t.Fatalf("failed to load version from row: %+v", err)
+		}
+		versions = append(versions, r)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("failed to load versions from rows: %+v", err)
+	}
+	prev := versions[0].chainHash
+	for _, v := range versions[1:] {
+		h := sha256.New()
+		h.Write([]byte("tfstated version chain v1"))
+		h.Write(prev)
+		h.Write(state.Id[:])
+		h.Write(v.id[:])
+		h.Write(v.accountId[:])
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(v.created)))
+		h.Write(v.dataHash)
+		prev = h.Sum(nil)
+		if _, err := db.Exec(`UPDATE versions SET chain_hash = ? WHERE id = ?;`, prev, v.id); err != nil {
+			t.Fatalf("failed to rewrite chain hash: %+v", err)
+		}
+	}
+	if report = verify("after rewriting the chain"); report.Valid() {
+		t.Fatalf("rewriting the chain without the key of the state should not hide a deleted version, got %+v", report)
+	}
+}
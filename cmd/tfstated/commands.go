@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+)
+
+// Runs a command which does not need the database, returns false if the
+// command is not one of them
+func runStandaloneCommand(args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (bool, error) {
+	switch args[0] {
+	case "split-key":
+		if len(args) != 3 {
+			return true, fmt.Errorf("usage: tfstated split-key <shares> <threshold> < key")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return true, fmt.Errorf("failed to parse the number of shares: %w", err)
+		}
+		threshold, err := strconv.Atoi(args[2])
+		if err != nil {
+			return true, fmt.Errorf("failed to parse the threshold: %w", err)
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return true, fmt.Errorf("failed to read the key: %w", err)
+		}
+		var key scrypto.AES256Key
+		if err := key.FromBase64(strings.TrimSpace(string(data))); err != nil {
+			return true, fmt.Errorf("failed to decode the key, expected 32 bytes base64 encoded: %w", err)
+		}
+		shares, err := scrypto.SplitKey(&key, n, threshold)
+		if err != nil {
+			return true, fmt.Errorf("failed to split the key: %w", err)
+		}
+		for _, share := range shares {
+			fmt.Fprintln(stdout, share)
+		}
+		fmt.Fprintf(stderr, "TFSTATED_UNSEAL_KEY_CHECK=%s\n", base64.StdEncoding.EncodeToString(scrypto.KeyCheckValue(&key)))
+		return true, nil
+	case "unseal":
+		if len(args) != 2 {
+			return true, fmt.Errorf("usage: tfstated unseal <webui url> < shares")
+		}
+		return true, unsealRemote(args[1], getenv("TFSTATED_UNSEAL_USERNAME"), getenv("TFSTATED_UNSEAL_PASSWORD"), stdin, stdout)
+	default:
+		return false, nil
+	}
+}
+
+// Submits the shares read from stdin, one per line, to a running tfstated with
+// the credentials of an administrator
+func unsealRemote(webuiURL string, username string, password string, stdin io.Reader, stdout io.Writer) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("the TFSTATED_UNSEAL_USERNAME and TFSTATED_UNSEAL_PASSWORD environment variables must hold the credentials of an administrator")
+	}
+	uri, err := url.JoinPath(webuiURL, "api", "unseal")
+	if err != nil {
+		return fmt.Errorf("failed to parse the webui url: %w", err)
+	}
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		share := strings.TrimSpace(scanner.Text())
+		if share == "" {
+			continue
+		}
+		body, err := json.Marshal(map[string]string{"share": share})
+		if err != nil {
+			return fmt.Errorf("failed to encode share: %w", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(username, password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to submit share: %w", err)
+		}
+		var status struct {
+			Msg       string `json:"msg"`
+			Sealed    bool   `json:"sealed"`
+			Shares    int    `json:"shares"`
+			Threshold int    `json:"threshold"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to submit share: %s", status.Msg)
+		}
+		if !status.Sealed {
+			fmt.Fprintln(stdout, "unsealed")
+			return nil
+		}
+		fmt.Fprintf(stdout, "submitted %d of %d shares\n", status.Shares, status.Threshold)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read shares: %w", err)
+	}
+	return fmt.Errorf("still sealed after reading all shares")
+}
+
+// Unseals the database with the shares read from stdin, one per line, before
+// running an offline command
+func unsealLocal(db *database.DB, stdin io.Reader) error {
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		share := strings.TrimSpace(scanner.Text())
+		if share == "" {
+			continue
+		}
+		unsealed, err := db.SubmitUnsealShare(share)
+		if err != nil {
+			return err
+		}
+		if unsealed {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read shares: %w", err)
+	}
+	return database.ErrSealed
+}
+
+// Runs an offline maintenance command, the servers should not be running
+// against the same database at the same time
+func runCommand(db *database.DB, args []string) error {
+	switch args[0] {
+	case "recompress":
+		n, err := db.RecompressVersions()
+		if err != nil {
+			return fmt.Errorf("failed to recompress versions: %w", err)
+		}
+		fmt.Printf("recompressed %d versions\n", n)
+		return nil
+	case "reencrypt":
+		n, err := db.RewrapDataKeys()
+		if err != nil {
+			return fmt.Errorf("failed to rewrap data keys: %w", err)
+		}
+		fmt.Printf("rewrapped %d data keys\n", n)
+		if n, err = db.RewrapWebhookSecrets(); err != nil {
+			return fmt.Errorf("failed to rewrap webhook secrets: %w", err)
+		}
+		fmt.Printf("rewrapped %d webhook secrets\n", n)
+		if n, err = db.ReencryptVersions(); err != nil {
+			return fmt.Errorf("failed to reencrypt versions: %w", err)
+		}
+		fmt.Printf("reencrypted %d versions\n", n)
+		return nil
+	case "verify-chain":
+		return verifyChains(db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %s, valid commands are: recompress, reencrypt, split-key, unseal, verify-chain", args[0])
+	}
+}
+
+// Verifies the hash chain of the versions of the states at the given paths, or
+// of every state if none are given
+func verifyChains(db *database.DB, paths []string) error {
+	states, err := db.LoadStates()
+	if err != nil {
+		return fmt.Errorf("failed to load states: %w", err)
+	}
+	if len(paths) > 0 {
+		states = slices.DeleteFunc(states, func(state model.State) bool {
+			return !slices.Contains(paths, state.Path)
+		})
+		if len(states) != len(paths) {
+			return fmt.Errorf("some state paths were not found")
+		}
+	}
+	broken := 0
+	for _, state := range states {
+		report, err := db.VerifyChain(&state)
+		if err != nil {
+			return fmt.Errorf("failed to verify the chain of state %s: %w", state.Path, err)
+		}
+		status := "ok"
+		if !report.Valid() {
+			status = "BROKEN"
+			broken++
+		}
+		fmt.Printf("%s %s: %d versions, %d unchained, head %x\n", status, state.Path, report.Versions, report.Unchained, report.Head)
+		for _, b := range report.Breaks {
+			fmt.Printf("  version %s: %s\n", b.VersionId, b.Reason)
+		}
+	}
+	if broken > 0 {
+		return fmt.Errorf("the version chain of %d states is broken", broken)
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+)
+
+func TestRecompress(t *testing.T) {
+	resources := make([]string, 0, 100)
+	for i := range 100 {
+		resources = append(resources, fmt.Sprintf(`{"mode":"managed","type":"null_resource","name":"test_%d","instances":[]}`, i))
+	}
+	state := strings.Replace(testState("test_recompress", 1), `"resources":[]`, `"resources":[`+strings.Join(resources, ",")+`]`, 1)
+	runHTTPRequest("POST", true, &url.URL{Path: "/test_recompress"}, strings.NewReader(state), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to POST state: %+v", err)
+		}
+	})
+
+	// store the version as it was before tfstated compressed versions
+	var key scrypto.AES256Key
+	if err := key.FromBase64("hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM="); err != nil {
+		t.Fatalf("failed to decode data encryption key: %+v", err)
+	}
+	encryptedData, err := key.EncryptAES256([]byte(state))
+	if err != nil {
+		t.Fatalf("failed to encrypt state: %+v", err)
+	}
+	_, err = db.Exec(
+		`UPDATE versions
+           SET compression = NULL, data = ?, data_key = FALSE, envelope = NULL, key_id = 'default'
+           WHERE state_id = (SELECT id FROM states WHERE path = '/test_recompress');`,
+		encryptedData)
+	if err != nil {
+		t.Fatalf("failed to store uncompressed version: %+v", err)
+	}
+
+	countCompressed := func() (n int) {
+		err := db.QueryRow(
+			`SELECT COUNT(versions.id)
+               FROM versions
+               JOIN states ON states.id = versions.state_id
+               WHERE states.path = '/test_recompress' AND versions.compression IS NOT NULL;`).Scan(&n)
+		if err != nil {
+			t.Fatalf("failed to count compressed versions: %+v", err)
+		}
+		return n
+	}
+	expectState := func() {
+		runHTTPRequest("GET", true, &url.URL{Path: "/test_recompress"}, nil, func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to GET state: %+v", err)
+			}
+			if body, err := io.ReadAll(r.Body); err != nil {
+				t.Fatalf("failed to read body with error: %+v", err)
+			} else if string(body) != state {
+				t.Fatalf("GET returned a different state: %s", string(body))
+			}
+		})
+	}
+
+	if n := countCompressed(); n != 0 {
+		t.Fatalf("there should be no compressed version before recompressing, got %d", n)
+	}
+	expectState()
+	if err := runCommand(db, []string{"recompress"}); err != nil {
+		t.Fatalf("failed to recompress: %+v", err)
+	}
+	if n := countCompressed(); n != 1 {
+		t.Fatalf("there should be 1 compressed version after recompressing, got %d", n)
+	}
+	expectState()
+	if err := runCommand(db, []string{"unknown"}); err == nil {
+		t.Fatalf("running an unknown command should fail")
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	state := testState("test_reencrypt", 1)
+	runHTTPRequest("POST", true, &url.URL{Path: "/test_reencrypt"}, strings.NewReader(state), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to POST state: %+v", err)
+		}
+	})
+	otherState := testState("test_reencrypt_other", 1)
+	runHTTPRequest("POST", true, &url.URL{Path: "/test_reencrypt_other"}, strings.NewReader(otherState), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to POST state: %+v", err)
+		}
+	})
+
+	expectState := func(path string, state string, status int) {
+		runHTTPRequest("GET", true, &url.URL{Path: path}, nil, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed to GET state: %+v", err)
+			}
+			if r.StatusCode != status {
+				t.Fatalf("GET %s should return %s, got %s", path, http.StatusText(status), http.StatusText(r.StatusCode))
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if body, err := io.ReadAll(r.Body); err != nil {
+				t.Fatalf("failed to read body with error: %+v", err)
+			} else if string(body) != state {
+				t.Fatalf("GET returned a different state: %s", string(body))
+			}
+		})
+	}
+
+	// a version moved to another state must not decrypt
+	_, err := db.Exec(
+		`UPDATE versions
+           SET data = (SELECT data
+                         FROM versions
+                         WHERE state_id = (SELECT id FROM states WHERE path = '/test_reencrypt'))
+           WHERE state_id = (SELECT id FROM states WHERE path = '/test_reencrypt_other');`)
+	if err != nil {
+		t.Fatalf("failed to swap versions: %+v", err)
+	}
+	expectState("/test_reencrypt_other", otherState, http.StatusInternalServerError)
+
+	// store the version as it was before tfstated used envelopes
+	var key scrypto.AES256Key
+	if err := key.FromBase64("hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM="); err != nil {
+		t.Fatalf("failed to decode data encryption key: %+v", err)
+	}
+	encryptedData, err := key.EncryptAES256([]byte(state))
+	if err != nil {
+		t.Fatalf("failed to encrypt state: %+v", err)
+	}
+	_, err = db.Exec(
+		`UPDATE versions
+           SET compression = NULL, data = ?, data_key = FALSE, envelope = NULL, key_id = 'default'
+           WHERE state_id = (SELECT id FROM states WHERE path = '/test_reencrypt');`,
+		encryptedData)
+	if err != nil {
+		t.Fatalf("failed to store legacy version: %+v", err)
+	}
+
+	countLegacy := func() (n int) {
+		err := db.QueryRow(
+			`SELECT COUNT(versions.id)
+               FROM versions
+               JOIN states ON states.id = versions.state_id
+               WHERE states.path = '/test_reencrypt' AND versions.envelope IS NULL;`).Scan(&n)
+		if err != nil {
+			t.Fatalf("failed to count legacy versions: %+v", err)
+		}
+		return n
+	}
+	if n := countLegacy(); n != 1 {
+		t.Fatalf("there should be 1 legacy version before reencrypting, got %d", n)
+	}
+	expectState("/test_reencrypt", state, http.StatusOK)
+	if err := runCommand(db, []string{"reencrypt"}); err != nil {
+		t.Fatalf("failed to reencrypt: %+v", err)
+	}
+	if n := countLegacy(); n != 0 {
+		t.Fatalf("there should be no legacy version after reencrypting, got %d", n)
+	}
+	expectState("/test_reencrypt", state, http.StatusOK)
+}
+
+func TestReencryptKeyRotation(t *testing.T) {
+	const (
+		oldKey = "hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM="
+		newKey = "J3gk9iGqPBn0Wqz+XvQ4B1p2mJ5Yx8cK0c3dO6s1uRM="
+	)
+	path := filepath.Join(t.TempDir(), "rotation.db")
+	open := func(env map[string]string) (*database.DB, error) {
+		return database.NewDB(context.Background(), path, func(key string) string {
+			if key == "TFSTATED_SESSIONS_SALT" {
+				return "a528D1m9q3IZxLinSmHmeKxrx3Pmm7GQ3nBzIDxjr0A="
+			}
+			return env[key]
+		})
+	}
+	closeDB := func(db *database.DB) {
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close database: %+v", err)
+		}
+	}
+	countKey := func(db *database.DB, keyId string) (n int) {
+		if err := db.QueryRow(`SELECT COUNT(id) FROM versions WHERE key_id = ?;`, keyId).Scan(&n); err != nil {
+			t.Fatalf("failed to count versions: %+v", err)
+		}
+		return n
+	}
+	expectState := func(db *database.DB, serial int) {
+		version, err := db.GetState("/rotation")
+		if err != nil {
+			t.Fatalf("failed to get state: %+v", err)
+		}
+		if string(version.Data) != testState("rotation", serial) {
+			t.Fatalf("got an unexpected state: %s", string(version.Data))
+		}
+	}
+
+	before, err := open(map[string]string{"TFSTATED_DATA_ENCRYPTION_KEY": oldKey})
+	if err != nil {
+		t.Fatalf("failed to open database: %+v", err)
+	}
+	account, err := before.CreateAccount("rotation", false)
+	if err != nil {
+		t.Fatalf("failed to create account: %+v", err)
+	}
+	if err := before.SetState("/rotation", account.Id, []byte(testState("rotation", 1)), "", false); err != nil {
+		t.Fatalf("failed to set state: %+v", err)
+	}
+	closeDB(before)
+
+	invalid := []struct {
+		env map[string]string
+		msg string
+	}{
+		{map[string]string{}, "without any key"},
+		{map[string]string{"TFSTATED_DATA_ENCRYPTION_KEYS": "next:" + newKey}, "without the key of stored versions"},
+		{map[string]string{"TFSTATED_DATA_ENCRYPTION_KEYS": "default:" + oldKey + ",next:" + newKey}, "with several keys and no active key"},
+		{map[string]string{"TFSTATED_DATA_ENCRYPTION_KEY": oldKey, "TFSTATED_DATA_ENCRYPTION_KEYS": "default:" + newKey}, "with a duplicate key id"},
+		{map[string]string{"TFSTATED_DATA_ENCRYPTION_KEY": oldKey, "TFSTATED_DATA_ENCRYPTION_KEYS": "next"}, "with a key without id"},
+		{map[string]string{"TFSTATED_DATA_ENCRYPTION_KEY": oldKey, "TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE": "next"}, "with an unknown active key"},
+	}
+	for _, tt := range invalid {
+		if db, err := open(tt.env); err == nil {
+			closeDB(db)
+			t.Fatalf("opening the database %s should have failed", tt.msg)
+		}
+	}
+
+	rotated, err := open(map[string]string{
+		"TFSTATED_DATA_ENCRYPTION_KEY":        oldKey,
+		"TFSTATED_DATA_ENCRYPTION_KEYS":       "next:" + newKey,
+		"TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE": "next",
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %+v", err)
+	}
+	expectState(rotated, 1)
+	if err := rotated.SetState("/rotation", account.Id, []byte(testState("rotation", 2)), "", false); err != nil {
+		t.Fatalf("failed to set state: %+v", err)
+	}
+	if n := countKey(rotated, "default"); n != 2 {
+		t.Fatalf("the data key of the state should still be wrapped with the old key before reencrypting, got %d versions", n)
+	}
+	if err := runCommand(rotated, []string{"reencrypt"}); err != nil {
+		t.Fatalf("failed to reencrypt: %+v", err)
+	}
+	if n := countKey(rotated, "default"); n != 0 {
+		t.Fatalf("there should be no version encrypted with the old key after reencrypting, got %d", n)
+	}
+	closeDB(rotated)
+
+	after, err := open(map[string]string{"TFSTATED_DATA_ENCRYPTION_KEYS": "next:" + newKey})
+	if err != nil {
+		t.Fatalf("failed to open database without the old key: %+v", err)
+	}
+	defer closeDB(after)
+	expectState(after, 2)
+	if n := countKey(after, "next"); n != 2 {
+		t.Fatalf("there should be 2 versions encrypted with the new key, got %d", n)
+	}
+}
+
+func TestReencryptKeyProviders(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "providers.db")
+	fileKey := filepath.Join(dir, "file.key")
+	execKey := filepath.Join(dir, "exec.key")
+	if err := os.WriteFile(fileKey, []byte("hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM=\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %+v", err)
+	}
+	if err := os.WriteFile(execKey, []byte("J3gk9iGqPBn0Wqz+XvQ4B1p2mJ5Yx8cK0c3dO6s1uRM=\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %+v", err)
+	}
+	open := func(providers string, active string) *database.DB {
+		db, err := database.NewDB(context.Background(), path, func(key string) string {
+			switch key {
+			case "TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE":
+				return active
+			case "TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS":
+				return providers
+			case "TFSTATED_SESSIONS_SALT":
+				return "a528D1m9q3IZxLinSmHmeKxrx3Pmm7GQ3nBzIDxjr0A="
+			default:
+				return ""
+			}
+		})
+		if err != nil {
+			t.Fatalf("failed to open database with key providers %s: %+v", providers, err)
+		}
+		return db
+	}
+	closeDB := func(db *database.DB) {
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close database: %+v", err)
+		}
+	}
+
+	before := open("file=file:"+fileKey, "")
+	account, err := before.CreateAccount("providers", false)
+	if err != nil {
+		t.Fatalf("failed to create account: %+v", err)
+	}
+	if err := before.SetState("/providers", account.Id, []byte(testState("providers", 1)), "", false); err != nil {
+		t.Fatalf("failed to set state: %+v", err)
+	}
+	closeDB(before)
+
+	rotated := open("file=file:"+fileKey+"; exec=exec:cat "+execKey, "exec")
+	if err := runCommand(rotated, []string{"reencrypt"}); err != nil {
+		t.Fatalf("failed to reencrypt: %+v", err)
+	}
+	closeDB(rotated)
+
+	after := open("exec=exec:cat "+execKey, "")
+	defer closeDB(after)
+	version, err := after.GetState("/providers")
+	if err != nil {
+		t.Fatalf("failed to get state: %+v", err)
+	}
+	if string(version.Data) != testState("providers", 1) {
+		t.Fatalf("got an unexpected state: %s", string(version.Data))
+	}
+}
+
+func TestDataKeysCache(t *testing.T) {
+	// a fake key management service which does not encrypt but authenticates
+	// the context and counts unwraps
+	var unwraps atomic.Int32
+	kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Ciphertext string `json:"ciphertext"`
+			Context    string `json:"context"`
+			Plaintext  string `json:"plaintext"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/wrap":
+			_ = json.NewEncoder(w).Encode(map[string]string{"ciphertext": request.Context + ":" + request.Plaintext})
+		case "/unwrap":
+			unwraps.Add(1)
+			additionalData, plaintext, ok := strings.Cut(request.Ciphertext, ":")
+			if !ok || additionalData != request.Context {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"plaintext": plaintext})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer kms.Close()
+	path := filepath.Join(t.TempDir(), "datakeys.db")
+	open := func() *database.DB {
+		db, err := database.NewDB(context.Background(), path, func(key string) string {
+			switch key {
+			case "TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS":
+				return "kms=" + kms.URL
+			case "TFSTATED_SESSIONS_SALT":
+				return "a528D1m9q3IZxLinSmHmeKxrx3Pmm7GQ3nBzIDxjr0A="
+			default:
+				return ""
+			}
+		})
+		if err != nil {
+			t.Fatalf("failed to open database: %+v", err)
+		}
+		return db
+	}
+	read := func(db *database.DB, msg string) {
+		for range 3 {
+			version, err := db.GetState("/datakeys")
+			if err != nil || version == nil || string(version.Data) != testState("datakeys", 2) {
+				t.Fatalf("failed to get state %s: %+v", msg, err)
+			}
+		}
+	}
+
+	first := open()
+	account, err := first.CreateAccount("datakeys", false)
+	if err != nil {
+		t.Fatalf("failed to create account: %+v", err)
+	}
+	for serial := 1; serial <= 2; serial++ {
+		if err := first.SetState("/datakeys", account.Id, []byte(testState("datakeys", serial)), "", false); err != nil {
+			t.Fatalf("failed to set state: %+v", err)
+		}
+	}
+	unwraps.Store(0)
+	read(first, "after generating the data key")
+	if n := unwraps.Load(); n != 0 {
+		t.Errorf("a generated data key should be cached, got %d unwraps", n)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("failed to close database: %+v", err)
+	}
+
+	second := open()
+	defer func() { _ = second.Close() }()
+	unwraps.Store(0)
+	read(second, "after opening the database again")
+	if n := unwraps.Load(); n != 1 {
+		t.Errorf("the data key should be unwrapped once then cached, got %d unwraps", n)
+	}
+}
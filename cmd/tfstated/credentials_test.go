@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestCredentialsCache(t *testing.T) {
+	account := createTestAccount(t, "test_credentials", "first_password")
+	if _, err := db.CreateGrant(&account.Id, nil, "/test_credentials", model.PermissionRead); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+	secret, token, err := db.CreateToken(account, "cached", model.PermissionRead, nil)
+	if err != nil {
+		t.Fatalf("failed to create token: %+v", err)
+	}
+
+	tests := []struct {
+		before   func()
+		password string
+		status   int
+		msg      string
+	}{
+		{nil, "first_password", http.StatusOK, "with the password"},
+		{nil, "first_password", http.StatusOK, "with the cached password"},
+		{nil, secret, http.StatusOK, "with a token"},
+		{nil, secret, http.StatusOK, "with a cached token"},
+		{func() {
+			account.SetPassword("second_password")
+			if success, err := db.SaveAccount(account); err != nil || !success {
+				t.Fatalf("failed to save account: %+v", err)
+			}
+		}, "first_password", http.StatusForbidden, "with the previous password after a password change"},
+		{nil, "second_password", http.StatusOK, "with the new password after a password change"},
+		{func() {
+			if _, err := db.DeleteToken(account, token.Id); err != nil {
+				t.Fatalf("failed to delete token: %+v", err)
+			}
+		}, secret, http.StatusForbidden, "with a revoked token"},
+		{func() {
+			account.MarkForDeletion()
+			if success, err := db.SaveAccount(account); err != nil || !success {
+				t.Fatalf("failed to save account: %+v", err)
+			}
+		}, "second_password", http.StatusForbidden, "with the password of a deleted account"},
+	}
+	for _, tt := range tests {
+		if tt.before != nil {
+			tt.before()
+		}
+		runHTTPRequestAs("GET", "test_credentials", tt.password, &url.URL{Path: "/test_credentials"}, nil, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed GET with error: %+v", err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("GET %s should %s, got %s", tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 )
@@ -21,9 +24,13 @@ func TestDelete(t *testing.T) {
 		{"DELETE", false, url.URL{Path: "/"}, nil, "", http.StatusUnauthorized, "/"},
 		{"DELETE", true, url.URL{Path: "/"}, nil, "", http.StatusBadRequest, "/"},
 		{"DELETE", true, url.URL{Path: "/non_existent_delete"}, nil, "", http.StatusNotFound, "non existent"},
-		{"POST", true, url.URL{Path: "/test_delete"}, strings.NewReader("the_test_delete"), "", http.StatusOK, "/test_delete"},
-		{"DELETE", true, url.URL{Path: "/test_delete"}, nil, "", http.StatusOK, "/test_delete"},
+		{"POST", true, url.URL{Path: "/test_delete"}, strings.NewReader(testState("test_delete", 1)), "", http.StatusOK, "/test_delete"},
+		{"LOCK", true, url.URL{Path: "/test_delete"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), "", http.StatusOK, "/test_delete"},
+		{"DELETE", true, url.URL{Path: "/test_delete", RawQuery: "ID=ffffffff-ffff-ffff-ffff-ffffffffffff"}, nil, "", http.StatusConflict, "with a wrong lock ID on a locked state"},
+		{"DELETE", true, url.URL{Path: "/test_delete", RawQuery: "ID=00000000-0000-0000-0000-000000000000"}, nil, "", http.StatusOK, "with a correct lock ID on a locked state"},
 		{"DELETE", true, url.URL{Path: "/test_delete"}, nil, "", http.StatusNotFound, "/test_delete"},
+		{"POST", true, url.URL{Path: "/test_delete"}, strings.NewReader(testState("test_delete", 1)), "", http.StatusOK, "/test_delete"},
+		{"DELETE", true, url.URL{Path: "/test_delete"}, nil, "", http.StatusOK, "without lock ID on an unlocked state"},
 	}
 	for _, tt := range tests {
 		runHTTPRequest(tt.method, tt.auth, &tt.uri, tt.body, func(r *http.Response, err error) {
@@ -41,3 +48,33 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteShredsDataKey(t *testing.T) {
+	path := "/test_delete_shred"
+	runHTTPRequest("POST", true, &url.URL{Path: path}, strings.NewReader(testState("test_delete_shred", 1)), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to POST state: %+v", err)
+		}
+	})
+	var dataKey []byte
+	if err := db.QueryRow(`SELECT data_key FROM states WHERE path = ?;`, path).Scan(&dataKey); err != nil {
+		t.Fatalf("failed to select data key: %+v", err)
+	}
+	if len(dataKey) == 0 {
+		t.Fatalf("the state should have a data key")
+	}
+	runHTTPRequest("DELETE", true, &url.URL{Path: path}, nil, func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to DELETE state: %+v", err)
+		}
+	})
+	for _, file := range []string{"./test.db", "./test.db-wal"} {
+		data, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("failed to read %s: %+v", file, err)
+		}
+		if bytes.Contains(data, dataKey) {
+			t.Fatalf("the wrapped data key of a deleted state should not remain in %s", file)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDeltas(t *testing.T) {
+	path := "/test_deltas"
+	// a pretty printed state, as the delta encoding works on lines
+	state := func(serial int) string {
+		resources := make([]string, 0, 50)
+		for i := range 50 {
+			resources = append(resources, fmt.Sprintf("    {\n      \"mode\": \"managed\",\n      \"type\": \"null_resource\",\n      \"name\": \"test_%d\",\n      \"instances\": []\n    }", i+serial))
+		}
+		return fmt.Sprintf("{\n  \"version\": 4,\n  \"terraform_version\": \"1.10.0\",\n  \"serial\": %d,\n  \"lineage\": \"test_deltas\",\n  \"outputs\": {},\n  \"resources\": [\n%s\n  ]\n}\n", serial, strings.Join(resources, ",\n"))
+	}
+	countDeltas := func() int {
+		var n int
+		if err := db.QueryRow(
+			`SELECT COUNT(versions.id)
+               FROM versions
+               JOIN states ON states.id = versions.state_id
+               WHERE states.path = ? AND versions.base_id IS NOT NULL;`,
+			path).Scan(&n); err != nil {
+			t.Fatalf("failed to count deltas: %+v", err)
+		}
+		return n
+	}
+
+	for serial := 1; serial <= 8; serial++ {
+		runHTTPRequest("POST", true, &url.URL{Path: path}, strings.NewReader(state(serial)), func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to POST state serial %d: %+v", serial, err)
+			}
+		})
+		runHTTPRequest("GET", true, &url.URL{Path: path}, nil, func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to GET state serial %d: %+v", serial, err)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("failed to read response body: %+v", err)
+			}
+			if !bytes.Equal(body, []byte(state(serial))) {
+				t.Fatalf("GET should return the state serial %d that was just pushed", serial)
+			}
+		})
+		if serial == 2 && countDeltas() != 1 {
+			t.Fatalf("the second version should have been stored as a delta")
+		}
+	}
+	if countDeltas() == 0 {
+		t.Fatalf("some versions should be stored as deltas")
+	}
+
+	states, err := db.LoadStates()
+	if err != nil {
+		t.Fatalf("failed to load states: %+v", err)
+	}
+	for _, s := range states {
+		if s.Path != path {
+			continue
+		}
+		versions, err := db.LoadVersionsByState(&s)
+		if err != nil {
+			t.Fatalf("failed to load versions: %+v", err)
+		}
+		if len(versions) != 3 {
+			t.Fatalf("pruning should have kept 3 versions, got %d", len(versions))
+		}
+		// every surviving version must rebuild after its snapshot was pruned
+		for i, v := range versions {
+			version, err := db.LoadVersionById(v.Id)
+			if err != nil {
+				t.Fatalf("failed to load version %s: %+v", v.Id, err)
+			}
+			if !bytes.Equal(version.Data, []byte(state(8-i))) {
+				t.Fatalf("version %s should rebuild the state serial %d", v.Id, 8-i)
+			}
+		}
+		return
+	}
+	t.Fatalf("failed to find state %s", path)
+}
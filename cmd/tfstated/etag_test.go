@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestContentMD5AndETag(t *testing.T) {
+	adminPasswordMutex.Lock()
+	password := adminPassword
+	adminPasswordMutex.Unlock()
+
+	state1 := testState("test_etag", 1)
+	state2 := testState("test_etag", 2)
+	sum1 := md5.Sum([]byte(state1))
+	sum2 := md5.Sum([]byte(state2))
+	md51 := base64.StdEncoding.EncodeToString(sum1[:])
+	md52 := base64.StdEncoding.EncodeToString(sum2[:])
+	etag1 := `"` + hex.EncodeToString(sum1[:]) + `"`
+	etag2 := `"` + hex.EncodeToString(sum2[:]) + `"`
+
+	tests := []struct {
+		method string
+		body   string
+		header http.Header
+		expect string
+		status int
+		msg    string
+	}{
+		{"POST", state1, http.Header{"Content-MD5": {"invalid"}}, "", http.StatusBadRequest, "with an invalid Content-MD5 header"},
+		{"POST", state1, http.Header{"Content-MD5": {md52}}, "", http.StatusBadRequest, "with a mismatched Content-MD5 header"},
+		{"POST", state1, http.Header{"Content-MD5": {md51}}, "", http.StatusOK, "with a matching Content-MD5 header"},
+		{"GET", "", nil, etag1, http.StatusOK, "without If-None-Match"},
+		{"GET", "", http.Header{"If-None-Match": {etag1}}, "", http.StatusNotModified, "with a matching If-None-Match"},
+		{"GET", "", http.Header{"If-None-Match": {`"other", W/` + etag1}}, "", http.StatusNotModified, "with a matching weak If-None-Match in a list"},
+		{"GET", "", http.Header{"If-None-Match": {etag2}}, etag1, http.StatusOK, "with a mismatched If-None-Match"},
+		{"POST", state2, nil, "", http.StatusOK, "without a Content-MD5 header"},
+		{"GET", "", http.Header{"If-None-Match": {etag1}}, etag2, http.StatusOK, "with the If-None-Match of the previous version"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestWithHeaders(tt.method, "admin", password, &url.URL{Path: "/test_etag"}, strings.NewReader(tt.body), tt.header, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed %s with error: %+v", tt.method, err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("%s %s should %s, got %s", tt.method, tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			} else if tt.expect != "" {
+				if etag := r.Header.Get("ETag"); etag != tt.expect {
+					t.Fatalf("%s %s should have returned ETag %s, got %s", tt.method, tt.msg, tt.expect, etag)
+				}
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatalf("failed to read body with error: %+v", err)
+				}
+				sum := md5.Sum(body)
+				if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+					t.Fatalf("%s %s returned a Content-MD5 header %s not matching its body", tt.method, tt.msg, contentMD5)
+				}
+			}
+		})
+	}
+}
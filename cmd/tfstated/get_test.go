@@ -21,8 +21,8 @@ func TestGet(t *testing.T) {
 		{"GET", false, url.URL{Path: "/"}, nil, "", http.StatusUnauthorized, "/"},
 		{"GET", true, url.URL{Path: "/"}, nil, "", http.StatusBadRequest, "/"},
 		{"GET", true, url.URL{Path: "/non_existent_get"}, strings.NewReader(""), "", http.StatusOK, "non existent"},
-		{"POST", true, url.URL{Path: "/test_get"}, strings.NewReader("the_test_get"), "", http.StatusOK, "/test_get"},
-		{"GET", true, url.URL{Path: "/test_get"}, nil, "the_test_get", http.StatusOK, "/test_get"},
+		{"POST", true, url.URL{Path: "/test_get"}, strings.NewReader(testState("test_get", 1)), "", http.StatusOK, "/test_get"},
+		{"GET", true, url.URL{Path: "/test_get"}, nil, testState("test_get", 1), http.StatusOK, "/test_get"},
 	}
 	for _, tt := range tests {
 		runHTTPRequest(tt.method, tt.auth, &tt.uri, tt.body, func(r *http.Response, err error) {
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestLockExpiry(t *testing.T) {
+	path := "/test_lock_expiry"
+	var state *model.State
+	loadState := func() {
+		states, err := db.LoadStates()
+		if err != nil {
+			t.Fatalf("failed to load states: %+v", err)
+		}
+		for _, s := range states {
+			if s.Path == path {
+				state = &s
+				return
+			}
+		}
+		t.Fatalf("failed to find state %s", path)
+	}
+	setLockTTL := func(ttl time.Duration) {
+		loadState()
+		state.LockTTL = &ttl
+		if success, err := db.SaveState(state); err != nil || !success {
+			t.Fatalf("failed to save state: %+v", err)
+		}
+	}
+	expire := func() {
+		setLockTTL(time.Second)
+		time.Sleep(time.Second)
+	}
+	releaseExpiredLocks := func(expected int) func() {
+		return func() {
+			if n, err := db.ReleaseExpiredLocks(); err != nil {
+				t.Fatalf("failed to release expired locks: %+v", err)
+			} else if n != expected {
+				t.Fatalf("should have released %d expired locks, released %d", expected, n)
+			}
+		}
+	}
+
+	tests := []struct {
+		before func()
+		id     string
+		status int
+		msg    string
+	}{
+		{nil, "00000000-0000-0000-0000-000000000000", http.StatusOK, "on a non existent state"},
+		{nil, "00000000-0000-0000-0000-000000000000", http.StatusOK, "with the same ID should refresh the lock"},
+		{nil, "00000000-0000-0000-0000-000000000001", http.StatusConflict, "with another ID on a locked state"},
+		{expire, "00000000-0000-0000-0000-000000000001", http.StatusOK, "with another ID on an expired lock"},
+		{expire, "00000000-0000-0000-0000-000000000002", http.StatusOK, "with another ID on another expired lock"},
+		{func() { setLockTTL(time.Hour); releaseExpiredLocks(0)() }, "00000000-0000-0000-0000-000000000003", http.StatusConflict, "with another ID on a lock that did not expire"},
+		{func() { expire(); releaseExpiredLocks(1)() }, "00000000-0000-0000-0000-000000000003", http.StatusOK, "with another ID after the sweeper released the lock"},
+	}
+	for _, tt := range tests {
+		if tt.before != nil {
+			tt.before()
+		}
+		runHTTPRequest("LOCK", true, &url.URL{Path: path}, strings.NewReader("{\"ID\":\""+tt.id+"\"}"), func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed LOCK with error: %+v", err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("LOCK %s should %s, got %s", tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+		})
+	}
+
+	loadState()
+	releases, err := db.LoadLockReleasesByState(state)
+	if err != nil {
+		t.Fatalf("failed to load lock releases: %+v", err)
+	}
+	if len(releases) != 3 {
+		t.Fatalf("should have recorded 3 lock releases, got %d", len(releases))
+	}
+	for _, release := range releases {
+		if release.Reason != model.LockReleaseExpired || release.AccountId != nil {
+			t.Fatalf("unexpected lock release: %+v", release)
+		}
+	}
+	if releases[0].Lock.Id != "00000000-0000-0000-0000-000000000002" {
+		t.Fatalf("the last lock release should be for the last expired lock, got %s", releases[0].Lock.Id)
+	}
+}
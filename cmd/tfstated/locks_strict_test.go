@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/backend"
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+)
+
+// Strict locking runs against its own database and backend so that the other
+// tests cover the default behaviour
+func TestLocksStrict(t *testing.T) {
+	env := map[string]string{
+		"TFSTATED_DATA_ENCRYPTION_KEY": "hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM=",
+		"TFSTATED_HOST":                "127.0.0.1",
+		"TFSTATED_LOCKS_STRICT":        "true",
+		"TFSTATED_PORT":                "8085",
+		"TFSTATED_SESSIONS_SALT":       "a528D1m9q3IZxLinSmHmeKxrx3Pmm7GQ3nBzIDxjr0A=",
+	}
+	getenv := func(key string) string { return env[key] }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	strictDB, err := database.NewDB(ctx, filepath.Join(t.TempDir(), "strict.db"), getenv)
+	if err != nil {
+		t.Fatalf("failed to open database: %+v", err)
+	}
+	defer func() { _ = strictDB.Close() }()
+	account, err := strictDB.CreateAccount("strict", true)
+	if err != nil {
+		t.Fatalf("failed to create account: %+v", err)
+	}
+	account.SetPassword("strict_password")
+	if success, err := strictDB.SaveAccount(account); err != nil || !success {
+		t.Fatalf("failed to save account: %+v", err)
+	}
+	backendServer := backend.Run(ctx, cancel, strictDB, getenv)
+	defer func() { _ = backendServer.Shutdown(context.Background()) }()
+	if err := waitForReady(ctx, 5*time.Second, "http://127.0.0.1:8085/healthz"); err != nil {
+		t.Fatalf("backend is not ready: %+v", err)
+	}
+
+	strict := func(path string, query string) url.URL {
+		return url.URL{Scheme: "http", Host: "127.0.0.1:8085", Path: path, RawQuery: query}
+	}
+	lock := `{"ID":"00000000-0000-0000-0000-000000000000"}`
+	tests := []struct {
+		method string
+		uri    url.URL
+		body   io.Reader
+		expect string
+		status int
+		msg    string
+	}{
+		{"POST", strict("/test_strict", ""), strings.NewReader(testState("test_strict", 1)), "", http.StatusOK, "without lock ID on an unlocked state"},
+		{"LOCK", strict("/test_strict", ""), strings.NewReader(lock), "", http.StatusOK, "/test_strict"},
+		{"POST", strict("/test_strict", ""), strings.NewReader(testState("test_strict", 2)), "", http.StatusLocked, "without lock ID on a locked state"},
+		{"GET", strict("/test_strict", ""), nil, testState("test_strict", 1), http.StatusOK, "/test_strict"},
+		{"POST", strict("/test_strict", "ID=00000000-0000-0000-0000-000000000000"), strings.NewReader(testState("test_strict", 2)), "", http.StatusOK, "with a correct lock ID on a locked state"},
+		{"POST", strict("/test_strict", "force=true"), strings.NewReader(testState("test_strict", 3)), "", http.StatusOK, "with force on a locked state"},
+		{"GET", strict("/test_strict", ""), nil, testState("test_strict", 3), http.StatusOK, "/test_strict"},
+		{"DELETE", strict("/test_strict", ""), nil, "", http.StatusLocked, "without lock ID on a locked state"},
+		{"DELETE", strict("/test_strict", "ID=00000000-0000-0000-0000-000000000000"), nil, "", http.StatusOK, "with a correct lock ID on a locked state"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs(tt.method, "strict", "strict_password", &tt.uri, tt.body, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed %s with error: %+v", tt.method, err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("%s %s should %s, got %s", tt.method, tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			} else if tt.expect != "" {
+				if body, err := io.ReadAll(r.Body); err != nil {
+					t.Fatalf("failed to read body with error: %+v", err)
+				} else if string(body) != tt.expect {
+					t.Fatalf("%s should have returned \"%s\", got %s", tt.method, tt.expect, string(body))
+				}
+			}
+		})
+	}
+}
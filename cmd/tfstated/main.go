@@ -26,6 +26,11 @@ func run(
 	if err := db.InitAdminAccount(); err != nil {
 		return err
 	}
+	db.Start()
+	if db.Sealed() {
+		shares, threshold := db.UnsealProgress()
+		slog.Warn("tfstated is sealed, submit unseal shares through the webui", "shares", shares, "threshold", threshold)
+	}
 
 	backend := backend.Run(ctx, cancel, db, getenv)
 	webui := webui.Run(ctx, cancel, db, getenv)
@@ -64,6 +69,16 @@ func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, opts))
 	slog.SetDefault(logger)
 
+	if len(os.Args) > 1 {
+		if ok, err := runStandaloneCommand(os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr); ok {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%+v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	db, err := database.NewDB(
 		ctx,
 		"./tfstated.db?_txlock=immediate",
@@ -75,11 +90,23 @@ func main() {
 	}
 	defer db.Close()
 
-	if err := run(
-		ctx,
-		db,
-		os.Getenv,
-	); err != nil {
+	if len(os.Args) > 1 {
+		if db.Sealed() {
+			fmt.Fprintln(os.Stderr, "tfstated is sealed, enter unseal shares one per line")
+			if err = unsealLocal(db, os.Stdin); err != nil {
+				fmt.Fprintf(os.Stderr, "%+v\n", err)
+				os.Exit(1)
+			}
+		}
+		err = runCommand(db, os.Args[1:])
+	} else {
+		err = run(
+			ctx,
+			db,
+			os.Getenv,
+		)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
 		os.Exit(1)
 	}
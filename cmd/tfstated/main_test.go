@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
 var baseURI = url.URL{
@@ -38,6 +39,8 @@ func TestMain(m *testing.M) {
 			return "3"
 		case "TFSTATED_VERSIONS_HISTORY_MINIMUM_DAYS":
 			return "0"
+		case "TFSTATED_VERSIONS_SNAPSHOT_INTERVAL":
+			return "3"
 		default:
 			return ""
 		}
@@ -82,6 +85,22 @@ func TestMain(m *testing.M) {
 }
 
 func runHTTPRequest(method string, auth bool, uriRef *url.URL, body io.Reader, testFunc func(*http.Response, error)) {
+	username, password := "", ""
+	if auth {
+		adminPasswordMutex.Lock()
+		username, password = "admin", adminPassword
+		adminPasswordMutex.Unlock()
+	}
+	runHTTPRequestAs(method, username, password, uriRef, body, testFunc)
+}
+
+// runHTTPRequestAs authenticates the request with the provided credentials
+// unless the username is empty
+func runHTTPRequestAs(method string, username string, password string, uriRef *url.URL, body io.Reader, testFunc func(*http.Response, error)) {
+	runHTTPRequestWithHeaders(method, username, password, uriRef, body, nil, testFunc)
+}
+
+func runHTTPRequestWithHeaders(method string, username string, password string, uriRef *url.URL, body io.Reader, header http.Header, testFunc func(*http.Response, error)) {
 	uri := baseURI.ResolveReference(uriRef)
 	client := http.Client{}
 	req, err := http.NewRequest(method, uri.String(), body)
@@ -89,10 +108,11 @@ func runHTTPRequest(method string, auth bool, uriRef *url.URL, body io.Reader, t
 		testFunc(nil, fmt.Errorf("failed to create request: %w", err))
 		return
 	}
-	if auth {
-		adminPasswordMutex.Lock()
-		defer adminPasswordMutex.Unlock()
-		req.SetBasicAuth("admin", adminPassword)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
 	}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -103,6 +123,27 @@ func runHTTPRequest(method string, auth bool, uriRef *url.URL, body io.Reader, t
 	_ = resp.Body.Close()
 }
 
+// Returns a minimal valid state document
+func testState(lineage string, serial int) string {
+	return fmt.Sprintf(`{"version":4,"terraform_version":"1.10.0","serial":%d,"lineage":"%s","outputs":{},"resources":[],"check_results":null}`, serial, lineage)
+}
+
+// createTestAccount creates a non admin account with a known password
+func createTestAccount(t *testing.T, username string, password string) *model.Account {
+	account, err := db.CreateAccount(username, false)
+	if err != nil {
+		t.Fatalf("failed to create account %s: %+v", username, err)
+	}
+	if account == nil {
+		t.Fatalf("failed to create account %s: duplicate username", username)
+	}
+	account.SetPassword(password)
+	if success, err := db.SaveAccount(account); err != nil || !success {
+		t.Fatalf("failed to save account %s: %+v", username, err)
+	}
+	return account
+}
+
 // waitForReady calls the specified endpoint until it gets a 200
 // response or until the context is cancelled or the timeout is
 // reached.
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/mail/mailtest"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestEmailNotifications(t *testing.T) {
+	server := mailtest.NewServer()
+	defer server.Close()
+	db, err := database.NewDB(context.Background(), filepath.Join(t.TempDir(), "notifications.db"), func(key string) string {
+		switch key {
+		case "TFSTATED_DATA_ENCRYPTION_KEY":
+			return "hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM="
+		case "TFSTATED_LOCKS_STALE_AFTER":
+			return "1h"
+		case "TFSTATED_SESSIONS_SALT":
+			return "a528D1m9q3IZxLinSmHmeKxrx3Pmm7GQ3nBzIDxjr0A="
+		case "TFSTATED_SMTP_FROM":
+			return "tfstated <tfstated@example.com>"
+		case "TFSTATED_SMTP_HOST":
+			return server.Host
+		case "TFSTATED_SMTP_PORT":
+			return strconv.Itoa(server.Port)
+		case "TFSTATED_WEBUI_URL":
+			return "https://tfstated.example.com/"
+		default:
+			return ""
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %+v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close database: %+v", err)
+		}
+	}()
+	createAccount := func(username string, isAdmin bool, pathPrefix string) *model.Account {
+		account, err := db.CreateAccount(username, isAdmin)
+		if err != nil || account == nil {
+			t.Fatalf("failed to create account %s: %+v", username, err)
+		}
+		account.Email = username + "@example.com"
+		if success, err := db.SaveAccount(account); err != nil || !success {
+			t.Fatalf("failed to save account %s: %+v", username, err)
+		}
+		if _, err := db.CreateSubscription(account, pathPrefix); err != nil {
+			t.Fatalf("failed to subscribe account %s: %+v", username, err)
+		}
+		return account
+	}
+	admin := createAccount("admin", true, "/team")
+	reader := createAccount("reader", false, "/team/app")
+	if _, err := db.CreateGrant(&reader.Id, nil, "/team", model.PermissionRead); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+	createAccount("outsider", false, "/")
+
+	// the first attempts fail and are retried
+	server.SetReject(true)
+	if err := db.SetState("/team/app", admin.Id, []byte(testState("app", 1)), "", false); err != nil {
+		t.Fatalf("failed to set state: %+v", err)
+	}
+	if err := db.SetState("/other", admin.Id, []byte(testState("other", 1)), "", false); err != nil {
+		t.Fatalf("failed to set state: %+v", err)
+	}
+	lock := &model.Lock{Id: "00000000-0000-0000-0000-000000000001", Operation: "OperationTypeApply", Who: "reader@laptop"}
+	if locked, err := db.SetLockOrGetExistingLock("/team/app", lock); err != nil || !locked {
+		t.Fatalf("failed to lock state: %+v", err)
+	}
+	if n, err := db.NotifyStaleLocks(); err != nil || n != 0 {
+		t.Fatalf("got %d stale locks and error %+v, wanted none", n, err)
+	}
+	if _, err := db.Exec(`UPDATE states SET lock_acquired = ? WHERE path = '/team/app';`, time.Now().Add(-2*time.Hour).Unix()); err != nil {
+		t.Fatalf("failed to age lock: %+v", err)
+	}
+	for _, expected := range []int{1, 0} {
+		if n, err := db.NotifyStaleLocks(); err != nil || n != expected {
+			t.Fatalf("got %d stale locks and error %+v, wanted %d", n, err, expected)
+		}
+	}
+	state := &model.State{}
+	if version, err := db.GetState("/team/app"); err != nil {
+		t.Fatalf("failed to get state: %+v", err)
+	} else {
+		state.Id = version.StateId
+	}
+	if err := db.ForceUnlock(state, admin.Id); err != nil {
+		t.Fatalf("failed to force unlock: %+v", err)
+	}
+	if err := reader.ResetPassword(); err != nil {
+		t.Fatalf("failed to reset password: %+v", err)
+	}
+	if success, err := db.SaveAccount(reader); err != nil || !success {
+		t.Fatalf("failed to save account: %+v", err)
+	}
+	if err := db.SendPasswordResetEmail(reader); err != nil {
+		t.Fatalf("failed to send password reset email: %+v", err)
+	}
+	if n, err := db.DeliverEmails(); err != nil || n != 0 {
+		t.Fatalf("got %d sent emails and error %+v while the server rejects them", n, err)
+	}
+	server.SetReject(false)
+	if _, err := db.Exec(`UPDATE emails SET next_attempt = 0;`); err != nil {
+		t.Fatalf("failed to reschedule emails: %+v", err)
+	}
+	if _, err := db.DeliverEmails(); err != nil {
+		t.Fatalf("failed to deliver emails: %+v", err)
+	}
+
+	expected := []string{
+		"admin@example.com [tfstated] /team/app is locked for too long",
+		"admin@example.com [tfstated] Lock of /team/app was forced",
+		"admin@example.com [tfstated] New version of /team/app",
+		"reader@example.com [tfstated] /team/app is locked for too long",
+		"reader@example.com [tfstated] Lock of /team/app was forced",
+		"reader@example.com [tfstated] New version of /team/app",
+		"reader@example.com [tfstated] Password reset",
+	}
+	var got []string
+	bodies := make(map[string]string)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		got = got[:0]
+		for _, msg := range server.Messages() {
+			_, subject, _ := strings.Cut(msg.Data, "Subject: ")
+			subject, _, _ = strings.Cut(subject, "\r\n")
+			key := msg.To[0] + " " + subject
+			if !slices.Contains(got, key) {
+				got = append(got, key)
+			}
+			bodies[key] = msg.Data
+		}
+		slices.Sort(got)
+		if slices.Equal(got, expected) {
+			break
+		}
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("got emails %v, wanted %v", got, expected)
+	}
+	for key, want := range map[string]string{
+		"reader@example.com [tfstated] Lock of /team/app was forced":     "forcibly released by admin",
+		"reader@example.com [tfstated] New version of /team/app":         "https://tfstated.example.com/states/" + state.Id.String(),
+		"reader@example.com [tfstated] Password reset":                   "https://tfstated.example.com/accounts/" + reader.Id.String() + "/reset/" + reader.PasswordReset.String(),
+		"reader@example.com [tfstated] /team/app is locked for too long": "Who: reader@laptop",
+	} {
+		if !strings.Contains(bodies[key], want) {
+			t.Errorf("email %s should contain %q:\n%s", key, want, bodies[key])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestOutputs(t *testing.T) {
+	state := `{"version":4,"terraform_version":"1.10.0","serial":1,"lineage":"test_outputs",
+	  "outputs":{"vpc_id":{"value":"vpc-1","type":"string"},"token":{"value":"the-token","type":"string","sensitive":true}},
+	  "resources":[{"mode":"managed","type":"aws_vpc","name":"main","instances":[{"attributes":{"id":"vpc-1","secret":"the-attribute"}}]}],
+	  "check_results":null}`
+	runHTTPRequest("POST", true, &url.URL{Path: "/test_outputs/network"}, strings.NewReader(state), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to post state: %+v", err)
+		}
+	})
+	consumer := createTestAccount(t, "test_outputs_consumer", "consumer_password")
+	if _, err := db.CreateGrant(&consumer.Id, nil, "/test_outputs", model.PermissionOutputs); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+
+	tests := []struct {
+		uri     url.URL
+		status  int
+		outputs []string
+		msg     string
+	}{
+		{url.URL{Path: "/test_outputs/network", RawQuery: "outputs=true"}, http.StatusOK, []string{"token", "vpc_id"}, "with an outputs grant"},
+		{url.URL{Path: "/test_outputs/network", RawQuery: "outputs=true&exclude_sensitive=true"}, http.StatusOK, []string{"vpc_id"}, "excluding sensitive outputs"},
+		{url.URL{Path: "/test_outputs/network", RawQuery: "outputs=true&exclude_sensitive=maybe"}, http.StatusBadRequest, nil, "with an invalid exclude_sensitive parameter"},
+		{url.URL{Path: "/test_outputs/missing", RawQuery: "outputs=true"}, http.StatusOK, nil, "for a state that does not exist"},
+		{url.URL{Path: "/test_outputs/network"}, http.StatusForbidden, nil, "reading the whole state with only an outputs grant"},
+		{url.URL{Path: "/test_outputs/network", RawQuery: "outputs=false"}, http.StatusForbidden, nil, "reading the whole state with outputs=false"},
+		{url.URL{Path: "/test_outputs_other", RawQuery: "outputs=true"}, http.StatusForbidden, nil, "without any grant"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs("GET", "test_outputs_consumer", "consumer_password", &tt.uri, nil, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed GET %s with error: %+v", tt.uri.Path, err)
+			}
+			if r.StatusCode != tt.status {
+				t.Fatalf("GET %s %s should %s, got %s", tt.uri.Path, tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+			if tt.outputs == nil {
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("failed to read body %s: %+v", tt.msg, err)
+			}
+			var document struct {
+				Lineage   string                     `json:"lineage"`
+				Outputs   map[string]json.RawMessage `json:"outputs"`
+				Resources []json.RawMessage          `json:"resources"`
+			}
+			if err := json.Unmarshal(body, &document); err != nil {
+				t.Fatalf("failed to unmarshal body %s: %+v", tt.msg, err)
+			}
+			if document.Lineage != "test_outputs" || document.Resources == nil || len(document.Resources) != 0 {
+				t.Fatalf("got an unexpected document %s: %s", tt.msg, body)
+			}
+			if len(document.Outputs) != len(tt.outputs) {
+				t.Fatalf("got outputs %s %s, wanted %v", body, tt.msg, tt.outputs)
+			}
+			for _, name := range tt.outputs {
+				if _, ok := document.Outputs[name]; !ok {
+					t.Fatalf("missing output %s %s: %s", name, tt.msg, body)
+				}
+			}
+			if strings.Contains(string(body), "the-attribute") {
+				t.Fatalf("resource attributes should not be served %s: %s", tt.msg, body)
+			}
+		})
+	}
+	runHTTPRequest("GET", true, &url.URL{Path: "/test_outputs/network", RawQuery: "outputs=true"}, nil, func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("an admin should be able to read outputs: %+v", err)
+		}
+	})
+	runHTTPRequest("GET", true, &url.URL{Path: "/", RawQuery: "outputs=true"}, nil, func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusBadRequest {
+			t.Fatalf("reading outputs without a state path should fail: %+v", err)
+		}
+	})
+
+	// states under /outputs/ remain regular states
+	runHTTPRequest("POST", true, &url.URL{Path: "/outputs/test_outputs"}, strings.NewReader(testState("test_outputs_prefix", 1)), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to post a state under /outputs/: %+v", err)
+		}
+	})
+	runHTTPRequest("GET", true, &url.URL{Path: "/outputs/test_outputs"}, nil, func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get a state under /outputs/: %+v", err)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %+v", err)
+		}
+		if string(body) != testState("test_outputs_prefix", 1) {
+			t.Fatalf("a state under /outputs/ should be read back, got %s", body)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestPermissions(t *testing.T) {
+	alice := createTestAccount(t, "test_permissions_alice", "alice_password")
+	bob := createTestAccount(t, "test_permissions_bob", "bob_password")
+	group, err := db.CreateGroup("test_permissions_team")
+	if err != nil || group == nil {
+		t.Fatalf("failed to create group: %+v", err)
+	}
+	if err := db.AddGroupMember(group, bob.Id); err != nil {
+		t.Fatalf("failed to add group member: %+v", err)
+	}
+	grants := []struct {
+		account    *model.Account
+		pathPrefix string
+		permission model.Permission
+	}{
+		{alice, "/test_permissions/read", model.PermissionRead},
+		{alice, "/test_permissions/write", model.PermissionWrite},
+		{alice, "/test_permissions/reveal", model.PermissionReveal},
+		{nil, "/test_permissions/team", model.PermissionAdmin},
+	}
+	for _, g := range grants {
+		if g.account != nil {
+			_, err = db.CreateGrant(&g.account.Id, nil, g.pathPrefix, g.permission)
+		} else {
+			_, err = db.CreateGrant(nil, &group.Id, g.pathPrefix, g.permission)
+		}
+		if err != nil {
+			t.Fatalf("failed to create grant: %+v", err)
+		}
+	}
+
+	tests := []struct {
+		method   string
+		username string
+		password string
+		uri      url.URL
+		body     io.Reader
+		status   int
+		msg      string
+	}{
+		{"GET", "test_permissions_alice", "wrong_password", url.URL{Path: "/test_permissions/read"}, nil, http.StatusForbidden, "with a wrong password"},
+		{"GET", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/read"}, nil, http.StatusOK, "with a read grant"},
+		{"GET", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/read/sub"}, nil, http.StatusOK, "with a read grant on a parent path"},
+		{"GET", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/readme"}, nil, http.StatusForbidden, "with a read grant on a path sharing a prefix"},
+		{"POST", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/read"}, strings.NewReader(testState("test_permissions", 1)), http.StatusForbidden, "with only a read grant"},
+		{"LOCK", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/read"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), http.StatusForbidden, "with only a read grant"},
+		{"POST", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/write"}, strings.NewReader(testState("test_permissions", 1)), http.StatusOK, "with a write grant"},
+		{"GET", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/write"}, nil, http.StatusOK, "with a write grant implying read"},
+		{"LOCK", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/write"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), http.StatusOK, "with a write grant implying lock"},
+		{"POST", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/write", RawQuery: "force=true"}, strings.NewReader(testState("test_permissions", 1)), http.StatusForbidden, "with force without an admin grant"},
+		{"UNLOCK", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/write"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), http.StatusOK, "with a write grant implying lock"},
+		{"DELETE", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/write"}, nil, http.StatusForbidden, "without a delete grant"},
+		{"GET", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/team"}, nil, http.StatusForbidden, "without any grant"},
+		{"GET", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/reveal"}, nil, http.StatusOK, "with a reveal grant implying read"},
+		{"POST", "test_permissions_alice", "alice_password", url.URL{Path: "/test_permissions/reveal"}, strings.NewReader(testState("test_permissions", 1)), http.StatusForbidden, "with only a reveal grant"},
+		{"POST", "test_permissions_bob", "bob_password", url.URL{Path: "/test_permissions/team/state"}, strings.NewReader(testState("test_permissions", 1)), http.StatusOK, "with an admin grant through a group"},
+		{"LOCK", "test_permissions_bob", "bob_password", url.URL{Path: "/test_permissions/team/state"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), http.StatusOK, "with an admin grant through a group"},
+		{"POST", "test_permissions_bob", "bob_password", url.URL{Path: "/test_permissions/team/state", RawQuery: "force=true"}, strings.NewReader(testState("test_permissions", 1)), http.StatusOK, "with force and an admin grant through a group"},
+		{"DELETE", "test_permissions_bob", "bob_password", url.URL{Path: "/test_permissions/team/state", RawQuery: "force=true"}, nil, http.StatusOK, "with force and an admin grant through a group"},
+		{"GET", "test_permissions_bob", "bob_password", url.URL{Path: "/test_permissions/read"}, nil, http.StatusForbidden, "without any grant"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs(tt.method, tt.username, tt.password, &tt.uri, tt.body, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed %s with error: %+v", tt.method, err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("%s %s %s should %s, got %s", tt.method, tt.uri.Path, tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+		})
+	}
+	for _, tt := range []struct {
+		path    string
+		allowed bool
+	}{
+		{"/test_permissions/reveal", true},
+		{"/test_permissions/read", false},
+		{"/test_permissions/write", false},
+	} {
+		allowed, err := db.CheckPermission(alice, tt.path, model.PermissionReveal)
+		if err != nil || allowed != tt.allowed {
+			t.Fatalf("the reveal permission on %s should be %t, got %t and %+v", tt.path, tt.allowed, allowed, err)
+		}
+	}
+}
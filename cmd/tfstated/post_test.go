@@ -21,20 +21,30 @@ func TestPost(t *testing.T) {
 		{"POST", false, url.URL{Path: "/"}, nil, "", http.StatusUnauthorized, "/"},
 		{"POST", true, url.URL{Path: "/"}, nil, "", http.StatusBadRequest, "/"},
 		{"POST", true, url.URL{Path: "/test_post"}, nil, "", http.StatusBadRequest, "without a body"},
-		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader("the_test_post"), "", http.StatusOK, "without lock ID in query string"},
-		{"GET", true, url.URL{Path: "/test_post"}, nil, "the_test_post", http.StatusOK, "/test_post"},
-		{"POST", true, url.URL{Path: "/test_post", RawQuery: "ID=00000000-0000-0000-0000-000000000000"}, strings.NewReader("the_test_post2"), "", http.StatusConflict, "with a lock ID on an unlocked state"},
-		{"GET", true, url.URL{Path: "/test_post"}, nil, "the_test_post", http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("test_post", 1)), "", http.StatusOK, "without lock ID in query string"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("test_post", 1), http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post", RawQuery: "ID=00000000-0000-0000-0000-000000000000"}, strings.NewReader(testState("test_post", 2)), "", http.StatusConflict, "with a lock ID on an unlocked state"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("test_post", 1), http.StatusOK, "/test_post"},
 		{"LOCK", true, url.URL{Path: "/test_post"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), "", http.StatusOK, "/test_post"},
-		{"POST", true, url.URL{Path: "/test_post", RawQuery: "ID=ffffffff-ffff-ffff-ffff-ffffffffffff"}, strings.NewReader("the_test_post3"), "", http.StatusConflict, "with a wrong lock ID on a locked state"},
-		{"GET", true, url.URL{Path: "/test_post"}, nil, "the_test_post", http.StatusOK, "/test_post"},
-		{"POST", true, url.URL{Path: "/test_post", RawQuery: "ID=00000000-0000-0000-0000-000000000000"}, strings.NewReader("the_test_post4"), "", http.StatusOK, "with a correct lock ID on a locked state"},
-		{"GET", true, url.URL{Path: "/test_post"}, nil, "the_test_post4", http.StatusOK, "/test_post"},
-		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader("the_test_post5"), "", http.StatusOK, "without lock ID in query string on a locked state"},
-		{"GET", true, url.URL{Path: "/test_post"}, nil, "the_test_post5", http.StatusOK, "/test_post"},
-		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader("the_test_post6"), "", http.StatusOK, "another post just to make sure the history limit works"},
-		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader("the_test_post7"), "", http.StatusOK, "another post just to make sure the history limit works"},
-		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader("the_test_post8"), "", http.StatusOK, "another post just to make sure the history limit works"},
+		{"POST", true, url.URL{Path: "/test_post", RawQuery: "ID=ffffffff-ffff-ffff-ffff-ffffffffffff"}, strings.NewReader(testState("test_post", 3)), "", http.StatusConflict, "with a wrong lock ID on a locked state"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("test_post", 1), http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post", RawQuery: "ID=00000000-0000-0000-0000-000000000000"}, strings.NewReader(testState("test_post", 4)), "", http.StatusOK, "with a correct lock ID on a locked state"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("test_post", 4), http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("test_post", 5)), "", http.StatusOK, "without lock ID in query string on a locked state"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("test_post", 5), http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post", RawQuery: "force=invalid"}, strings.NewReader(testState("test_post", 5)), "", http.StatusBadRequest, "with an invalid force parameter"},
+		{"UNLOCK", true, url.URL{Path: "/test_post"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), "", http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader("the_test_post"), "", http.StatusBadRequest, "with a body that is not a state"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("test_post", 5)), "", http.StatusOK, "with the current version"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("test_post", 4)), "", http.StatusConflict, "with a lower serial"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(strings.Replace(testState("test_post", 5), "1.10.0", "1.10.1", 1)), "", http.StatusConflict, "with the same serial and a different content"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("another_lineage", 6)), "", http.StatusConflict, "with a different lineage"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("test_post", 5), http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post", RawQuery: "force=true"}, strings.NewReader(testState("another_lineage", 1)), "", http.StatusOK, "with force and a different lineage"},
+		{"GET", true, url.URL{Path: "/test_post"}, nil, testState("another_lineage", 1), http.StatusOK, "/test_post"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("another_lineage", 2)), "", http.StatusOK, "another post just to make sure the history limit works"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("another_lineage", 3)), "", http.StatusOK, "another post just to make sure the history limit works"},
+		{"POST", true, url.URL{Path: "/test_post"}, strings.NewReader(testState("another_lineage", 4)), "", http.StatusOK, "another post just to make sure the history limit works"},
 	}
 	for _, tt := range tests {
 		runHTTPRequest(tt.method, tt.auth, &tt.uri, tt.body, func(r *http.Response, err error) {
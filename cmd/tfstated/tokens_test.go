@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+func TestTokens(t *testing.T) {
+	account := createTestAccount(t, "test_tokens", "tokens_password")
+	if _, err := db.CreateGrant(&account.Id, nil, "/test_tokens", model.PermissionWrite); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+	readToken, _, err := db.CreateToken(account, "read", model.PermissionRead, nil)
+	if err != nil {
+		t.Fatalf("failed to create token: %+v", err)
+	}
+	writeToken, _, err := db.CreateToken(account, "write", model.PermissionAdmin, nil)
+	if err != nil {
+		t.Fatalf("failed to create token: %+v", err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	expiredToken, _, err := db.CreateToken(account, "expired", model.PermissionAdmin, &expired)
+	if err != nil {
+		t.Fatalf("failed to create token: %+v", err)
+	}
+	revokedToken, revoked, err := db.CreateToken(account, "revoked", model.PermissionAdmin, nil)
+	if err != nil {
+		t.Fatalf("failed to create token: %+v", err)
+	}
+	if _, err := db.DeleteToken(account, revoked.Id); err != nil {
+		t.Fatalf("failed to delete token: %+v", err)
+	}
+	if duplicate, _, err := db.CreateToken(account, "read", model.PermissionRead, nil); err != nil || duplicate != "" {
+		t.Fatalf("creating a token with a duplicate name should fail without error, got %+v", err)
+	}
+
+	tests := []struct {
+		method   string
+		password string
+		uri      url.URL
+		body     io.Reader
+		status   int
+		msg      string
+	}{
+		{"POST", writeToken, url.URL{Path: "/test_tokens"}, strings.NewReader(testState("test_tokens", 1)), http.StatusOK, "with a token scope wider than the account grants"},
+		{"DELETE", writeToken, url.URL{Path: "/test_tokens"}, nil, http.StatusForbidden, "with a token scope wider than the account grants"},
+		{"GET", readToken, url.URL{Path: "/test_tokens"}, nil, http.StatusOK, "with a read token"},
+		{"POST", readToken, url.URL{Path: "/test_tokens"}, strings.NewReader(testState("test_tokens", 2)), http.StatusForbidden, "with a read token"},
+		{"GET", readToken + "x", url.URL{Path: "/test_tokens"}, nil, http.StatusForbidden, "with an invalid token"},
+		{"GET", expiredToken, url.URL{Path: "/test_tokens"}, nil, http.StatusForbidden, "with an expired token"},
+		{"GET", revokedToken, url.URL{Path: "/test_tokens"}, nil, http.StatusForbidden, "with a revoked token"},
+		{"GET", "tokens_password", url.URL{Path: "/test_tokens"}, nil, http.StatusOK, "with the account password"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs(tt.method, "test_tokens", tt.password, &tt.uri, tt.body, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed %s with error: %+v", tt.method, err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("%s %s should %s, got %s", tt.method, tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+		})
+	}
+	if err := db.FlushTouches(); err != nil {
+		t.Fatalf("failed to flush last used timestamps: %+v", err)
+	}
+	tokens, err := db.LoadTokensByAccount(account)
+	if err != nil {
+		t.Fatalf("failed to load tokens: %+v", err)
+	}
+	for _, token := range tokens {
+		if token.Name == "read" && token.LastUsed == nil {
+			t.Fatalf("the last used timestamp of the read token should have been set")
+		}
+	}
+}
+
+func TestTokensPrefixedPassword(t *testing.T) {
+	account := createTestAccount(t, "test_tokens_prefix", model.TokenPrefix+"password")
+	if _, err := db.CreateGrant(&account.Id, nil, "/test_tokens_prefix", model.PermissionRead); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+	tests := []struct {
+		password string
+		status   int
+		msg      string
+	}{
+		{model.TokenPrefix + "password", http.StatusOK, "with a password which starts like a token secret"},
+		{model.TokenPrefix + "wrong_password", http.StatusForbidden, "with a wrong password which starts like a token secret"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs("GET", "test_tokens_prefix", tt.password, &url.URL{Path: "/test_tokens_prefix"}, nil, func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed GET with error: %+v", err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("GET %s should %s, got %s", tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+		})
+	}
+}
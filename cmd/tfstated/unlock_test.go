@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
 func TestUnlock(t *testing.T) {
@@ -20,7 +22,8 @@ func TestUnlock(t *testing.T) {
 	}{
 		{"UNLOCK", false, url.URL{Path: "/"}, nil, "", http.StatusUnauthorized, "/"},
 		{"UNLOCK", true, url.URL{Path: "/"}, nil, "", http.StatusBadRequest, "/"},
-		{"UNLOCK", true, url.URL{Path: "/non_existent_lock"}, nil, "", http.StatusBadRequest, "no lock data on non existent state"},
+		{"UNLOCK", true, url.URL{Path: "/non_existent_lock"}, nil, "", http.StatusConflict, "no lock data on non existent state"},
+		{"UNLOCK", true, url.URL{Path: "/non_existent_lock"}, strings.NewReader("{"), "", http.StatusBadRequest, "invalid lock data on non existent state"},
 		{"UNLOCK", true, url.URL{Path: "/non_existent_lock"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), "", http.StatusConflict, "valid lock data on non existent state"},
 		{"LOCK", true, url.URL{Path: "/test_unlock"}, strings.NewReader("{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"), "", http.StatusOK, "valid lock data on non existent state should create it empty"},
 		{"UNLOCK", true, url.URL{Path: "/test_unlock"}, strings.NewReader("{\"ID\":\"FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF\"}"), "", http.StatusConflict, "valid but wrong lock data on a locked state"},
@@ -43,3 +46,73 @@ func TestUnlock(t *testing.T) {
 		})
 	}
 }
+
+func TestForceUnlock(t *testing.T) {
+	account := createTestAccount(t, "test_force_unlock", "force_unlock_password")
+	if _, err := db.CreateGrant(&account.Id, nil, "/test_force_unlock", model.PermissionLock); err != nil {
+		t.Fatalf("failed to create grant: %+v", err)
+	}
+	admin, err := db.LoadAccountByUsername("admin")
+	if err != nil || admin == nil {
+		t.Fatalf("failed to load admin account: %+v", err)
+	}
+	adminPasswordMutex.Lock()
+	password := adminPassword
+	adminPasswordMutex.Unlock()
+
+	lock := "{\"ID\":\"00000000-0000-0000-0000-000000000000\",\"Operation\":\"OperationTypeApply\",\"Who\":\"runner@ci\"}"
+	idOnly := "{\"ID\":\"00000000-0000-0000-0000-000000000000\"}"
+	tests := []struct {
+		method   string
+		username string
+		password string
+		body     string
+		status   int
+		msg      string
+	}{
+		{"LOCK", "test_force_unlock", "force_unlock_password", lock, http.StatusOK, "with a lock grant"},
+		{"UNLOCK", "test_force_unlock", "force_unlock_password", idOnly, http.StatusForbidden, "with only the lock ID and without a write grant"},
+		{"UNLOCK", "admin", password, "{\"ID\":\"ffffffff-ffff-ffff-ffff-ffffffffffff\"}", http.StatusConflict, "with only a wrong lock ID"},
+		{"UNLOCK", "admin", password, idOnly, http.StatusOK, "with only the lock ID"},
+		{"UNLOCK", "admin", password, idOnly, http.StatusConflict, "with only the lock ID on a now unlocked state"},
+		{"LOCK", "test_force_unlock", "force_unlock_password", lock, http.StatusOK, "with a lock grant"},
+		{"UNLOCK", "test_force_unlock", "force_unlock_password", "", http.StatusForbidden, "without a body and without a write grant"},
+		{"UNLOCK", "admin", password, "", http.StatusOK, "without a body"},
+		{"UNLOCK", "admin", password, "", http.StatusConflict, "without a body on a now unlocked state"},
+		{"LOCK", "test_force_unlock", "force_unlock_password", lock, http.StatusOK, "with a lock grant"},
+		{"UNLOCK", "test_force_unlock", "force_unlock_password", lock, http.StatusOK, "with the complete lock"},
+	}
+	for _, tt := range tests {
+		runHTTPRequestAs(tt.method, tt.username, tt.password, &url.URL{Path: "/test_force_unlock"}, strings.NewReader(tt.body), func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed %s with error: %+v", tt.method, err)
+			} else if r.StatusCode != tt.status {
+				t.Fatalf("%s %s should %s, got %s", tt.method, tt.msg, http.StatusText(tt.status), http.StatusText(r.StatusCode))
+			}
+		})
+	}
+
+	states, err := db.LoadStates()
+	if err != nil {
+		t.Fatalf("failed to load states: %+v", err)
+	}
+	for _, state := range states {
+		if state.Path != "/test_force_unlock" {
+			continue
+		}
+		releases, err := db.LoadLockReleasesByState(&state)
+		if err != nil {
+			t.Fatalf("failed to load lock releases: %+v", err)
+		}
+		if len(releases) != 2 {
+			t.Fatalf("should have recorded 2 lock releases, got %d", len(releases))
+		}
+		for _, release := range releases {
+			if release.Reason != model.LockReleaseForced || release.AccountId == nil || !release.AccountId.Equal(admin.Id) || release.Lock.Who != "runner@ci" {
+				t.Fatalf("unexpected lock release: %+v", release)
+			}
+		}
+		return
+	}
+	t.Fatalf("failed to find state /test_force_unlock")
+}
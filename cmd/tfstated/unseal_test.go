@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/backend"
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/webui"
+)
+
+func TestUnseal(t *testing.T) {
+	const (
+		key      = "J3gk9iGqPBn0Wqz+XvQ4B1p2mJ5Yx8cK0c3dO6s1uRM="
+		otherKey = "hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM="
+	)
+	path := filepath.Join(t.TempDir(), "unseal.db")
+	env := map[string]string{
+		"TFSTATED_HOST":          "127.0.0.1",
+		"TFSTATED_PORT":          "8083",
+		"TFSTATED_SESSIONS_SALT": "a528D1m9q3IZxLinSmHmeKxrx3Pmm7GQ3nBzIDxjr0A=",
+		"TFSTATED_WEBUI_PORT":    "8084",
+	}
+	getenv := func(key string) string { return env[key] }
+	splitKey := func(key string) ([]string, string) {
+		var stdout, stderr bytes.Buffer
+		ok, err := runStandaloneCommand([]string{"split-key", "3", "2"}, getenv, strings.NewReader(key+"\n"), &stdout, &stderr)
+		if !ok || err != nil {
+			t.Fatalf("failed to split key: %+v", err)
+		}
+		shares := strings.Fields(stdout.String())
+		if len(shares) != 3 {
+			t.Fatalf("expected 3 shares, got %d", len(shares))
+		}
+		keyCheck, ok := strings.CutPrefix(strings.TrimSpace(stderr.String()), "TFSTATED_UNSEAL_KEY_CHECK=")
+		if !ok {
+			t.Fatalf("expected the key check value, got %s", stderr.String())
+		}
+		return shares, keyCheck
+	}
+	shares, keyCheck := splitKey(key)
+	otherShares, _ := splitKey(otherKey)
+
+	env["TFSTATED_DATA_ENCRYPTION_KEY"] = key
+	before, err := database.NewDB(context.Background(), path, getenv)
+	if err != nil {
+		t.Fatalf("failed to open database: %+v", err)
+	}
+	account, err := before.CreateAccount("unseal", true)
+	if err != nil {
+		t.Fatalf("failed to create account: %+v", err)
+	}
+	account.SetPassword("unseal_password")
+	if success, err := before.SaveAccount(account); err != nil || !success {
+		t.Fatalf("failed to save account: %+v", err)
+	}
+	if err := before.SetState("/unseal", account.Id, []byte(testState("unseal", 1)), "", false); err != nil {
+		t.Fatalf("failed to set state: %+v", err)
+	}
+	if err := before.Close(); err != nil {
+		t.Fatalf("failed to close database: %+v", err)
+	}
+
+	env["TFSTATED_UNSEAL_THRESHOLD"] = "2"
+	if _, err := database.NewDB(context.Background(), path, getenv); err == nil {
+		t.Fatal("opening the database sealed with another data encryption key configured should fail")
+	}
+	delete(env, "TFSTATED_DATA_ENCRYPTION_KEY")
+	if _, err := database.NewDB(context.Background(), path, getenv); err == nil {
+		t.Fatal("opening the database sealed without a key check value should fail")
+	}
+	env["TFSTATED_UNSEAL_KEY_CHECK"] = keyCheck
+	sealed, err := database.NewDB(context.Background(), path, getenv)
+	if err != nil {
+		t.Fatalf("failed to open sealed database: %+v", err)
+	}
+	defer func() { _ = sealed.Close() }()
+	if !sealed.Sealed() {
+		t.Fatal("database should start sealed")
+	}
+	if _, err := sealed.GetState("/unseal"); !errors.Is(err, database.ErrSealed) {
+		t.Fatalf("getting a state while sealed should fail with ErrSealed, got %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backendServer := backend.Run(ctx, cancel, sealed, getenv)
+	webuiServer := webui.Run(ctx, cancel, sealed, getenv)
+	defer func() {
+		_ = backendServer.Shutdown(context.Background())
+		_ = webuiServer.Shutdown(context.Background())
+	}()
+	if err := waitForReady(ctx, 5*time.Second, "http://127.0.0.1:8083/healthz"); err != nil {
+		t.Fatalf("backend is not ready: %+v", err)
+	}
+	if err := waitForReady(ctx, 5*time.Second, "http://127.0.0.1:8084/healthz"); err != nil {
+		t.Fatalf("webui is not ready: %+v", err)
+	}
+	getState := func() int {
+		req, err := http.NewRequest("GET", "http://127.0.0.1:8083/unseal", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %+v", err)
+		}
+		req.SetBasicAuth("unseal", "unseal_password")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to do request: %+v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := getState(); status != http.StatusServiceUnavailable {
+		t.Fatalf("the backend should answer 503 while sealed, got %d", status)
+	}
+	postShare := func(contentType string, body string, auth bool) int {
+		req, err := http.NewRequest("POST", "http://127.0.0.1:8084/api/unseal", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %+v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		if auth {
+			req.SetBasicAuth("unseal", "unseal_password")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to do request: %+v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := postShare("application/json", `{"share":"`+otherShares[0]+`"}`, false); status != http.StatusUnauthorized {
+		t.Fatalf("anonymous submissions to the unseal api should be refused, got %d", status)
+	}
+	if status := postShare("application/x-www-form-urlencoded", "share="+shares[0], true); status != http.StatusUnsupportedMediaType {
+		t.Fatalf("form submissions to the unseal api should be refused, got %d", status)
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get("http://127.0.0.1:8084/unseal")
+	if err != nil {
+		t.Fatalf("failed to do request: %+v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Fatalf("the unseal form should require a login, got %d to %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	webuiURL := "http://127.0.0.1:8084"
+	var stdout bytes.Buffer
+	if ok, err := runStandaloneCommand([]string{"unseal", webuiURL}, getenv, strings.NewReader(shares[0]+"\n"), &stdout, io.Discard); !ok || err == nil {
+		t.Fatal("submitting shares without credentials should fail")
+	}
+	env["TFSTATED_UNSEAL_USERNAME"] = "unseal"
+	env["TFSTATED_UNSEAL_PASSWORD"] = "unseal_password"
+	if ok, err := runStandaloneCommand([]string{"unseal", webuiURL}, getenv, strings.NewReader(shares[0]+"\n"+shares[0]+"\n"), &stdout, io.Discard); !ok || err == nil {
+		t.Fatal("submitting the same share twice should fail")
+	}
+	if _, err := sealed.SubmitUnsealShare(otherShares[1]); !errors.Is(err, database.ErrUnsealFailed) {
+		t.Fatalf("shares of another key should fail to unseal, got %+v", err)
+	}
+	if _, err := sealed.SubmitUnsealShare(otherShares[0]); !errors.Is(err, database.ErrUnsealFailed) {
+		t.Fatalf("enough shares of another key should fail the key check, got %+v", err)
+	}
+	if !sealed.Sealed() {
+		t.Fatal("shares of another key should not unseal")
+	}
+	if shares, _ := sealed.UnsealProgress(); shares != 3 {
+		t.Fatalf("a failed unseal should keep the submitted shares, got %d", shares)
+	}
+	if _, err := sealed.SubmitUnsealShare("invalid"); !errors.Is(err, database.ErrUnsealFailed) {
+		t.Fatalf("invalid shares should fail to unseal, got %+v", err)
+	}
+	stdout.Reset()
+	if ok, err := runStandaloneCommand([]string{"unseal", webuiURL}, getenv, strings.NewReader(shares[2]+"\n"+shares[1]+"\n"), &stdout, io.Discard); !ok || err != nil {
+		t.Fatalf("failed to unseal: %+v", err)
+	}
+	if !strings.Contains(stdout.String(), "unsealed") {
+		t.Fatalf("unexpected unseal output: %s", stdout.String())
+	}
+	if sealed.Sealed() {
+		t.Fatal("database should be unsealed")
+	}
+	if status := getState(); status != http.StatusOK {
+		t.Fatalf("the backend should serve states once unsealed, got %d", status)
+	}
+	version, err := sealed.GetState("/unseal")
+	if err != nil {
+		t.Fatalf("failed to get state: %+v", err)
+	}
+	if string(version.Data) != testState("unseal", 1) {
+		t.Fatalf("got an unexpected state: %s", string(version.Data))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/tfstate"
+)
+
+func TestPreviousVersion(t *testing.T) {
+	for serial := 1; serial <= 2; serial++ {
+		runHTTPRequest("POST", true, &url.URL{Path: "/test_previous_version"}, strings.NewReader(testState("test_previous_version", serial)), func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to post version %d: %+v", serial, err)
+			}
+		})
+	}
+	states, err := db.LoadStates()
+	if err != nil {
+		t.Fatalf("failed to load states: %+v", err)
+	}
+	var state *model.State
+	for i := range states {
+		if states[i].Path == "/test_previous_version" {
+			state = &states[i]
+		}
+	}
+	if state == nil {
+		t.Fatal("failed to find state")
+	}
+	versions, err := db.LoadVersionsByState(state)
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("failed to load the two versions: %+v, %+v", versions, err)
+	}
+	previous, err := db.LoadPreviousVersion(&versions[0])
+	if err != nil {
+		t.Fatalf("failed to load the previous version: %+v", err)
+	}
+	if previous == nil || previous.Id != versions[1].Id {
+		t.Fatalf("got previous version %+v, wanted %s", previous, versions[1].Id)
+	}
+	after, err := db.LoadVersionById(versions[0].Id)
+	if err != nil {
+		t.Fatalf("failed to load the latest version: %+v", err)
+	}
+	for _, data := range [][]byte{previous.Data, after.Data} {
+		if _, err := tfstate.ParseDocument(data); err != nil {
+			t.Fatalf("failed to parse version data: %+v", err)
+		}
+	}
+	if previous, err = db.LoadPreviousVersion(&versions[1]); err != nil || previous != nil {
+		t.Fatalf("the first version should have no previous version, got %+v, %+v", previous, err)
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	for serial := 1; serial <= 3; serial++ {
+		runHTTPRequest("POST", true, &url.URL{Path: "/test_restore_version"}, strings.NewReader(testState("test_restore_version", serial)), func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to post version %d: %+v", serial, err)
+			}
+		})
+	}
+	states, err := db.LoadStates()
+	if err != nil {
+		t.Fatalf("failed to load states: %+v", err)
+	}
+	var state *model.State
+	for i := range states {
+		if states[i].Path == "/test_restore_version" {
+			state = &states[i]
+		}
+	}
+	if state == nil {
+		t.Fatal("failed to find state")
+	}
+	versions, err := db.LoadVersionsByState(state)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("failed to load the three versions: %+v, %+v", versions, err)
+	}
+	first, err := db.LoadVersionById(versions[2].Id)
+	if err != nil {
+		t.Fatalf("failed to load the first version: %+v", err)
+	}
+	restorer := createTestAccount(t, "test_restore_version", "restorer_password")
+
+	lock := `{"ID":"00000000-0000-0000-0000-000000000000"}`
+	runHTTPRequest("LOCK", true, &url.URL{Path: "/test_restore_version"}, strings.NewReader(lock), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to lock the state: %+v", err)
+		}
+	})
+	if err := db.RestoreVersion(first, restorer.Id); !errors.Is(err, database.ErrStateLocked) {
+		t.Fatalf("restoring a version of a locked state should fail with ErrStateLocked, got %+v", err)
+	}
+	runHTTPRequest("UNLOCK", true, &url.URL{Path: "/test_restore_version"}, strings.NewReader(lock), func(r *http.Response, err error) {
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Fatalf("failed to unlock the state: %+v", err)
+		}
+	})
+
+	if err := db.RestoreVersion(first, restorer.Id); err != nil {
+		t.Fatalf("failed to restore the first version: %+v", err)
+	}
+	current, err := db.GetState("/test_restore_version")
+	if err != nil || current == nil {
+		t.Fatalf("failed to get the current version: %+v", err)
+	}
+	if current.AccountId != restorer.Id {
+		t.Errorf("the restored version should be attributed to the restoring account, got %s", current.AccountId)
+	}
+	restored, err := tfstate.Parse(current.Data)
+	if err != nil {
+		t.Fatalf("failed to parse the restored version: %+v", err)
+	}
+	if restored.Lineage != "test_restore_version" || *restored.Serial != 4 {
+		t.Errorf("got lineage %s and serial %d, wanted test_restore_version and 4", restored.Lineage, *restored.Serial)
+	}
+	expected, err := tfstate.SetSerial(first.Data, 4)
+	if err != nil {
+		t.Fatalf("failed to set the serial of the first version: %+v", err)
+	}
+	if !bytes.Equal(current.Data, expected) {
+		t.Errorf("the restored version should hold the first version with a new serial, got %s", current.Data)
+	}
+}
+
+func TestPinnedVersions(t *testing.T) {
+	post := func(serial int) {
+		runHTTPRequest("POST", true, &url.URL{Path: "/test_pinned_versions"}, strings.NewReader(testState("test_pinned_versions", serial)), func(r *http.Response, err error) {
+			if err != nil || r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to post version %d: %+v", serial, err)
+			}
+		})
+	}
+	post(1)
+	post(2)
+	states, err := db.LoadStates()
+	if err != nil {
+		t.Fatalf("failed to load states: %+v", err)
+	}
+	var state *model.State
+	for i := range states {
+		if states[i].Path == "/test_pinned_versions" {
+			state = &states[i]
+		}
+	}
+	if state == nil {
+		t.Fatal("failed to find state")
+	}
+	versions, err := db.LoadVersionsByState(state)
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("failed to load the two versions: %+v, %+v", versions, err)
+	}
+	first, second := &versions[1], &versions[0]
+	pinner := createTestAccount(t, "test_pinned_versions", "pinner_password")
+	if err := db.PinVersion(first, pinner.Id, "baseline"); err != nil {
+		t.Fatalf("failed to pin the first version: %+v", err)
+	}
+	if err := db.PinVersion(second, pinner.Id, "pre-migration baseline"); err != nil {
+		t.Fatalf("failed to pin the second version: %+v", err)
+	}
+	check := func(msg string, count int) {
+		versions, err := db.LoadVersionsByState(state)
+		if err != nil || len(versions) != count {
+			t.Fatalf("there should be %d versions %s, got %+v, %+v", count, msg, versions, err)
+		}
+		report, err := db.VerifyChain(state)
+		if err != nil {
+			t.Fatalf("failed to verify chain %s: %+v", msg, err)
+		}
+		if !report.Valid() {
+			t.Fatalf("the chain should remain valid %s, got %+v", msg, report)
+		}
+		pinned, err := db.LoadVersionById(second.Id)
+		if err != nil || pinned == nil {
+			t.Fatalf("the pinned version should survive %s: %+v", msg, err)
+		}
+		if pinned.PinnedAt == nil || pinned.PinnedBy == nil || *pinned.PinnedBy != pinner.Id || pinned.PinnedReason != "pre-migration baseline" {
+			t.Errorf("the pin of the version should be loaded %s, got %+v", msg, pinned)
+		}
+		data, err := tfstate.Parse(pinned.Data)
+		if err != nil || *data.Serial != 2 {
+			t.Errorf("the pinned version should remain readable %s: %+v", msg, err)
+		}
+	}
+	for serial := 3; serial <= 6; serial++ {
+		post(serial)
+	}
+	check("after pruning around pinned versions", 5)
+
+	if err := db.UnpinVersion(first); err != nil {
+		t.Fatalf("failed to unpin the first version: %+v", err)
+	}
+	post(7)
+	check("after pruning the snapshot of a pinned version", 4)
+	var baseId *string
+	if err := db.QueryRow(`SELECT base_id FROM versions WHERE id = ?;`, second.Id).Scan(&baseId); err != nil || baseId != nil {
+		t.Errorf("the pinned version should have become a snapshot, got %v, %+v", baseId, err)
+	}
+	if version, err := db.LoadVersionById(first.Id); err != nil || version != nil {
+		t.Errorf("the unpinned version should be pruned, got %+v, %+v", version, err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/webhooks"
+	"go.n16f.net/uuid"
+)
+
+func TestWebhooks(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		failed   bool
+		received = make(map[string]model.WebhookEvent)
+		secret   []byte
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read webhook body: %+v", err)
+		}
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+		if err != nil {
+			t.Errorf("failed to parse webhook timestamp: %+v", err)
+		}
+		if !webhooks.Verify(secret, timestamp, body, r.Header.Get(webhooks.HeaderSignature)) {
+			t.Errorf("got invalid webhook signature %s", r.Header.Get(webhooks.HeaderSignature))
+		}
+		// fail the first delivery to exercise retries
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event model.WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("failed to unmarshal webhook event: %+v", err)
+		}
+		if event.Event != r.Header.Get(webhooks.HeaderEvent) {
+			t.Errorf("got event %s in a delivery of %s", event.Event, r.Header.Get(webhooks.HeaderEvent))
+		}
+		received[r.Header.Get(webhooks.HeaderDelivery)] = event
+	}))
+	defer receiver.Close()
+
+	webhook, err := db.CreateWebhook(receiver.URL, "/test_webhooks", []string{
+		model.WebhookAccountCreated,
+		model.WebhookLockAcquired,
+		model.WebhookLockReleased,
+		model.WebhookStateCreated,
+		model.WebhookStateRenamed,
+		model.WebhookVersionCreated,
+	})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %+v", err)
+	}
+	mutex.Lock()
+	if secret, err = hex.DecodeString(webhook.Secret); err != nil {
+		t.Fatalf("failed to decode webhook secret: %+v", err)
+	}
+	mutex.Unlock()
+
+	createTestAccount(t, "test_webhooks", "webhooks_password")
+	const lockId = `{"ID":"00000000-0000-0000-0000-000000000001"}`
+	tests := []struct {
+		method string
+		uri    url.URL
+		body   string
+		msg    string
+	}{
+		{"LOCK", url.URL{Path: "/test_webhooks"}, lockId, "lock"},
+		{"LOCK", url.URL{Path: "/test_webhooks"}, lockId, "refresh the lock"},
+		{"POST", url.URL{Path: "/test_webhooks", RawQuery: "ID=00000000-0000-0000-0000-000000000001"}, testState("test_webhooks", 1), "push"},
+		{"UNLOCK", url.URL{Path: "/test_webhooks"}, lockId, "unlock"},
+		{"POST", url.URL{Path: "/test_webhooks_other"}, testState("test_webhooks_other", 1), "push outside the path prefix"},
+	}
+	for _, tt := range tests {
+		runHTTPRequest(tt.method, true, &tt.uri, strings.NewReader(tt.body), func(r *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("failed to %s: %+v", tt.msg, err)
+			}
+			if r.StatusCode != http.StatusOK {
+				t.Fatalf("failed to %s, got %s", tt.msg, http.StatusText(r.StatusCode))
+			}
+		})
+	}
+
+	// the delivery which failed is retried after a backoff
+	_, err = db.Exec(`UPDATE webhooks_deliveries SET next_attempt = 0 WHERE webhook_id = ?;`, webhook.Id)
+	if err != nil {
+		t.Fatalf("failed to reschedule webhook deliveries: %+v", err)
+	}
+	if _, err := db.DeliverWebhooks(); err != nil {
+		t.Fatalf("failed to deliver webhooks: %+v", err)
+	}
+
+	expected := []string{
+		model.WebhookAccountCreated + " test_webhooks",
+		model.WebhookLockAcquired + " /test_webhooks",
+		model.WebhookLockReleased + " /test_webhooks",
+		model.WebhookStateCreated + " /test_webhooks",
+		model.WebhookVersionCreated + " /test_webhooks",
+	}
+	var got []string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		mutex.Lock()
+		got = got[:0]
+		for _, event := range received {
+			switch {
+			case event.State != nil:
+				got = append(got, event.Event+" "+event.State.Path)
+			case event.Account != nil && event.Account.Username == "test_webhooks":
+				got = append(got, event.Event+" "+event.Account.Username)
+			}
+		}
+		mutex.Unlock()
+		slices.Sort(got)
+		if slices.Equal(got, expected) {
+			break
+		}
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("got webhook events %v, wanted %v", got, expected)
+	}
+
+	deliveries, err := db.LoadWebhookDeliveries(webhook, 50)
+	if err != nil {
+		t.Fatalf("failed to load webhook deliveries: %+v", err)
+	}
+	retried := 0
+	for _, delivery := range deliveries {
+		if delivery.Delivered == nil {
+			t.Errorf("delivery %s of %s was not delivered", delivery.Id, delivery.Event)
+		}
+		if delivery.Attempts > 1 {
+			retried++
+		}
+	}
+	if retried != 1 {
+		t.Errorf("got %d retried deliveries, wanted 1", retried)
+	}
+	if err := db.DeleteWebhook(webhook); err != nil {
+		t.Fatalf("failed to delete webhook: %+v", err)
+	}
+}
+
+func TestWebhooksSecrets(t *testing.T) {
+	webhook, err := db.CreateWebhook("http://127.0.0.1:1/test_webhooks_secrets", "/test_webhooks_secrets", []string{model.WebhookVersionCreated})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %+v", err)
+	}
+	var (
+		keyId  *string
+		stored string
+	)
+	if err := db.QueryRow(`SELECT secret, secret_key_id FROM webhooks WHERE id = ?;`, webhook.Id).Scan(&stored, &keyId); err != nil {
+		t.Fatalf("failed to select webhook secret: %+v", err)
+	}
+	if keyId == nil || strings.Contains(stored, webhook.Secret) {
+		t.Fatalf("the webhook secret should be stored wrapped, got %s", stored)
+	}
+	loaded, err := db.LoadWebhookById(webhook.Id)
+	if err != nil || loaded == nil || loaded.Secret != webhook.Secret {
+		t.Fatalf("the webhook secret should be unwrapped when loading the webhook, got %+v, %+v", loaded, err)
+	}
+
+	if n, err := db.RewrapWebhookSecrets(); err != nil || n != 0 {
+		t.Fatalf("the webhook secret is already wrapped by the active key, got %d, %+v", n, err)
+	}
+
+	// a secret which fails to unwrap does not hold back the other webhooks
+	var received atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer receiver.Close()
+	other, err := db.CreateWebhook(receiver.URL, "/test_webhooks_secrets", []string{model.WebhookVersionCreated})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %+v", err)
+	}
+	if _, err := db.Exec(`UPDATE webhooks SET secret_key_id = 'missing' WHERE id = ?;`, webhook.Id); err != nil {
+		t.Fatalf("failed to break the webhook secret: %+v", err)
+	}
+	for _, webhookId := range []uuid.UUID{webhook.Id, other.Id} {
+		_, err := db.Exec(
+			`INSERT INTO webhooks_deliveries(id, webhook_id, event, payload, next_attempt)
+               VALUES (?, ?, ?, ?, 0);`,
+			uuid.MustGenerate(uuid.V7), webhookId, model.WebhookVersionCreated, []byte("{}"))
+		if err != nil {
+			t.Fatalf("failed to queue webhook delivery: %+v", err)
+		}
+	}
+	if _, err := db.DeliverWebhooks(); err != nil {
+		t.Fatalf("failed to deliver webhooks: %+v", err)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("got %d deliveries to the other webhook, wanted 1", n)
+	}
+	deliveries, err := db.LoadWebhookDeliveries(webhook, 1)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("failed to load webhook deliveries, got %+v, %+v", deliveries, err)
+	}
+	if deliveries[0].Delivered != nil || deliveries[0].Attempts != 1 || deliveries[0].LastError == nil {
+		t.Errorf("the delivery of a webhook whose secret fails to unwrap should fail, got %+v", deliveries[0])
+	}
+	if err := db.DeleteWebhook(other); err != nil {
+		t.Fatalf("failed to delete webhook: %+v", err)
+	}
+	if err := db.DeleteWebhook(webhook); err != nil {
+		t.Fatalf("failed to delete webhook: %+v", err)
+	}
+}
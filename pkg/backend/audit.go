@@ -0,0 +1,27 @@
+package backend
+
+import (
+	"log/slog"
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+// Records an action on the state at the request path. Failures are only logged
+// because the action already happened
+func recordAuditEvent(db *database.DB, r *http.Request, action string, before any, after any) {
+	account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
+	event, err := model.NewAuditEvent(account, action, model.AuditTargetState, nil, r.URL.Path, before, after)
+	if err == nil {
+		event.SourceIP = helpers.RemoteIP(r)
+		if token, ok := r.Context().Value(model.TokenContextKey{}).(*model.Token); ok {
+			event.TokenName = &token.Name
+		}
+		err = db.RecordAuditEvent(event)
+	}
+	if err != nil {
+		slog.Error("failed to record audit event", "action", action, "path", r.URL.Path, "err", err)
+	}
+}
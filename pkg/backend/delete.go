@@ -6,6 +6,7 @@ import (
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
 func handleDelete(db *database.DB) http.Handler {
@@ -16,9 +17,15 @@ func handleDelete(db *database.DB) http.Handler {
 			return
 		}
 
-		if success, err := db.DeleteState(r.URL.Path); err != nil {
-			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+		id := r.URL.Query().Get("ID")
+		force, ok := parseForce(db, w, r)
+		if !ok {
+			return
+		}
+		if success, err := db.DeleteState(r.URL.Path, id, force); err != nil {
+			stateErrorResponse(w, err)
 		} else if success {
+			recordAuditEvent(db, r, model.AuditStateDelete, nil, map[string]any{"force": force, "lock_id": id})
 			w.WriteHeader(http.StatusOK)
 		} else {
 			helpers.ErrorResponse(w, http.StatusNotFound,
@@ -0,0 +1,27 @@
+package backend
+
+import (
+	"errors"
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+)
+
+// Writes the error response matching the errors returned when modifying a state
+func stateErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidState):
+		helpers.ErrorResponse(w, http.StatusBadRequest, err)
+	case errors.Is(err, database.ErrLineageMismatch),
+		errors.Is(err, database.ErrLockIdMismatch),
+		errors.Is(err, database.ErrSerialRegression):
+		helpers.ErrorResponse(w, http.StatusConflict, err)
+	case errors.Is(err, database.ErrStateLocked):
+		helpers.ErrorResponse(w, http.StatusLocked, err)
+	case errors.Is(err, database.ErrSealed):
+		helpers.ErrorResponse(w, http.StatusServiceUnavailable, err)
+	default:
+		helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+	}
+}
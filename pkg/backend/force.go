@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/middlewares/permissions"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+// Parses the force query parameter which allows bypassing the lock ownership
+// checks and requires the admin permission on the state path. Returns false
+// as second value when an error response was written
+func parseForce(db *database.DB, w http.ResponseWriter, r *http.Request) (bool, bool) {
+	s := r.URL.Query().Get("force")
+	if s == "" {
+		return false, true
+	}
+	force, err := strconv.ParseBool(s)
+	if err != nil {
+		helpers.ErrorResponse(w, http.StatusBadRequest,
+			fmt.Errorf("invalid force parameter, expected a boolean: %w", err))
+		return false, false
+	}
+	if !force {
+		return false, true
+	}
+	allowed, err := permissions.Allowed(db, r, r.URL.Path, model.PermissionAdmin)
+	if err != nil {
+		helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+		return false, false
+	}
+	if !allowed {
+		helpers.ErrorResponse(w, http.StatusForbidden,
+			fmt.Errorf("missing %s permission on %s to force", model.PermissionAdmin, r.URL.Path))
+		return false, false
+	}
+	return true, true
+}
@@ -1,13 +1,27 @@
 package backend
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
 )
 
+// Returns true if the If-None-Match header value matches the etag
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func handleGet(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store, no-cache")
@@ -18,11 +32,23 @@ func handleGet(db *database.DB) http.Handler {
 			return
 		}
 
-		if data, err := db.GetState(r.URL.Path); err != nil {
+		version, err := db.GetState(r.URL.Path)
+		if err != nil {
 			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
-		} else {
+			return
+		}
+		if version == nil {
 			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(data)
+			return
+		}
+		etag := `"` + hex.EncodeToString(version.MD5) + `"`
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(version.MD5))
+		w.Header().Set("ETag", etag)
+		if matchesETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(version.Data)
 	})
 }
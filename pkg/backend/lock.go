@@ -8,6 +8,7 @@ import (
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
 type lockRequest struct {
@@ -32,6 +33,12 @@ func (l *lockRequest) valid() []error {
 	return err
 }
 
+// Returns true when only the ID is set, which is what clients know when they
+// force-unlock a state
+func (l *lockRequest) idOnly() bool {
+	return *l == lockRequest{ID: l.ID}
+}
+
 func handleLock(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
@@ -53,6 +60,7 @@ func handleLock(db *database.DB) http.Handler {
 		if success, err := db.SetLockOrGetExistingLock(r.URL.Path, &lock); err != nil {
 			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
 		} else if success {
+			recordAuditEvent(db, r, model.AuditStateLock, nil, lock)
 			w.WriteHeader(http.StatusOK)
 		} else {
 			_ = helpers.Encode(w, http.StatusConflict, lock)
@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/tfstate"
+)
+
+// Routes GET requests with the outputs=true query parameter to the outputs
+// handler. Any other value falls back to reading the whole state, which
+// requires the read permission
+func handleGetOrOutputs(get http.Handler, outputs http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, err := strconv.ParseBool(r.URL.Query().Get("outputs")); err == nil && ok {
+			outputs.ServeHTTP(w, r)
+			return
+		}
+		get.ServeHTTP(w, r)
+	})
+}
+
+// Serves a minimal state holding only the outputs of the current version of a
+// state, for terraform_remote_state consumers which should not read resources
+func handleOutputs(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, no-cache")
+
+		if r.URL.Path == "/" {
+			helpers.ErrorResponse(w, http.StatusBadRequest,
+				fmt.Errorf("no state path provided, cannot GET /"))
+			return
+		}
+		excludeSensitive := false
+		if s := r.URL.Query().Get("exclude_sensitive"); s != "" {
+			var err error
+			if excludeSensitive, err = strconv.ParseBool(s); err != nil {
+				helpers.ErrorResponse(w, http.StatusBadRequest,
+					fmt.Errorf("invalid exclude_sensitive parameter, expected a boolean: %w", err))
+				return
+			}
+		}
+
+		version, err := db.GetState(r.URL.Path)
+		if err != nil {
+			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		if version == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		document, err := tfstate.ParseDocument(version.Data)
+		if err != nil {
+			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		data, err := document.OutputsOnly(excludeSensitive)
+		if err != nil {
+			helpers.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	})
+}
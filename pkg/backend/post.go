@@ -1,6 +1,9 @@
 package backend
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,20 +23,39 @@ func handlePost(db *database.DB) http.Handler {
 		}
 
 		id := r.URL.Query().Get("ID")
+		force, ok := parseForce(db, w, r)
+		if !ok {
+			return
+		}
 
 		data, err := io.ReadAll(r.Body)
 		if err != nil || len(data) == 0 {
 			helpers.ErrorResponse(w, http.StatusBadRequest, err)
 			return
 		}
-		account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
-		if idMismatch, err := db.SetState(r.URL.Path, account.Id, data, id); err != nil {
-			if idMismatch {
-				helpers.ErrorResponse(w, http.StatusConflict, err)
-			} else {
-				helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+		if header := r.Header.Get("Content-MD5"); header != "" {
+			expected, err := base64.StdEncoding.DecodeString(header)
+			if err != nil || len(expected) != md5.Size {
+				helpers.ErrorResponse(w, http.StatusBadRequest,
+					fmt.Errorf("invalid Content-MD5 header, expected a base64 encoded MD5 digest"))
+				return
 			}
+			if sum := md5.Sum(data); !bytes.Equal(sum[:], expected) {
+				helpers.ErrorResponse(w, http.StatusBadRequest,
+					fmt.Errorf("the Content-MD5 header does not match the received data, the upload might have been truncated"))
+				return
+			}
+		}
+		account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
+		if err := db.SetState(r.URL.Path, account.Id, data, id, force); err != nil {
+			stateErrorResponse(w, err)
 		} else {
+			sum := md5.Sum(data)
+			recordAuditEvent(db, r, model.AuditStatePush, nil, map[string]any{
+				"force":   force,
+				"lock_id": id,
+				"md5":     base64.StdEncoding.EncodeToString(sum[:]),
+			})
 			w.WriteHeader(http.StatusOK)
 		}
 	})
@@ -5,6 +5,8 @@ import (
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/middlewares/basic_auth"
+	"git.adyxax.org/adyxax/tfstated/pkg/middlewares/permissions"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
 func addRoutes(
@@ -14,9 +16,14 @@ func addRoutes(
 	mux.Handle("GET /healthz", handleHealthz())
 
 	basicAuth := basic_auth.Middleware(db)
-	mux.Handle("DELETE /", basicAuth(handleDelete(db)))
-	mux.Handle("GET /", basicAuth(handleGet(db)))
-	mux.Handle("LOCK /", basicAuth(handleLock(db)))
-	mux.Handle("POST /", basicAuth(handlePost(db)))
-	mux.Handle("UNLOCK /", basicAuth(handleUnlock(db)))
+	require := func(permission model.Permission, next http.Handler) http.Handler {
+		return unsealed(db, basicAuth(permissions.Middleware(db, permission)(next)))
+	}
+	mux.Handle("DELETE /", require(model.PermissionDelete, handleDelete(db)))
+	mux.Handle("GET /", handleGetOrOutputs(
+		require(model.PermissionRead, handleGet(db)),
+		require(model.PermissionOutputs, handleOutputs(db))))
+	mux.Handle("LOCK /", require(model.PermissionLock, handleLock(db)))
+	mux.Handle("POST /", require(model.PermissionWrite, handlePost(db)))
+	mux.Handle("UNLOCK /", require(model.PermissionLock, handleUnlock(db)))
 }
@@ -0,0 +1,20 @@
+package backend
+
+import (
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+)
+
+// Rejects requests while tfstated is sealed
+func unsealed(db *database.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db.Sealed() {
+			w.Header().Set("Retry-After", "60")
+			helpers.ErrorResponse(w, http.StatusServiceUnavailable, database.ErrSealed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,11 +1,15 @@
 package backend
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/middlewares/permissions"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
 func handleUnlock(db *database.DB) http.Handler {
@@ -18,15 +22,75 @@ func handleUnlock(db *database.DB) http.Handler {
 
 		var lock lockRequest
 		if err := helpers.Decode(r, &lock); err != nil {
+			// force-unlock sends no body when it did not acquire the lock
+			// itself, which releases the current lock whatever its ID
+			if errors.Is(err, io.EOF) {
+				handleForceUnlockCurrent(db, w, r)
+				return
+			}
 			_ = helpers.Encode(w, http.StatusBadRequest, err)
 			return
 		}
-		if success, err := db.Unlock(r.URL.Path, &lock); err != nil {
+		success, err := db.Unlock(r.URL.Path, &lock)
+		if err != nil {
 			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
-		} else if success {
+			return
+		}
+		if success {
+			recordAuditEvent(db, r, model.AuditStateUnlock, lock, nil)
+		}
+		if !success && lock.idOnly() {
+			if !allowedToForceUnlock(db, w, r) {
+				return
+			}
+			account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
+			if success, err = db.ForceUnlockById(r.URL.Path, lock.ID, account.Id); err != nil {
+				helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+				return
+			}
+			if success {
+				recordAuditEvent(db, r, model.AuditStateForceUnlock, map[string]string{"ID": lock.ID}, nil)
+			}
+		}
+		if success {
 			w.WriteHeader(http.StatusOK)
 		} else {
 			_ = helpers.Encode(w, http.StatusConflict, lock)
 		}
 	})
 }
+
+// Returns true if the account has the write permission on the state, otherwise
+// writes the error response
+func allowedToForceUnlock(db *database.DB, w http.ResponseWriter, r *http.Request) bool {
+	allowed, err := permissions.Allowed(db, r, r.URL.Path, model.PermissionWrite)
+	if err != nil {
+		helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+		return false
+	}
+	if !allowed {
+		helpers.ErrorResponse(w, http.StatusForbidden,
+			fmt.Errorf("missing %s permission on %s to force-unlock", model.PermissionWrite, r.URL.Path))
+		return false
+	}
+	return true
+}
+
+func handleForceUnlockCurrent(db *database.DB, w http.ResponseWriter, r *http.Request) {
+	if !allowedToForceUnlock(db, w, r) {
+		return
+	}
+	account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
+	lockId, err := db.ForceUnlockByPath(r.URL.Path, account.Id)
+	if err != nil {
+		helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if lockId == "" {
+		helpers.ErrorResponse(w, http.StatusConflict,
+			fmt.Errorf("%s is not locked", r.URL.Path))
+		return
+	}
+	recordAuditEvent(db, r, model.AuditStateForceUnlock, map[string]string{"ID": lockId}, nil)
+	w.WriteHeader(http.StatusOK)
+}
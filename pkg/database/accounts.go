@@ -28,29 +28,36 @@ func (db *DB) CreateAccount(username string, isAdmin bool) (*model.Account, erro
 	if err := passwordReset.Generate(uuid.V4); err != nil {
 		return nil, fmt.Errorf("failed to generate password reset uuid: %w", err)
 	}
-	_, err := db.Exec(
-		`INSERT INTO accounts(id, username, is_Admin, settings, password_reset)
-           VALUES (?, ?, ?, jsonb('{}'), ?);`,
-		accountId,
-		username,
-		isAdmin,
-		passwordReset,
-	)
-	if err != nil {
-		var sqliteErr sqlite3.Error
-		if errors.As(err, &sqliteErr) {
-			if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
-				return nil, nil
-			}
-		}
-		return nil, fmt.Errorf("failed to insert new account: %w", err)
-	}
-	return &model.Account{
+	account := &model.Account{
 		Id:            accountId,
 		Username:      username,
 		IsAdmin:       isAdmin,
 		PasswordReset: &passwordReset,
-	}, nil
+	}
+	return account, db.WithTransaction(func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(db.ctx,
+			`INSERT INTO accounts(id, username, is_Admin, settings, password_reset)
+               VALUES (?, ?, ?, jsonb('{}'), ?);`,
+			accountId,
+			username,
+			isAdmin,
+			passwordReset,
+		)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) {
+				if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
+					account = nil
+					return nil
+				}
+			}
+			return fmt.Errorf("failed to insert new account: %w", err)
+		}
+		return db.enqueueEvent(tx, "", &model.WebhookEvent{
+			Account: &model.WebhookAccount{Id: accountId, Username: username},
+			Event:   model.WebhookAccountCreated,
+		})
+	})
 }
 
 func (db *DB) InitAdminAccount() error {
@@ -91,7 +98,8 @@ func (db *DB) InitAdminAccount() error {
 func (db *DB) LoadAccounts() ([]model.Account, error) {
 	rows, err := db.Query(
 		`SELECT id, username, salt, password_hash, is_admin, created, last_login,
-                json_extract(settings, '$'), password_reset, deleted FROM accounts;`)
+                json_extract(settings, '$'), password_reset, deleted, COALESCE(email, '')
+           FROM accounts;`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load accounts from database: %w", err)
 	}
@@ -114,7 +122,8 @@ func (db *DB) LoadAccounts() ([]model.Account, error) {
 			&lastLogin,
 			&settings,
 			&account.PasswordReset,
-			&account.Deleted)
+			&account.Deleted,
+			&account.Email)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load account from row: %w", err)
 		}
@@ -170,7 +179,7 @@ func (db *DB) LoadAccountById(id *uuid.UUID) (*model.Account, error) {
 	)
 	err := db.QueryRow(
 		`SELECT username, salt, password_hash, is_admin, created, last_login,
-                json_extract(settings, '$'), password_reset, deleted
+                json_extract(settings, '$'), password_reset, deleted, COALESCE(email, '')
            FROM accounts
            WHERE id = ?;`,
 		id,
@@ -182,7 +191,8 @@ func (db *DB) LoadAccountById(id *uuid.UUID) (*model.Account, error) {
 		&lastLogin,
 		&settings,
 		&account.PasswordReset,
-		&account.Deleted)
+		&account.Deleted,
+		&account.Email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -208,7 +218,7 @@ func (db *DB) LoadAccountByUsername(username string) (*model.Account, error) {
 	)
 	err := db.QueryRow(
 		`SELECT id, salt, password_hash, is_admin, created, last_login,
-                json_extract(settings, '$'), password_reset, deleted
+                json_extract(settings, '$'), password_reset, deleted, COALESCE(email, '')
            FROM accounts
            WHERE username = ?;`,
 		username,
@@ -220,7 +230,8 @@ func (db *DB) LoadAccountByUsername(username string) (*model.Account, error) {
 		&lastLogin,
 		&settings,
 		&account.PasswordReset,
-		&account.Deleted)
+		&account.Deleted,
+		&account.Email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -237,15 +248,23 @@ func (db *DB) LoadAccountByUsername(username string) (*model.Account, error) {
 
 func (db *DB) SaveAccount(account *model.Account) (bool, error) {
 	ret := false
-	return ret, db.WithTransaction(func(tx *sql.Tx) error {
-		_, err := tx.ExecContext(db.ctx,
+	err := db.WithTransaction(func(tx *sql.Tx) error {
+		var wasDeleted bool
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT deleted FROM accounts WHERE id = ?;`,
+			account.Id).Scan(&wasDeleted)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to select account id %s: %w", account.Id, err)
+		}
+		_, err = tx.ExecContext(db.ctx,
 			`UPDATE accounts
                SET username = ?,
                    salt = ?,
                    password_hash = ?,
                    is_admin = ?,
                    password_reset = ?,
-                   deleted = ?
+                   deleted = ?,
+                   email = NULLIF(?, '')
                WHERE id = ?`,
 			account.Username,
 			account.Salt,
@@ -253,6 +272,7 @@ func (db *DB) SaveAccount(account *model.Account) (bool, error) {
 			account.IsAdmin,
 			account.PasswordReset,
 			account.Deleted,
+			account.Email,
 			account.Id)
 		if err != nil {
 			var sqliteErr sqlite3.Error
@@ -278,8 +298,18 @@ func (db *DB) SaveAccount(account *model.Account) (bool, error) {
 			return fmt.Errorf("failed to update account settings for user account %s: %w", account.Username, err)
 		}
 		ret = true
-		return nil
+		if !account.Deleted || wasDeleted {
+			return nil
+		}
+		return db.enqueueEvent(tx, "", &model.WebhookEvent{
+			Account: &model.WebhookAccount{Id: account.Id, Username: account.Username},
+			Event:   model.WebhookAccountDeleted,
+		})
 	})
+	// invalidate after the commit so that the cache cannot be populated again
+	// with the previous credentials
+	db.credentials.invalidate(account.Id)
+	return ret, err
 }
 
 func (db *DB) SaveAccountSettings(account *model.Account, settings *model.Settings) error {
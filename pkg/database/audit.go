@@ -0,0 +1,119 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+const defaultAuditEventsLimit = 100
+
+// Appends an event to the audit log, the table's triggers refuse updates and
+// deletions
+func (db *DB) RecordAuditEvent(event *model.AuditEvent) error {
+	if err := event.Id.Generate(uuid.V7); err != nil {
+		return fmt.Errorf("failed to generate audit event id: %w", err)
+	}
+	_, err := db.Exec(
+		`INSERT INTO audit_events(id, action, actor_id, actor, token_name, source_ip, target_type, target_id, target, before, after)
+           VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, jsonb(?), jsonb(?));`,
+		event.Id, event.Action, event.ActorId, event.Actor, event.TokenName, event.SourceIP,
+		event.TargetType, event.TargetId, event.Target, []byte(event.Before), []byte(event.After))
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	event.Created = time.Now()
+	return nil
+}
+
+// Returns the audit events matching the filter, most recent first. Events are
+// ordered by rowid because the table is append only and uuids generated within
+// the same millisecond are not ordered. A negative limit returns all of them
+func (db *DB) LoadAuditEvents(filter *model.AuditFilter) ([]model.AuditEvent, error) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Before != nil {
+		conditions = append(conditions, "rowid < (SELECT rowid FROM audit_events WHERE id = ?)")
+		args = append(args, filter.Before)
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "instr(target, ?) > 0")
+		args = append(args, filter.Target)
+	}
+	if filter.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, filter.TargetType)
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created < ?")
+		args = append(args, filter.Until.Unix())
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultAuditEventsLimit
+	}
+	args = append(args, limit)
+	rows, err := db.Query(
+		`SELECT action, actor, actor_id, json(after), json(before), created, id, source_ip, target, target_id, target_type, token_name
+           FROM audit_events
+           `+where+`
+           ORDER BY rowid DESC
+           LIMIT ?;`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit events from database: %w", err)
+	}
+	defer rows.Close()
+	events := make([]model.AuditEvent, 0)
+	for rows.Next() {
+		var (
+			after   []byte
+			before  []byte
+			created int64
+			event   model.AuditEvent
+		)
+		err = rows.Scan(
+			&event.Action,
+			&event.Actor,
+			&event.ActorId,
+			&after,
+			&before,
+			&created,
+			&event.Id,
+			&event.SourceIP,
+			&event.Target,
+			&event.TargetId,
+			&event.TargetType,
+			&event.TokenName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load audit event from row: %w", err)
+		}
+		event.After = after
+		event.Before = before
+		event.Created = time.Unix(created, 0)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load audit events from rows: %w", err)
+	}
+	return events, nil
+}
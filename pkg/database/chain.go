@@ -0,0 +1,337 @@
+package database
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+// Each version stores the SHA-256 digest of its data and a chain hash covering
+// its ids, author, creation time and data digest together with the chain hash
+// of the previous version of its state. Modifying or deleting a version breaks
+// the chain at the next one. Pruning records the chain hash of the newest
+// pruned version before each remaining version in versions_prunes so that the
+// oldest remaining version and the versions following pinned ones can still be
+// checked.
+//
+// Chain hashes and prune records are HMAC-SHA256 keyed with a key derived from
+// the data key of the state, so that rewriting a chain after modifying or
+// deleting a version requires unwrapping it. Deleting the newest versions of a
+// state can only be detected by comparing its head with one recorded outside
+// of tfstated.
+
+const (
+	versionChainDomain  = "tfstated version chain v1"
+	versionsPruneDomain = "tfstated versions prune v1"
+)
+
+// Returns the key of the chain hashes of a state, derived from its data key
+func versionChainKey(key *dataKey) []byte {
+	mac := hmac.New(sha256.New, key.key[:])
+	mac.Write([]byte(versionChainDomain))
+	return mac.Sum(nil)
+}
+
+func versionChainHash(chainKey []byte, prev []byte, stateId uuid.UUID, versionId uuid.UUID, accountId uuid.UUID, created int64, dataHash []byte) []byte {
+	if prev == nil {
+		prev = make([]byte, sha256.Size)
+	}
+	h := hmac.New(sha256.New, chainKey)
+	h.Write([]byte(versionChainDomain))
+	h.Write(prev)
+	h.Write(stateId[:])
+	h.Write(versionId[:])
+	h.Write(accountId[:])
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(created)))
+	h.Write(dataHash)
+	return h.Sum(nil)
+}
+
+// Authenticates the chain hash recorded for a pruned version, so that a
+// deleted version cannot pass for a pruned one
+func versionsPruneHash(chainKey []byte, stateId uuid.UUID, versionId uuid.UUID, chainHash []byte) []byte {
+	h := hmac.New(sha256.New, chainKey)
+	h.Write([]byte(versionsPruneDomain))
+	h.Write(stateId[:])
+	h.Write(versionId[:])
+	h.Write(chainHash)
+	return h.Sum(nil)
+}
+
+// Returns the data hash and chain hash of a new version following the latest
+// version of its state
+func (db *DB) chainVersion(tx *sql.Tx, key *dataKey, stateId uuid.UUID, versionId uuid.UUID, accountId uuid.UUID, latestId *uuid.UUID, created int64, data []byte) ([]byte, []byte, error) {
+	var prev []byte
+	if latestId != nil {
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT chain_hash FROM versions WHERE id = ?;`,
+			latestId).Scan(&prev)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to select the chain hash of version %s: %w", latestId, err)
+		}
+	}
+	dataHash := sha256.Sum256(data)
+	return dataHash[:], versionChainHash(versionChainKey(key), prev, stateId, versionId, accountId, created, dataHash[:]), nil
+}
+
+// Records the chain hash of each version about to be pruned which precedes a
+// remaining version, either the cutoff or a pinned version
+func (db *DB) recordVersionsPrune(tx *sql.Tx, stateId uuid.UUID, cutoff uuid.UUID) error {
+	rows, err := tx.QueryContext(db.ctx,
+		`SELECT pruned.id, pruned.chain_hash
+           FROM versions AS pruned
+           JOIN versions AS next ON next.id = (SELECT MIN(id)
+                                                 FROM versions
+                                                 WHERE state_id = pruned.state_id AND id > pruned.id)
+           WHERE pruned.state_id = :stateId AND pruned.id < :cutoff
+             AND pruned.pinned_at IS NULL AND pruned.chain_hash IS NOT NULL
+             AND (next.id >= :cutoff OR next.pinned_at IS NOT NULL);`,
+		sql.Named("cutoff", cutoff),
+		sql.Named("stateId", stateId))
+	if err != nil {
+		return fmt.Errorf("failed to select the pruned versions at prune boundaries: %w", err)
+	}
+	type boundary struct {
+		chainHash []byte
+		versionId uuid.UUID
+	}
+	boundaries := make([]boundary, 0)
+	for rows.Next() {
+		var b boundary
+		if err := rows.Scan(&b.versionId, &b.chainHash); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to load pruned version from row: %w", err)
+		}
+		boundaries = append(boundaries, b)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return fmt.Errorf("failed to load pruned versions from rows: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to load pruned versions from rows: %w", err)
+	}
+	if len(boundaries) == 0 {
+		return nil
+	}
+	key, err := db.loadDataKey(tx, stateId)
+	if err != nil {
+		return err
+	}
+	chainKey := versionChainKey(key)
+	for _, b := range boundaries {
+		var pruneId uuid.UUID
+		if err := pruneId.Generate(uuid.V7); err != nil {
+			return fmt.Errorf("failed to generate versions prune id: %w", err)
+		}
+		_, err = tx.ExecContext(db.ctx,
+			`INSERT INTO versions_prunes(id, state_id, version_id, chain_hash, prune_hash) VALUES (?, ?, ?, ?, ?);`,
+			pruneId, stateId, b.versionId, b.chainHash, versionsPruneHash(chainKey, stateId, b.versionId, b.chainHash))
+		if err != nil {
+			return fmt.Errorf("failed to record versions prune: %w", err)
+		}
+	}
+	return nil
+}
+
+// Deletes the versions prunes records followed by a newer record without any
+// remaining version in between, only the newest record before each remaining
+// version is needed to verify the chain
+func (db *DB) deleteSupersededVersionsPrunes(tx *sql.Tx, stateId uuid.UUID) error {
+	_, err := tx.ExecContext(db.ctx,
+		`DELETE FROM versions_prunes
+           WHERE state_id = :stateId
+             AND EXISTS (SELECT 1
+                           FROM versions_prunes AS newer
+                           WHERE newer.state_id = :stateId
+                             AND newer.version_id > versions_prunes.version_id
+                             AND NOT EXISTS (SELECT 1
+                                               FROM versions
+                                               WHERE versions.state_id = :stateId
+                                                 AND versions.id > versions_prunes.version_id
+                                                 AND versions.id < newer.version_id));`,
+		sql.Named("stateId", stateId))
+	if err != nil {
+		return fmt.Errorf("failed to delete superseded versions prunes: %w", err)
+	}
+	return nil
+}
+
+type chainedVersion struct {
+	accountId uuid.UUID
+	chainHash []byte
+	created   int64
+	dataHash  []byte
+	id        uuid.UUID
+	pruneHash []byte
+}
+
+// Verifies the hash chain of the versions of every state
+func (db *DB) VerifyChains() ([]model.ChainReport, error) {
+	states, err := db.LoadStates()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]model.ChainReport, 0, len(states))
+	for _, state := range states {
+		report, err := db.VerifyChain(&state)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// Verifies the hash chain of the versions of a state, which requires
+// decrypting all of them. Errors are only returned when the verification
+// could not run, breaks of the chain are listed in the report.
+func (db *DB) VerifyChain(state *model.State) (*model.ChainReport, error) {
+	tx, err := db.readDB.BeginTx(db.ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	rows, err := tx.QueryContext(db.ctx,
+		`SELECT account_id, chain_hash, created, data_hash, id
+           FROM versions
+           WHERE state_id = ?
+           ORDER BY id;`,
+		state.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load versions from database: %w", err)
+	}
+	versions := make([]chainedVersion, 0)
+	for rows.Next() {
+		var v chainedVersion
+		if err := rows.Scan(&v.accountId, &v.chainHash, &v.created, &v.dataHash, &v.id); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to load version from row: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, fmt.Errorf("failed to load versions from rows: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to load versions from rows: %w", err)
+	}
+	report := &model.ChainReport{
+		Breaks:   make([]model.ChainBreak, 0),
+		Path:     state.Path,
+		StateId:  state.Id,
+		Versions: len(versions),
+	}
+	if len(versions) == 0 {
+		return report, nil
+	}
+	var chainKey []byte
+	var wrapped wrappedDataKey
+	err = tx.QueryRowContext(db.ctx,
+		`SELECT data_key, data_key_id FROM states WHERE id = ?;`,
+		state.Id).Scan(&wrapped.data, &wrapped.keyId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select data key of state %s: %w", state.Id, err)
+	}
+	if wrapped.data != nil {
+		key, err := db.unwrapDataKey(&wrapped, state.Id)
+		if err != nil {
+			return nil, err
+		}
+		chainKey = versionChainKey(key)
+	}
+	prunes, err := tx.QueryContext(db.ctx,
+		`SELECT chain_hash, prune_hash, version_id
+           FROM versions_prunes
+           WHERE state_id = ?
+           ORDER BY version_id;`,
+		state.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select versions prunes: %w", err)
+	}
+	boundaries := make([]chainedVersion, 0)
+	for prunes.Next() {
+		var b chainedVersion
+		if err := prunes.Scan(&b.chainHash, &b.pruneHash, &b.id); err != nil {
+			_ = prunes.Close()
+			return nil, fmt.Errorf("failed to load versions prune from row: %w", err)
+		}
+		boundaries = append(boundaries, b)
+	}
+	if err := prunes.Err(); err != nil {
+		_ = prunes.Close()
+		return nil, fmt.Errorf("failed to load versions prunes from rows: %w", err)
+	}
+	if err := prunes.Close(); err != nil {
+		return nil, fmt.Errorf("failed to load versions prunes from rows: %w", err)
+	}
+	var prev []byte
+	chained := false
+	for _, v := range versions {
+		// the newest pruned version before this one, if any, preceded it
+		forged := false
+		for len(boundaries) > 0 && bytes.Compare(boundaries[0].id[:], v.id[:]) < 0 {
+			b := boundaries[0]
+			if chainKey != nil && hmac.Equal(versionsPruneHash(chainKey, state.Id, b.id, b.chainHash), b.pruneHash) {
+				prev = b.chainHash
+			} else {
+				forged = true
+			}
+			boundaries = boundaries[1:]
+		}
+		if v.chainHash == nil {
+			if chained {
+				report.Breaks = append(report.Breaks, model.ChainBreak{
+					Reason:    "the version has no chain hash",
+					VersionId: v.id,
+				})
+			} else {
+				report.Unchained++
+			}
+			prev = nil
+			continue
+		}
+		chained = true
+		data, err := db.loadVersionData(tx, v.id)
+		if errors.Is(err, ErrSealed) {
+			return nil, err
+		}
+		if err != nil {
+			report.Breaks = append(report.Breaks, model.ChainBreak{
+				Reason:    fmt.Sprintf("failed to open the version: %s", err),
+				VersionId: v.id,
+			})
+		} else if sum := sha256.Sum256(data); !bytes.Equal(sum[:], v.dataHash) {
+			report.Breaks = append(report.Breaks, model.ChainBreak{
+				Reason:    "the data of the version does not match its hash",
+				VersionId: v.id,
+			})
+		} else if forged {
+			report.Breaks = append(report.Breaks, model.ChainBreak{
+				Reason:    "a versions prune record before the version is not authentic",
+				VersionId: v.id,
+			})
+		} else if chainKey == nil {
+			report.Breaks = append(report.Breaks, model.ChainBreak{
+				Reason:    "the state has no data key to verify the chain hash",
+				VersionId: v.id,
+			})
+		} else if expected := versionChainHash(chainKey, prev, state.Id, v.id, v.accountId, v.created, v.dataHash); !hmac.Equal(expected, v.chainHash) {
+			report.Breaks = append(report.Breaks, model.ChainBreak{
+				Reason:    "the chain hash does not match, the version or the one before it was modified or deleted",
+				VersionId: v.id,
+			})
+		}
+		prev = v.chainHash
+	}
+	report.Head = prev
+	return report, nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// The compression of a version's data is stored in the versions.compression
+// column, NULL meaning the data is not compressed
+const compressionGzip = "gzip"
+
+// Compresses data unless it does not make it smaller, in which case the
+// returned compression is nil
+func compress(data []byte) ([]byte, *string, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, nil, fmt.Errorf("failed to compress data: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to compress data: %w", err)
+	}
+	if buf.Len() >= len(data) {
+		return data, nil, nil
+	}
+	compression := compressionGzip
+	return buf.Bytes(), &compression, nil
+}
+
+func decompress(data []byte, compression *string) ([]byte, error) {
+	if compression == nil {
+		return data, nil
+	}
+	if *compression != compressionGzip {
+		return nil, fmt.Errorf("unsupported compression %s", *compression)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+	"go.n16f.net/uuid"
+)
+
+// Verifying an account password costs hundreds of thousands of PBKDF2
+// iterations, which is too much to pay on every backend request. This cache
+// remembers recently verified credentials for a limited time.
+//
+// A verification which read an account before it was changed must not cache
+// it after the change invalidated the cache, so invalidations increment an
+// epoch that verifications read before loading the account and that set checks
+type credentialsCache struct {
+	entries    map[[32]byte]*credentialsCacheEntry
+	epoch      uint64
+	maxEntries int
+	mutex      sync.Mutex
+	salt       []byte
+	ttl        time.Duration
+}
+
+type credentialsCacheEntry struct {
+	account model.Account
+	expires time.Time
+	token   *model.Token
+}
+
+func newCredentialsCache(maxEntries int, ttl time.Duration) *credentialsCache {
+	return &credentialsCache{
+		entries:    make(map[[32]byte]*credentialsCacheEntry),
+		maxEntries: maxEntries,
+		salt:       scrypto.RandomBytes(32),
+		ttl:        ttl,
+	}
+}
+
+// The cache keys are salted hashes so that passwords are never kept in memory
+func (cache *credentialsCache) key(username string, password string) [32]byte {
+	h := sha256.New()
+	h.Write(cache.salt)
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// Returns copies of the cached account and token, or nil on cache miss
+func (cache *credentialsCache) get(username string, password string) (*model.Account, *model.Token) {
+	if cache.ttl <= 0 {
+		return nil, nil
+	}
+	key := cache.key(username, password)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(cache.entries, key)
+		return nil, nil
+	}
+	account := entry.account
+	if entry.token == nil {
+		return &account, nil
+	}
+	token := *entry.token
+	return &account, &token
+}
+
+// Returns the current invalidation epoch, to read before loading an account
+func (cache *credentialsCache) currentEpoch() uint64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.epoch
+}
+
+func (cache *credentialsCache) invalidate(accountId uuid.UUID) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.epoch++
+	for key, entry := range cache.entries {
+		if entry.account.Id.Equal(accountId) {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+// Caches the credentials unless the cache was invalidated since epoch
+func (cache *credentialsCache) set(username string, password string, account *model.Account, token *model.Token, epoch uint64) {
+	if cache.ttl <= 0 {
+		return
+	}
+	entry := credentialsCacheEntry{
+		account: *account,
+		expires: time.Now().Add(cache.ttl),
+	}
+	if token != nil {
+		t := *token
+		entry.token = &t
+		if token.Expires != nil && token.Expires.Before(entry.expires) {
+			entry.expires = *token.Expires
+		}
+	}
+	key := cache.key(username, password)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if epoch != cache.epoch {
+		return
+	}
+	if _, ok := cache.entries[key]; !ok && len(cache.entries) >= cache.maxEntries {
+		cache.evict()
+	}
+	cache.entries[key] = &entry
+}
+
+// Removes expired entries, and the entry closest to expiration if the cache is
+// still full. Must be called with the mutex held
+func (cache *credentialsCache) evict() {
+	var (
+		now       = time.Now()
+		oldest    *credentialsCacheEntry
+		oldestKey [32]byte
+	)
+	for key, entry := range cache.entries {
+		if now.After(entry.expires) {
+			delete(cache.entries, key)
+		} else if oldest == nil || entry.expires.Before(oldest.expires) {
+			oldest = entry
+			oldestKey = key
+		}
+	}
+	if oldest != nil && len(cache.entries) >= cache.maxEntries {
+		delete(cache.entries, oldestKey)
+	}
+}
+
+// Returns the account matching the credentials, along with the API token if
+// the password was one. Returns (nil, nil, nil) if the credentials are invalid
+func (db *DB) LoadAccountByCredentials(username string, password string) (*model.Account, *model.Token, error) {
+	if account, token := db.credentials.get(username, password); account != nil {
+		if token == nil {
+			db.touches.account(account.Id)
+		} else {
+			if token.IsExpired() {
+				return nil, nil, nil
+			}
+			db.touches.token(token.Id)
+		}
+		return account, token, nil
+	}
+	epoch := db.credentials.currentEpoch()
+	account, err := db.LoadAccountByUsername(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account == nil || account.Deleted {
+		return nil, nil, nil
+	}
+	var token *model.Token
+	if model.IsTokenSecret(password) {
+		token, err = db.LoadTokenBySecret(account, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		if token != nil && token.IsExpired() {
+			return nil, nil, nil
+		}
+	}
+	if token != nil {
+		db.touches.token(token.Id)
+	} else {
+		// a password can start like a token secret without being one
+		if !account.CheckPassword(password) {
+			return nil, nil, nil
+		}
+		db.touches.account(account.Id)
+	}
+	db.credentials.set(username, password, account, token, epoch)
+	return account, token, nil
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+func TestCredentialsCacheInvalidateRace(t *testing.T) {
+	cache := newCredentialsCache(16, time.Hour)
+	account := model.Account{Id: uuid.MustGenerate(uuid.V7), Username: "test_race"}
+	var (
+		invalidated = make(chan struct{})
+		verified    = make(chan struct{})
+		wg          sync.WaitGroup
+	)
+	// a verification reads the account before a password change is committed
+	// and caches it after the change invalidated the cache
+	wg.Go(func() {
+		epoch := cache.currentEpoch()
+		close(verified)
+		<-invalidated
+		cache.set(account.Username, "previous_password", &account, nil, epoch)
+	})
+	<-verified
+	cache.invalidate(account.Id)
+	close(invalidated)
+	wg.Wait()
+	if cached, _ := cache.get(account.Username, "previous_password"); cached != nil {
+		t.Fatalf("the previous password should not be cached after the invalidation")
+	}
+
+	epoch := cache.currentEpoch()
+	cache.set(account.Username, "password", &account, nil, epoch)
+	if cached, _ := cache.get(account.Username, "password"); cached == nil {
+		t.Fatalf("the password should be cached when the cache was not invalidated")
+	}
+}
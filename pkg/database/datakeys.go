@@ -0,0 +1,214 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+	"go.n16f.net/uuid"
+)
+
+// Each state has its own random data key which encrypts its versions. It is
+// stored in the states table wrapped by a data encryption key from the
+// keyring, so that deleting a state destroys the only means to decrypt its
+// versions, and rotating the keyring only requires wrapping the data keys
+// again.
+
+// A data key as stored in the states table, both fields are nil for states
+// created before tfstated used data keys
+type wrappedDataKey struct {
+	data  []byte
+	keyId *string
+}
+
+// An unwrapped data key and the id of the keyring key that wraps it
+type dataKey struct {
+	key   *scrypto.AES256Key
+	keyId string
+}
+
+// Unwrapped data keys by state id, so that reading versions does not require a
+// round trip to a remote key provider each time. Entries hold the wrapped data
+// key they were unwrapped from and only match it, there is at most one per
+// state
+type dataKeysCache struct {
+	entries map[uuid.UUID]dataKeysCacheEntry
+	mutex   sync.Mutex
+}
+
+type dataKeysCacheEntry struct {
+	key     *dataKey
+	wrapped []byte
+}
+
+func newDataKeysCache() *dataKeysCache {
+	return &dataKeysCache{entries: make(map[uuid.UUID]dataKeysCacheEntry)}
+}
+
+func (cache *dataKeysCache) get(stateId uuid.UUID, wrapped []byte) *dataKey {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[stateId]
+	if !ok || !bytes.Equal(entry.wrapped, wrapped) {
+		return nil
+	}
+	return entry.key
+}
+
+func (cache *dataKeysCache) invalidate(stateId uuid.UUID) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.entries, stateId)
+}
+
+func (cache *dataKeysCache) set(stateId uuid.UUID, wrapped []byte, key *dataKey) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries[stateId] = dataKeysCacheEntry{key: key, wrapped: wrapped}
+}
+
+// The additional data authenticated with a wrapped data key, which binds it to
+// its state
+func dataKeyAdditionalData(stateId uuid.UUID) []byte {
+	return append([]byte("data key "), stateId[:]...)
+}
+
+func (db *DB) wrapDataKey(key *scrypto.AES256Key, stateId uuid.UUID) (*wrappedDataKey, error) {
+	keys, err := db.keyring()
+	if err != nil {
+		return nil, err
+	}
+	active := keys.active
+	wrappingKey, err := keys.get(active)
+	if err != nil {
+		return nil, err
+	}
+	data, err := wrappingKey.Wrap(db.ctx, key[:], dataKeyAdditionalData(stateId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return &wrappedDataKey{data: data, keyId: &active}, nil
+}
+
+func (db *DB) unwrapDataKey(wrapped *wrappedDataKey, stateId uuid.UUID) (*dataKey, error) {
+	if wrapped.data == nil || wrapped.keyId == nil {
+		return nil, fmt.Errorf("state %s has no data key", stateId)
+	}
+	keys, err := db.keyring()
+	if err != nil {
+		return nil, err
+	}
+	if key := db.dataKeys.get(stateId, wrapped.data); key != nil {
+		return key, nil
+	}
+	wrappingKey, err := keys.get(*wrapped.keyId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := wrappingKey.Unwrap(db.ctx, wrapped.data, dataKeyAdditionalData(stateId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of state %s: %w", stateId, err)
+	}
+	var key scrypto.AES256Key
+	if len(data) != len(key) {
+		return nil, fmt.Errorf("invalid data key size for state %s", stateId)
+	}
+	copy(key[:], data)
+	unwrapped := &dataKey{key: &key, keyId: *wrapped.keyId}
+	db.dataKeys.set(stateId, wrapped.data, unwrapped)
+	return unwrapped, nil
+}
+
+// Returns the data key of a state, generating it if the state does not have
+// one yet
+func (db *DB) loadDataKey(tx *sql.Tx, stateId uuid.UUID) (*dataKey, error) {
+	var wrapped wrappedDataKey
+	err := tx.QueryRowContext(db.ctx,
+		`SELECT data_key, data_key_id FROM states WHERE id = ?;`,
+		stateId).Scan(&wrapped.data, &wrapped.keyId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select data key of state %s: %w", stateId, err)
+	}
+	if wrapped.data != nil {
+		return db.unwrapDataKey(&wrapped, stateId)
+	}
+	var key scrypto.AES256Key
+	copy(key[:], scrypto.RandomBytes(len(key)))
+	w, err := db.wrapDataKey(&key, stateId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(db.ctx,
+		`UPDATE states SET data_key = ?, data_key_id = ? WHERE id = ?;`,
+		w.data, w.keyId, stateId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save data key of state %s: %w", stateId, err)
+	}
+	generated := &dataKey{key: &key, keyId: *w.keyId}
+	db.dataKeys.set(stateId, w.data, generated)
+	return generated, nil
+}
+
+// Wraps with the active data encryption key the data keys wrapped by another
+// key and returns how many were wrapped again
+func (db *DB) RewrapDataKeys() (int, error) {
+	keys, err := db.keyring()
+	if err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	err = db.WithTransaction(func(tx *sql.Tx) error {
+		type row struct {
+			id      uuid.UUID
+			wrapped wrappedDataKey
+		}
+		result, err := tx.QueryContext(db.ctx,
+			`SELECT id, data_key, data_key_id
+               FROM states
+               WHERE data_key IS NOT NULL AND data_key_id != ?;`,
+			keys.active)
+		if err != nil {
+			return fmt.Errorf("failed to select data keys: %w", err)
+		}
+		rows := make([]row, 0)
+		for result.Next() {
+			var r row
+			if err := result.Scan(&r.id, &r.wrapped.data, &r.wrapped.keyId); err != nil {
+				_ = result.Close()
+				return fmt.Errorf("failed to load data key from row: %w", err)
+			}
+			rows = append(rows, r)
+		}
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("failed to load data keys from rows: %w", err)
+		}
+		for _, r := range rows {
+			key, err := db.unwrapDataKey(&r.wrapped, r.id)
+			if err != nil {
+				return err
+			}
+			w, err := db.wrapDataKey(key.key, r.id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(db.ctx,
+				`UPDATE states SET data_key = ?, data_key_id = ? WHERE id = ?;`,
+				w.data, w.keyId, r.id)
+			if err != nil {
+				return fmt.Errorf("failed to save data key of state %s: %w", r.id, err)
+			}
+			_, err = tx.ExecContext(db.ctx,
+				`UPDATE versions SET key_id = ? WHERE state_id = ? AND data_key;`,
+				w.keyId, r.id)
+			if err != nil {
+				return fmt.Errorf("failed to update key id of versions of state %s: %w", r.id, err)
+			}
+			db.dataKeys.invalidate(r.id)
+			rewrapped++
+		}
+		return nil
+	})
+	return rewrapped, err
+}
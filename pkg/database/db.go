@@ -2,11 +2,20 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"git.adyxax.org/adyxax/tfstated/pkg/mail"
 	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
 )
 
@@ -29,11 +38,28 @@ func initDB(ctx context.Context, url string) (*sql.DB, error) {
 
 type DB struct {
 	ctx                        context.Context
-	dataEncryptionKey          scrypto.AES256Key
+	credentials                *credentialsCache
+	dataEncryptionKeys         atomic.Pointer[keyring] // nil while sealed
+	dataKeys                   *dataKeysCache
+	deliveriesQueued           atomic.Bool // set when a transaction queued webhooks or emails
+	emailsNotify               chan struct{}
+	locksStaleAfter            time.Duration
+	locksStrict                bool
+	locksTTL                   time.Duration
+	mailer                     *mail.Mailer // nil when emails are not configured
 	readDB                     *sql.DB
 	sessionsSalt               scrypto.AES256Key
+	stop                       chan struct{}
+	touches                    *touches
+	unsealer                   *unsealer
 	versionsHistoryLimit       int
 	versionsHistoryMinimumDays int
+	versionsSnapshotInterval   int
+	webhooksClient             *http.Client
+	webhooksDelivering         sync.Mutex // serializes deliveries so that none is sent twice
+	webhooksNotify             chan struct{}
+	webuiURL                   string
+	wg                         sync.WaitGroup
 	writeDB                    *sql.DB
 }
 
@@ -62,9 +88,17 @@ func NewDB(ctx context.Context, url string, getenv func(string) string) (*DB, er
 
 	db := DB{
 		ctx:                        ctx,
+		dataKeys:                   newDataKeysCache(),
+		emailsNotify:               make(chan struct{}, 1),
+		locksStaleAfter:            24 * time.Hour,
 		readDB:                     readDB,
+		stop:                       make(chan struct{}),
+		touches:                    newTouches(),
 		versionsHistoryLimit:       128,
 		versionsHistoryMinimumDays: 28,
+		versionsSnapshotInterval:   16,
+		webhooksClient:             &http.Client{},
+		webhooksNotify:             make(chan struct{}, 1),
 		writeDB:                    writeDB,
 	}
 	pragmas := []struct {
@@ -74,6 +108,7 @@ func NewDB(ctx context.Context, url string, getenv func(string) string) (*DB, er
 		{"foreign_keys", "ON"},
 		{"cache_size", "10000000"},
 		{"journal_mode", "WAL"},
+		{"secure_delete", "ON"},
 		{"synchronous", "NORMAL"},
 	}
 	for _, pragma := range pragmas {
@@ -85,12 +120,39 @@ func NewDB(ctx context.Context, url string, getenv func(string) string) (*DB, er
 		return nil, fmt.Errorf("failed to migrate: %w", err)
 	}
 
-	dataEncryptionKey := getenv("TFSTATED_DATA_ENCRYPTION_KEY")
-	if dataEncryptionKey == "" {
-		return nil, fmt.Errorf("the TFSTATED_DATA_ENCRYPTION_KEY environment variable is not set")
+	keys, err := newKeyring(ctx, getenv)
+	if err != nil {
+		return nil, err
 	}
-	if err := db.dataEncryptionKey.FromBase64(dataEncryptionKey); err != nil {
-		return nil, fmt.Errorf("failed to decode the TFSTATED_DATA_ENCRYPTION_KEY environment variable, expected 32 bytes base64 encoded: %w", err)
+	if s := getenv("TFSTATED_UNSEAL_THRESHOLD"); s != "" {
+		threshold, err := strconv.Atoi(s)
+		if err != nil || threshold < 2 {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_UNSEAL_THRESHOLD environment variable, expected an integer greater than 1")
+		}
+		keyId := getenv("TFSTATED_UNSEAL_KEY_ID")
+		if keyId == "" {
+			keyId = defaultDataEncryptionKeyId
+		}
+		if len(keys.keys) > 0 || keys.active != "" {
+			return nil, fmt.Errorf("the unseal key is the only data encryption key when TFSTATED_UNSEAL_THRESHOLD is set, unset TFSTATED_DATA_ENCRYPTION_KEY, TFSTATED_DATA_ENCRYPTION_KEYS, TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS and TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE")
+		}
+		keyCheck, err := base64.StdEncoding.DecodeString(getenv("TFSTATED_UNSEAL_KEY_CHECK"))
+		if err != nil || len(keyCheck) != sha256.Size {
+			return nil, fmt.Errorf("failed to decode the TFSTATED_UNSEAL_KEY_CHECK environment variable, expected the key check value printed by tfstated split-key")
+		}
+		db.unsealer = &unsealer{
+			keyCheck:  keyCheck,
+			keyId:     keyId,
+			threshold: threshold,
+		}
+	} else {
+		if err = keys.selectActive(); err != nil {
+			return nil, err
+		}
+		if err = db.checkDataEncryptionKeys(keys); err != nil {
+			return nil, err
+		}
+		db.dataEncryptionKeys.Store(keys)
 	}
 	sessionsSalt := getenv("TFSTATED_SESSIONS_SALT")
 	if sessionsSalt == "" {
@@ -111,11 +173,62 @@ func NewDB(ctx context.Context, url string, getenv func(string) string) (*DB, er
 			return nil, fmt.Errorf("failed to parse the TFSTATED_VERSIONS_HISTORY_MINIMUM_DAYS environment variable, expected an integer: %w", err)
 		}
 	}
-
+	if s := getenv("TFSTATED_VERSIONS_SNAPSHOT_INTERVAL"); s != "" {
+		if db.versionsSnapshotInterval, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_VERSIONS_SNAPSHOT_INTERVAL environment variable, expected an integer: %w", err)
+		}
+	}
+	authCacheSize := 1024
+	if s := getenv("TFSTATED_AUTH_CACHE_SIZE"); s != "" {
+		if authCacheSize, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_AUTH_CACHE_SIZE environment variable, expected an integer: %w", err)
+		}
+	}
+	authCacheTTL := 5 * time.Minute
+	if s := getenv("TFSTATED_AUTH_CACHE_TTL"); s != "" {
+		if authCacheTTL, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_AUTH_CACHE_TTL environment variable, expected a duration: %w", err)
+		}
+	}
+	db.credentials = newCredentialsCache(authCacheSize, authCacheTTL)
+	if s := getenv("TFSTATED_LOCKS_STRICT"); s != "" {
+		if db.locksStrict, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_LOCKS_STRICT environment variable, expected a boolean: %w", err)
+		}
+	}
+	if s := getenv("TFSTATED_LOCKS_TTL"); s != "" {
+		if db.locksTTL, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_LOCKS_TTL environment variable, expected a duration: %w", err)
+		}
+	}
+	if s := getenv("TFSTATED_LOCKS_STALE_AFTER"); s != "" {
+		if db.locksStaleAfter, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_LOCKS_STALE_AFTER environment variable, expected a duration: %w", err)
+		}
+	}
+	if db.mailer, err = newMailer(getenv); err != nil {
+		return nil, err
+	}
+	db.webuiURL = strings.TrimSuffix(getenv("TFSTATED_WEBUI_URL"), "/")
 	return &db, nil
 }
 
+// Starts the background loops which deliver emails and webhooks, flush last
+// usage timestamps and release expired locks. Only the server runs them, so
+// that offline commands do not duplicate its deliveries. They stop on Close
+func (db *DB) Start() {
+	db.wg.Go(func() { db.deliverEmailsLoop(5 * time.Second) })
+	db.wg.Go(func() { db.flushTouchesLoop(10 * time.Second) })
+	db.wg.Go(func() { db.releaseExpiredLocksLoop(time.Minute) })
+	db.wg.Go(func() { db.deliverWebhooksLoop(5 * time.Second) })
+}
+
 func (db *DB) Close() error {
+	close(db.stop)
+	db.wg.Wait()
+	if err := db.flushTouches(context.Background()); err != nil {
+		slog.Error("failed to flush last login timestamps", "err", err)
+	}
 	if err := db.readDB.Close(); err != nil {
 		_ = db.writeDB.Close()
 		return fmt.Errorf("failed to close read database connection: %w", err)
@@ -151,11 +264,18 @@ func (db *DB) WithTransaction(f func(tx *sql.Tx) error) error {
 		}
 	}()
 	if err = f(tx); err != nil {
+		db.deliveriesQueued.Store(false)
 		return fmt.Errorf("failed to execute function inside transaction: %w", err)
 	} else {
 		if err = tx.Commit(); err != nil {
+			db.deliveriesQueued.Store(false)
 			err = fmt.Errorf("failed to commit transaction: %w", err)
 		}
 	}
+	// Deliveries are only visible to the delivery loops once committed
+	if db.deliveriesQueued.Swap(false) {
+		db.notifyEmails()
+		db.notifyWebhooks()
+	}
 	return err
 }
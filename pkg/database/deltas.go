@@ -0,0 +1,310 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/delta"
+	"go.n16f.net/uuid"
+)
+
+// A version is either a full snapshot of a state, or a delta against the
+// snapshot referenced by versions.base_id. Deltas are always made against a
+// snapshot so that any version can be rebuilt from at most two rows.
+
+// The columns needed to rebuild a version's data, to be used with
+// storedVersionJoin and storedVersion.scanTargets
+const storedVersionColumns = `versions.base_id, versions.compression, versions.data, versions.data_key, versions.envelope, versions.id, versions.key_id, versions.md5, versions.state_id,
+  bases.compression, bases.data, COALESCE(bases.data_key, FALSE), bases.envelope, COALESCE(bases.key_id, ''),
+  data_keys.data_key, data_keys.data_key_id`
+const storedVersionJoin = `JOIN states AS data_keys ON data_keys.id = versions.state_id
+  LEFT JOIN versions AS bases ON bases.id = versions.base_id`
+
+type storedVersion struct {
+	base    sealedData
+	baseId  *uuid.UUID
+	dataKey wrappedDataKey
+	id      uuid.UUID
+	md5     []byte
+	sealed  sealedData
+	stateId uuid.UUID
+}
+
+func (v *storedVersion) scanTargets() []any {
+	return []any{
+		&v.baseId, &v.sealed.compression, &v.sealed.data, &v.sealed.dataKey, &v.sealed.envelope, &v.id, &v.sealed.keyId, &v.md5, &v.stateId,
+		&v.base.compression, &v.base.data, &v.base.dataKey, &v.base.envelope, &v.base.keyId,
+		&v.dataKey.data, &v.dataKey.keyId,
+	}
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Rebuilds the data of a stored version
+func (db *DB) openStoredVersion(v *storedVersion) ([]byte, error) {
+	var key *dataKey
+	if v.sealed.dataKey || v.base.dataKey {
+		var err error
+		if key, err = db.unwrapDataKey(&v.dataKey, v.stateId); err != nil {
+			return nil, err
+		}
+	}
+	data, err := db.openVersionData(&v.sealed, key, v.stateId, v.id)
+	if err != nil {
+		return nil, err
+	}
+	if v.baseId != nil {
+		if v.base.data == nil {
+			return nil, fmt.Errorf("base version %s not found", v.baseId)
+		}
+		base, err := db.openVersionData(&v.base, key, v.stateId, *v.baseId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open base version %s: %w", v.baseId, err)
+		}
+		if data, err = delta.Apply(base, data); err != nil {
+			return nil, fmt.Errorf("failed to apply delta against base version %s: %w", v.baseId, err)
+		}
+	}
+	if v.md5 != nil {
+		if sum := md5.Sum(data); !bytes.Equal(sum[:], v.md5) {
+			return nil, errors.New("rebuilt data does not match its md5 digest")
+		}
+	}
+	return data, nil
+}
+
+func (db *DB) loadVersionData(q rowQuerier, id uuid.UUID) ([]byte, error) {
+	var v storedVersion
+	err := q.QueryRowContext(db.ctx,
+		`SELECT `+storedVersionColumns+`
+           FROM versions
+           `+storedVersionJoin+`
+           WHERE versions.id = ?;`,
+		id).Scan(v.scanTargets()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load version %s: %w", id, err)
+	}
+	return db.openStoredVersion(&v)
+}
+
+// Returns what to store for a new version following the latest one: either
+// the full data with a nil base id, or a delta against the latest snapshot
+func (db *DB) encodeVersionData(tx *sql.Tx, latestId *uuid.UUID, latestBaseId *uuid.UUID, latest []byte, data []byte) (*uuid.UUID, []byte, error) {
+	if latestId == nil || db.versionsSnapshotInterval <= 1 {
+		return nil, data, nil
+	}
+	baseId, base := latestBaseId, latest
+	if baseId == nil {
+		baseId = latestId
+	} else {
+		var err error
+		if base, err = db.loadVersionData(tx, *baseId); err != nil {
+			return nil, nil, err
+		}
+	}
+	var deltas int
+	if err := tx.QueryRowContext(db.ctx, `SELECT COUNT(id) FROM versions WHERE base_id = ?;`, baseId).Scan(&deltas); err != nil {
+		return nil, nil, fmt.Errorf("failed to count deltas against snapshot %s: %w", baseId, err)
+	}
+	if deltas+1 >= db.versionsSnapshotInterval {
+		return nil, data, nil
+	}
+	encoded := delta.Encode(base, data)
+	if len(encoded) >= len(data) {
+		return nil, data, nil
+	}
+	return baseId, encoded, nil
+}
+
+// Deletes the versions of a state beyond the history limit, except pinned
+// versions. Versions that survive but were deltas against a pruned snapshot
+// are rebased on the oldest of them, which becomes a snapshot, pinned deltas
+// against a pruned snapshot become snapshots themselves, and the chain hash at
+// each prune boundary is recorded.
+func (db *DB) pruneVersions(tx *sql.Tx, stateId uuid.UUID) error {
+	min := time.Now().Add(time.Duration(db.versionsHistoryMinimumDays) * -24 * time.Hour)
+	var cutoff *uuid.UUID
+	err := tx.QueryRowContext(db.ctx,
+		`SELECT MIN(id)
+           FROM (SELECT id
+                   FROM versions
+                   WHERE state_id = :stateId AND created <= :min
+                   ORDER BY id DESC
+                   LIMIT :limit);`,
+		sql.Named("limit", db.versionsHistoryLimit),
+		sql.Named("min", min.Unix()),
+		sql.Named("stateId", stateId)).Scan(&cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to select versions history cutoff: %w", err)
+	}
+	if cutoff == nil {
+		return nil
+	}
+	rows, err := tx.QueryContext(db.ctx,
+		`SELECT DISTINCT versions.base_id
+           FROM versions
+           JOIN versions AS bases ON bases.id = versions.base_id
+           WHERE versions.state_id = :stateId AND versions.id >= :cutoff
+             AND bases.id < :cutoff AND bases.pinned_at IS NULL;`,
+		sql.Named("cutoff", cutoff),
+		sql.Named("stateId", stateId))
+	if err != nil {
+		return fmt.Errorf("failed to select pruned snapshots: %w", err)
+	}
+	snapshots := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to load pruned snapshot from row: %w", err)
+		}
+		snapshots = append(snapshots, id)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return fmt.Errorf("failed to load pruned snapshots from rows: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to load pruned snapshots from rows: %w", err)
+	}
+	for _, snapshot := range snapshots {
+		if err := db.rebaseVersions(tx, stateId, snapshot, *cutoff); err != nil {
+			return err
+		}
+	}
+	if err := db.snapshotPinnedVersions(tx, stateId, *cutoff); err != nil {
+		return err
+	}
+	if err := db.recordVersionsPrune(tx, stateId, *cutoff); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(db.ctx,
+		`DELETE FROM versions WHERE state_id = ? AND id < ? AND pinned_at IS NULL;`,
+		stateId, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to delete pruned versions: %w", err)
+	}
+	return db.deleteSupersededVersionsPrunes(tx, stateId)
+}
+
+// Turns the pinned versions older than the cutoff which are deltas against a
+// version about to be pruned into snapshots
+func (db *DB) snapshotPinnedVersions(tx *sql.Tx, stateId uuid.UUID, cutoff uuid.UUID) error {
+	rows, err := tx.QueryContext(db.ctx,
+		`SELECT versions.id
+           FROM versions
+           JOIN versions AS bases ON bases.id = versions.base_id
+           WHERE versions.state_id = ? AND versions.id < ?
+             AND versions.pinned_at IS NOT NULL AND bases.pinned_at IS NULL;`,
+		stateId, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to select pinned deltas: %w", err)
+	}
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to load pinned delta from row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return fmt.Errorf("failed to load pinned deltas from rows: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to load pinned deltas from rows: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	key, err := db.loadDataKey(tx, stateId)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		data, err := db.loadVersionData(tx, id)
+		if err != nil {
+			return err
+		}
+		sealed, err := db.sealVersionData(data, key, stateId, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(db.ctx,
+			`UPDATE versions SET base_id = NULL, compression = ?, data = ?, data_key = ?, envelope = ?, key_id = ? WHERE id = ?;`,
+			sealed.compression, sealed.data, sealed.dataKey, sealed.envelope, sealed.keyId, id)
+		if err != nil {
+			return fmt.Errorf("failed to turn pinned version %s into a snapshot: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Turns the oldest delta surviving the cutoff against a snapshot into a
+// snapshot and re-encodes the other surviving deltas against it
+func (db *DB) rebaseVersions(tx *sql.Tx, stateId uuid.UUID, snapshot uuid.UUID, cutoff uuid.UUID) error {
+	rows, err := tx.QueryContext(db.ctx,
+		`SELECT id FROM versions WHERE base_id = ? AND id >= ? ORDER BY id;`,
+		snapshot, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to select deltas against snapshot %s: %w", snapshot, err)
+	}
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to load delta from row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return fmt.Errorf("failed to load deltas from rows: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to load deltas from rows: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	key, err := db.loadDataKey(tx, stateId)
+	if err != nil {
+		return err
+	}
+	data := make([][]byte, len(ids))
+	for i, id := range ids {
+		if data[i], err = db.loadVersionData(tx, id); err != nil {
+			return err
+		}
+	}
+	for i, id := range ids {
+		var baseId *uuid.UUID
+		stored := data[i]
+		if i > 0 {
+			if encoded := delta.Encode(data[0], data[i]); len(encoded) < len(data[i]) {
+				baseId, stored = &ids[0], encoded
+			}
+		}
+		sealed, err := db.sealVersionData(stored, key, stateId, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(db.ctx,
+			`UPDATE versions SET base_id = ?, compression = ?, data = ?, data_key = ?, envelope = ?, key_id = ? WHERE id = ?;`,
+			baseId, sealed.compression, sealed.data, sealed.dataKey, sealed.envelope, sealed.keyId, id)
+		if err != nil {
+			return fmt.Errorf("failed to rebase version %s: %w", id, err)
+		}
+	}
+	return nil
+}
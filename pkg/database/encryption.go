@@ -0,0 +1,175 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+	"go.n16f.net/uuid"
+)
+
+// The additional data authenticated with the envelope of a version, which
+// binds it to its state and version ids
+func versionAdditionalData(stateId uuid.UUID, versionId uuid.UUID) []byte {
+	additionalData := make([]byte, 0, 32)
+	additionalData = append(additionalData, stateId[:]...)
+	return append(additionalData, versionId[:]...)
+}
+
+// The data of a version as stored in the versions table
+type sealedData struct {
+	compression *string
+	data        []byte
+	dataKey     bool // false for versions encrypted directly with keyId
+	envelope    *int // nil for versions encrypted with AES-256-CBC
+	keyId       string
+}
+
+// Compresses then encrypts the data of a version in an envelope with the data
+// key of its state
+func (db *DB) sealVersionData(data []byte, key *dataKey, stateId uuid.UUID, versionId uuid.UUID) (*sealedData, error) {
+	compressed, compression, err := compress(data)
+	if err != nil {
+		return nil, err
+	}
+	encryptedData, err := key.key.Seal(compressed, versionAdditionalData(stateId, versionId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt state data: %w", err)
+	}
+	envelope := int(scrypto.EnvelopeAES256GCM)
+	return &sealedData{
+		compression: compression,
+		data:        encryptedData,
+		dataKey:     true,
+		envelope:    &envelope,
+		keyId:       key.keyId,
+	}, nil
+}
+
+// Decrypts then decompresses the data of a version, with the data key of its
+// state unless it was encrypted before tfstated used data keys
+func (db *DB) openVersionData(sealed *sealedData, stateKey *dataKey, stateId uuid.UUID, versionId uuid.UUID) ([]byte, error) {
+	var key *scrypto.AES256Key
+	if sealed.dataKey {
+		if stateKey == nil {
+			return nil, fmt.Errorf("missing the data key of state %s", stateId)
+		}
+		key = stateKey.key
+	} else {
+		keys, err := db.keyring()
+		if err != nil {
+			return nil, err
+		}
+		if key, err = keys.staticKey(sealed.keyId); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if sealed.envelope == nil {
+		data, err = key.DecryptAES256(sealed.data)
+	} else {
+		data, err = key.Open(sealed.data, versionAdditionalData(stateId, versionId))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state data: %w", err)
+	}
+	return decompress(data, sealed.compression)
+}
+
+// Compresses the versions stored before tfstated compressed them and returns
+// how many were compressed
+func (db *DB) RecompressVersions() (int, error) {
+	return db.resealVersions("compression IS NULL", nil, func(compression *string) bool {
+		return compression != nil
+	})
+}
+
+// Encrypts with the data keys of their states the versions stored before
+// tfstated used data keys, and returns how many were encrypted
+func (db *DB) ReencryptVersions() (int, error) {
+	return db.resealVersions("NOT data_key", nil, func(*string) bool {
+		return true
+	})
+}
+
+// Opens and seals again in batches the versions matching the where clause and
+// its arguments, and returns how many were updated. A version is only updated
+// if changed returns true for its new compression.
+func (db *DB) resealVersions(where string, args []any, changed func(compression *string) bool) (int, error) {
+	var (
+		cursor   uuid.UUID
+		resealed int
+	)
+	for {
+		type row struct {
+			id      uuid.UUID
+			sealed  sealedData
+			stateId uuid.UUID
+		}
+		rows := make([]row, 0, 100)
+		n := 0
+		err := db.WithTransaction(func(tx *sql.Tx) error {
+			result, err := tx.QueryContext(db.ctx,
+				`SELECT compression, data, data_key, envelope, id, key_id, state_id
+                   FROM versions
+                   WHERE `+where+` AND id > ?
+                   ORDER BY id
+                   LIMIT 100;`,
+				append(args, cursor)...)
+			if err != nil {
+				return fmt.Errorf("failed to select versions: %w", err)
+			}
+			for result.Next() {
+				var r row
+				if err := result.Scan(&r.sealed.compression, &r.sealed.data, &r.sealed.dataKey, &r.sealed.envelope, &r.id, &r.sealed.keyId, &r.stateId); err != nil {
+					_ = result.Close()
+					return fmt.Errorf("failed to load version from row: %w", err)
+				}
+				rows = append(rows, r)
+			}
+			if err := result.Err(); err != nil {
+				return fmt.Errorf("failed to load versions from rows: %w", err)
+			}
+			dataKeys := make(map[uuid.UUID]*dataKey)
+			for _, r := range rows {
+				key, ok := dataKeys[r.stateId]
+				if !ok {
+					if key, err = db.loadDataKey(tx, r.stateId); err != nil {
+						return err
+					}
+					dataKeys[r.stateId] = key
+				}
+				data, err := db.openVersionData(&r.sealed, key, r.stateId, r.id)
+				if err != nil {
+					return fmt.Errorf("failed to open version %s: %w", r.id, err)
+				}
+				sealed, err := db.sealVersionData(data, key, r.stateId, r.id)
+				if err != nil {
+					return fmt.Errorf("failed to seal version %s: %w", r.id, err)
+				}
+				if !changed(sealed.compression) {
+					continue
+				}
+				_, err = tx.ExecContext(db.ctx,
+					`UPDATE versions SET compression = ?, data = ?, data_key = ?, envelope = ?, key_id = ? WHERE id = ?;`,
+					sealed.compression, sealed.data, sealed.dataKey, sealed.envelope, sealed.keyId, r.id)
+				if err != nil {
+					return fmt.Errorf("failed to update version %s: %w", r.id, err)
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return resealed, err
+		}
+		resealed += n
+		if len(rows) < 100 {
+			return resealed, nil
+		}
+		cursor = rows[len(rows)-1].id
+	}
+}
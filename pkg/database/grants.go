@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+// Returns true if the account is allowed the permission on the state path
+func (db *DB) CheckPermission(account *model.Account, path string, permission model.Permission) (bool, error) {
+	if account.IsAdmin {
+		return true, nil
+	}
+	grants, err := db.LoadGrantsByAccount(account.Id)
+	if err != nil {
+		return false, err
+	}
+	return grants.Allow(path, permission), nil
+}
+
+func (db *DB) CreateGrant(accountId *uuid.UUID, groupId *uuid.UUID, pathPrefix string, permission model.Permission) (*model.Grant, error) {
+	if !permission.Valid() {
+		return nil, fmt.Errorf("invalid permission %s", permission)
+	}
+	var grantId uuid.UUID
+	if err := grantId.Generate(uuid.V7); err != nil {
+		return nil, fmt.Errorf("failed to generate grant id: %w", err)
+	}
+	_, err := db.Exec(
+		`INSERT INTO grants(id, account_id, group_id, path_prefix, permission)
+           VALUES (?, ?, ?, ?, ?);`,
+		grantId,
+		accountId,
+		groupId,
+		pathPrefix,
+		permission,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert new grant: %w", err)
+	}
+	return &model.Grant{
+		AccountId:  accountId,
+		Created:    time.Now(),
+		GroupId:    groupId,
+		Id:         grantId,
+		PathPrefix: pathPrefix,
+		Permission: permission,
+	}, nil
+}
+
+// returns true in case of successful deletion
+func (db *DB) DeleteGrant(id uuid.UUID) (bool, error) {
+	result, err := db.Exec(`DELETE FROM grants WHERE id = ?;`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete grant %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return n == 1, nil
+}
+
+// Returns the grants given directly to the account as well as the grants given
+// to the groups the account is a member of
+func (db *DB) LoadGrantsByAccount(accountId uuid.UUID) (model.Grants, error) {
+	return db.loadGrants(
+		`SELECT account_id, created, group_id, id, path_prefix, permission
+           FROM grants
+           WHERE account_id = :id
+              OR group_id IN (SELECT group_id
+                                FROM groups_members
+                                WHERE account_id = :id)
+           ORDER BY path_prefix, id;`,
+		sql.Named("id", accountId))
+}
+
+func (db *DB) LoadGrantsByGroup(groupId uuid.UUID) (model.Grants, error) {
+	return db.loadGrants(
+		`SELECT account_id, created, group_id, id, path_prefix, permission
+           FROM grants
+           WHERE group_id = ?
+           ORDER BY path_prefix, id;`,
+		groupId)
+}
+
+func (db *DB) loadGrants(query string, args ...any) (model.Grants, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load grants from database: %w", err)
+	}
+	defer rows.Close()
+	grants := make(model.Grants, 0)
+	for rows.Next() {
+		var (
+			grant   model.Grant
+			created int64
+		)
+		err = rows.Scan(
+			&grant.AccountId,
+			&created,
+			&grant.GroupId,
+			&grant.Id,
+			&grant.PathPrefix,
+			&grant.Permission)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load grant from row: %w", err)
+		}
+		grant.Created = time.Unix(created, 0)
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load grants from rows: %w", err)
+	}
+	return grants, nil
+}
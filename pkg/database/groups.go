@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"github.com/mattn/go-sqlite3"
+	"go.n16f.net/uuid"
+)
+
+func (db *DB) AddGroupMember(group *model.Group, accountId uuid.UUID) error {
+	_, err := db.Exec(
+		`INSERT INTO groups_members(group_id, account_id)
+           VALUES (?, ?)
+           ON CONFLICT DO NOTHING;`,
+		group.Id,
+		accountId)
+	if err != nil {
+		return fmt.Errorf("failed to add account %s to group %s: %w", accountId, group.Name, err)
+	}
+	return nil
+}
+
+// Returns (nil, nil) if the group name already exists
+func (db *DB) CreateGroup(name string) (*model.Group, error) {
+	var groupId uuid.UUID
+	if err := groupId.Generate(uuid.V7); err != nil {
+		return nil, fmt.Errorf("failed to generate group id: %w", err)
+	}
+	_, err := db.Exec(`INSERT INTO groups(id, name) VALUES (?, ?);`, groupId, name)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) {
+			if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to insert new group: %w", err)
+	}
+	return &model.Group{
+		Created: time.Now(),
+		Id:      groupId,
+		Name:    name,
+	}, nil
+}
+
+func (db *DB) DeleteGroup(group *model.Group) error {
+	_, err := db.Exec(`DELETE FROM groups WHERE id = ?;`, group.Id)
+	if err != nil {
+		return fmt.Errorf("failed to delete group %s: %w", group.Name, err)
+	}
+	return nil
+}
+
+func (db *DB) LoadGroupById(id uuid.UUID) (*model.Group, error) {
+	group := model.Group{
+		Id: id,
+	}
+	var created int64
+	err := db.QueryRow(
+		`SELECT created, name FROM groups WHERE id = ?;`,
+		id,
+	).Scan(&created, &group.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load group by id %s: %w", id, err)
+	}
+	group.Created = time.Unix(created, 0)
+	return &group, nil
+}
+
+func (db *DB) LoadGroupMembers(group *model.Group) ([]uuid.UUID, error) {
+	rows, err := db.Query(
+		`SELECT account_id FROM groups_members WHERE group_id = ?;`,
+		group.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group members from database: %w", err)
+	}
+	defer rows.Close()
+	members := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var accountId uuid.UUID
+		if err := rows.Scan(&accountId); err != nil {
+			return nil, fmt.Errorf("failed to load group member from row: %w", err)
+		}
+		members = append(members, accountId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load group members from rows: %w", err)
+	}
+	return members, nil
+}
+
+func (db *DB) LoadGroups() ([]model.Group, error) {
+	return db.loadGroups(`SELECT created, id, name FROM groups ORDER BY name;`)
+}
+
+func (db *DB) LoadGroupsByAccount(accountId uuid.UUID) ([]model.Group, error) {
+	return db.loadGroups(
+		`SELECT created, id, name
+           FROM groups
+           JOIN groups_members ON groups_members.group_id = groups.id
+           WHERE groups_members.account_id = ?
+           ORDER BY name;`,
+		accountId)
+}
+
+func (db *DB) loadGroups(query string, args ...any) ([]model.Group, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load groups from database: %w", err)
+	}
+	defer rows.Close()
+	groups := make([]model.Group, 0)
+	for rows.Next() {
+		var (
+			group   model.Group
+			created int64
+		)
+		if err := rows.Scan(&created, &group.Id, &group.Name); err != nil {
+			return nil, fmt.Errorf("failed to load group from row: %w", err)
+		}
+		group.Created = time.Unix(created, 0)
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load groups from rows: %w", err)
+	}
+	return groups, nil
+}
+
+func (db *DB) RemoveGroupMember(group *model.Group, accountId uuid.UUID) error {
+	_, err := db.Exec(
+		`DELETE FROM groups_members WHERE group_id = ? AND account_id = ?;`,
+		group.Id,
+		accountId)
+	if err != nil {
+		return fmt.Errorf("failed to remove account %s from group %s: %w", accountId, group.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/keyprovider"
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+)
+
+// The id of the key configured with TFSTATED_DATA_ENCRYPTION_KEY, which
+// encrypted every version stored before tfstated supported several keys
+const defaultDataEncryptionKeyId = "default"
+
+// The data encryption keys by id. New data keys are wrapped with the active
+// key while the others remain available to unwrap older data keys.
+type keyring struct {
+	active string
+	keys   map[string]keyprovider.Provider
+}
+
+func newKeyring(ctx context.Context, getenv func(string) string) (*keyring, error) {
+	k := keyring{
+		keys: make(map[string]keyprovider.Provider),
+	}
+	add := func(variable string, id string, provider keyprovider.Provider) error {
+		if _, exists := k.keys[id]; exists {
+			return fmt.Errorf("failed to parse the %s environment variable, duplicate key id %s", variable, id)
+		}
+		k.keys[id] = provider
+		return nil
+	}
+	if s := getenv("TFSTATED_DATA_ENCRYPTION_KEY"); s != "" {
+		var key scrypto.AES256Key
+		if err := key.FromBase64(s); err != nil {
+			return nil, fmt.Errorf("failed to decode the TFSTATED_DATA_ENCRYPTION_KEY environment variable, expected 32 bytes base64 encoded: %w", err)
+		}
+		k.keys[defaultDataEncryptionKeyId] = keyprovider.NewStatic(&key)
+	}
+	if s := getenv("TFSTATED_DATA_ENCRYPTION_KEYS"); s != "" {
+		for entry := range strings.SplitSeq(s, ",") {
+			id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok || id == "" {
+				return nil, fmt.Errorf("failed to parse the TFSTATED_DATA_ENCRYPTION_KEYS environment variable, expected a comma separated list of id:key")
+			}
+			var key scrypto.AES256Key
+			if err := key.FromBase64(encoded); err != nil {
+				return nil, fmt.Errorf("failed to decode the TFSTATED_DATA_ENCRYPTION_KEYS environment variable key %s, expected 32 bytes base64 encoded: %w", id, err)
+			}
+			if err := add("TFSTATED_DATA_ENCRYPTION_KEYS", id, keyprovider.NewStatic(&key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s := getenv("TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS"); s != "" {
+		var token string
+		if path := getenv("TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS_TOKEN_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS_TOKEN_FILE: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		for entry := range strings.SplitSeq(s, ";") {
+			id, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || id == "" {
+				return nil, fmt.Errorf("failed to parse the TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS environment variable, expected a semicolon separated list of id=provider")
+			}
+			provider, err := keyprovider.Parse(ctx, spec, token)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse the TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS environment variable key %s: %w", id, err)
+			}
+			if err := add("TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS", id, provider); err != nil {
+				return nil, err
+			}
+		}
+	}
+	k.active = getenv("TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE")
+	return &k, nil
+}
+
+// Checks the active key, which defaults to the only key of the keyring
+func (k *keyring) selectActive() error {
+	switch len(k.keys) {
+	case 0:
+		return fmt.Errorf("none of the TFSTATED_DATA_ENCRYPTION_KEY, TFSTATED_DATA_ENCRYPTION_KEYS or TFSTATED_DATA_ENCRYPTION_KEY_PROVIDERS environment variables are set")
+	case 1:
+		if k.active == "" {
+			for id := range k.keys {
+				k.active = id
+			}
+		}
+	}
+	if k.active == "" {
+		return fmt.Errorf("the TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE environment variable must be set when several data encryption keys are configured")
+	}
+	if _, ok := k.keys[k.active]; !ok {
+		return fmt.Errorf("the TFSTATED_DATA_ENCRYPTION_KEY_ACTIVE environment variable references the unknown key id %s", k.active)
+	}
+	return nil
+}
+
+func (k *keyring) get(id string) (keyprovider.Provider, error) {
+	provider, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown data encryption key id %s", id)
+	}
+	return provider, nil
+}
+
+// Returns the key itself, which only providers holding their key in memory can
+// do. It is needed to decrypt the versions stored before tfstated used data
+// keys.
+func (k *keyring) staticKey(id string) (*scrypto.AES256Key, error) {
+	provider, err := k.get(id)
+	if err != nil {
+		return nil, err
+	}
+	static, ok := provider.(*keyprovider.Static)
+	if !ok {
+		return nil, fmt.Errorf("the data encryption key %s cannot decrypt versions stored before tfstated used data keys, run tfstated reencrypt with a static key first", id)
+	}
+	return static.Key(), nil
+}
+
+// Returns the keyring, or ErrSealed until tfstated is unsealed
+func (db *DB) keyring() (*keyring, error) {
+	keys := db.dataEncryptionKeys.Load()
+	if keys == nil {
+		return nil, ErrSealed
+	}
+	return keys, nil
+}
+
+// Fails if some versions, data keys or webhook secrets were encrypted with a
+// key missing from the keyring
+func (db *DB) checkDataEncryptionKeys(keys *keyring) error {
+	rows, err := db.Query(
+		`SELECT key_id FROM versions
+         UNION
+         SELECT data_key_id FROM states WHERE data_key_id IS NOT NULL
+         UNION
+         SELECT secret_key_id FROM webhooks;`)
+	if err != nil {
+		return fmt.Errorf("failed to select data encryption key ids: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to load data encryption key id from row: %w", err)
+		}
+		if _, err := keys.get(id); err != nil {
+			return fmt.Errorf("versions are encrypted with the data encryption key %s which is not configured", id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load data encryption key ids from rows: %w", err)
+	}
+	return nil
+}
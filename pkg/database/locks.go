@@ -1,67 +1,162 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"time"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
 	"go.n16f.net/uuid"
 )
 
-// Atomically check the lock status of a state and lock it if unlocked. Returns
-// true if the function locked the state, otherwise returns false and the lock
-// parameter is updated to the value of the existing lock
+var (
+	ErrLockIdMismatch = errors.New("lock ID mismatch")
+	ErrStateLocked    = errors.New("state is locked")
+)
+
+// Returns nil if a modification presenting lockId is allowed on a state whose
+// current lock ID is lock. In strict mode, modifying a locked state requires
+// presenting its lock ID
+func (db *DB) checkLockOwnership(lock *string, lockId string) error {
+	if lockId != "" {
+		if lock == nil || *lock != lockId {
+			return ErrLockIdMismatch
+		}
+		return nil
+	}
+	if lock != nil && db.locksStrict {
+		return ErrStateLocked
+	}
+	return nil
+}
+
+// Returns the default lock time to live, zero meaning that locks never expire
+func (db *DB) LocksTTL() time.Duration {
+	return db.locksTTL
+}
+
+// Returns the time at which the lock of a state expires, or nil if the state
+// is not locked or if its lock never expires
+func (db *DB) LockExpires(state *model.State) *time.Time {
+	if state.Lock == nil || state.LockRefreshed == nil {
+		return nil
+	}
+	ttl := db.locksTTL
+	if state.LockTTL != nil {
+		ttl = *state.LockTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	expires := state.LockRefreshed.Add(ttl)
+	return &expires
+}
+
+// Atomically check the lock status of a state and lock it if unlocked. Locking
+// again with the same lock ID refreshes the lock, and an expired lock is
+// released before being replaced. Returns true if the function locked the
+// state, otherwise returns false and the lock parameter is updated to the value
+// of the existing lock
 func (db *DB) SetLockOrGetExistingLock(path string, lock any) (bool, error) {
+	lockData, err := json.Marshal(lock)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal lock data: %w", err)
+	}
+	var newLock model.Lock
+	if err := json.Unmarshal(lockData, &newLock); err != nil {
+		return false, fmt.Errorf("failed to unmarshal lock data: %w", err)
+	}
 	ret := false
 	return ret, db.WithTransaction(func(tx *sql.Tx) error {
-		var lockData []byte
+		var (
+			existingData  []byte
+			lockRefreshed *int64
+			lockTTL       *int64
+			stateId       uuid.UUID
+		)
+		now := time.Now()
 		err := tx.QueryRowContext(db.ctx,
-			`SELECT json_extract(lock, '$')
+			`SELECT id, json_extract(lock, '$'), lock_refreshed, lock_ttl
                FROM states
                WHERE path = ?;`,
-			path).Scan(&lockData)
+			path).Scan(&stateId, &existingData, &lockRefreshed, &lockTTL)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				if lockData, err = json.Marshal(lock); err != nil {
-					return fmt.Errorf("failed to marshall lock data: %w", err)
-				}
-				var stateId uuid.UUID
 				if err := stateId.Generate(uuid.V7); err != nil {
 					return fmt.Errorf("failed to generate state id: %w", err)
 				}
 				_, err := tx.ExecContext(db.ctx,
-					`INSERT INTO states(id, path, lock)
-                       VALUES (?, ?, jsonb(?))`,
-					stateId, path, lockData)
+					`INSERT INTO states(id, path, lock, lock_acquired, lock_refreshed)
+                       VALUES (?, ?, jsonb(?), ?, ?)`,
+					stateId, path, lockData, now.Unix(), now.Unix())
 				if err != nil {
 					return fmt.Errorf("failed to create new state: %w", err)
 				}
 				ret = true
-				return nil
+				webhookState := &model.WebhookState{Id: stateId, Path: path}
+				if err := db.enqueueEvent(tx, path, &model.WebhookEvent{
+					Event: model.WebhookStateCreated,
+					State: webhookState,
+				}); err != nil {
+					return err
+				}
+				return db.enqueueEvent(tx, path, &model.WebhookEvent{
+					Event: model.WebhookLockAcquired,
+					Lock:  &newLock,
+					State: webhookState,
+				})
 			}
 			return fmt.Errorf("failed to select lock data from state: %w", err)
 		}
-		if lockData != nil {
-			if err := json.Unmarshal(lockData, lock); err != nil {
-				return fmt.Errorf("failed to unmarshal lock data: %w", err)
+		if existingData != nil {
+			state := model.State{Id: stateId}
+			if err := scanStateLock(&state, existingData, lockRefreshed, lockTTL); err != nil {
+				return err
+			}
+			if state.Lock.Id == newLock.Id {
+				_, err = tx.ExecContext(db.ctx,
+					`UPDATE states SET lock_refreshed = ? WHERE id = ?;`,
+					now.Unix(), stateId)
+				if err != nil {
+					return fmt.Errorf("failed to refresh lock: %w", err)
+				}
+				ret = true
+				return nil
+			}
+			if expires := db.LockExpires(&state); expires == nil || expires.After(now) {
+				if err := json.Unmarshal(existingData, lock); err != nil {
+					return fmt.Errorf("failed to unmarshal lock data: %w", err)
+				}
+				return nil
+			}
+			if err := db.recordLockRelease(tx, stateId, existingData, model.LockReleaseExpired, nil); err != nil {
+				return err
+			}
+			if err := db.enqueueLockReleasedEvent(tx, model.WebhookLockReleased, stateId, path, existingData, model.LockReleaseExpired, nil); err != nil {
+				return err
 			}
-			return nil
-		}
-		if lockData, err = json.Marshal(lock); err != nil {
-			return fmt.Errorf("failed to marshal lock data: %w", err)
 		}
 		_, err = tx.ExecContext(db.ctx,
 			`UPDATE states
-               SET lock = jsonb(?)
-               WHERE path = ?;`,
-			lockData, path)
+               SET lock = jsonb(?),
+                   lock_acquired = ?,
+                   lock_refreshed = ?,
+                   lock_stale = FALSE
+               WHERE id = ?;`,
+			lockData, now.Unix(), now.Unix(), stateId)
 		if err != nil {
 			return fmt.Errorf("failed to set lock data: %w", err)
 		}
 		ret = true
-		return nil
+		return db.enqueueEvent(tx, path, &model.WebhookEvent{
+			Event: model.WebhookLockAcquired,
+			Lock:  &newLock,
+			State: &model.WebhookState{Id: stateId, Path: path},
+		})
 	})
 }
 
@@ -70,29 +165,276 @@ func (db *DB) Unlock(path string, lock any) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("failed to marshal lock data: %w", err)
 	}
-	result, err := db.Exec(
+	ret := false
+	return ret, db.WithTransaction(func(tx *sql.Tx) error {
+		var stateId uuid.UUID
+		err := tx.QueryRowContext(db.ctx,
+			`UPDATE states
+               SET lock = NULL,
+                   lock_refreshed = NULL
+               WHERE path = ? and lock = jsonb(?)
+               RETURNING id;`,
+			path, data).Scan(&stateId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to update state: %w", err)
+		}
+		ret = true
+		var released model.Lock
+		if err := json.Unmarshal(data, &released); err != nil {
+			return fmt.Errorf("failed to unmarshal lock data: %w", err)
+		}
+		return db.enqueueEvent(tx, path, &model.WebhookEvent{
+			Event: model.WebhookLockReleased,
+			Lock:  &released,
+			State: &model.WebhookState{Id: stateId, Path: path},
+		})
+	})
+}
+
+// Releases the lock of a state whatever it is and records the release
+func (db *DB) ForceUnlock(state *model.State, accountId uuid.UUID) error {
+	return db.WithTransaction(func(tx *sql.Tx) error {
+		var lockData []byte
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT json_extract(lock, '$') FROM states WHERE id = ?;`,
+			state.Id).Scan(&lockData)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to select lock data from state: %w", err)
+		}
+		if lockData == nil {
+			return nil
+		}
+		return db.forceUnlock(tx, state.Id, lockData, accountId)
+	})
+}
+
+// Releases the lock of a state only knowing its ID and records the release.
+// Returns true if the lock was released
+func (db *DB) ForceUnlockById(path string, lockId string, accountId uuid.UUID) (bool, error) {
+	ret := false
+	return ret, db.WithTransaction(func(tx *sql.Tx) error {
+		var (
+			lockData []byte
+			stateId  uuid.UUID
+		)
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT id, json_extract(lock, '$')
+               FROM states
+               WHERE path = ? AND lock->>'ID' = ?;`,
+			path, lockId).Scan(&stateId, &lockData)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to select lock data from state: %w", err)
+		}
+		ret = true
+		return db.forceUnlock(tx, stateId, lockData, accountId)
+	})
+}
+
+// Releases the lock of a state whatever its ID and records the release.
+// Returns the ID of the released lock, or an empty string if the state was not
+// locked
+func (db *DB) ForceUnlockByPath(path string, accountId uuid.UUID) (string, error) {
+	ret := ""
+	return ret, db.WithTransaction(func(tx *sql.Tx) error {
+		var (
+			lockData []byte
+			lockId   string
+			stateId  uuid.UUID
+		)
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT id, json_extract(lock, '$'), lock->>'ID'
+               FROM states
+               WHERE path = ? AND lock IS NOT NULL;`,
+			path).Scan(&stateId, &lockData, &lockId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to select lock data from state: %w", err)
+		}
+		if err := db.forceUnlock(tx, stateId, lockData, accountId); err != nil {
+			return err
+		}
+		ret = lockId
+		return nil
+	})
+}
+
+func (db *DB) forceUnlock(tx *sql.Tx, stateId uuid.UUID, lockData []byte, accountId uuid.UUID) error {
+	if err := db.recordLockRelease(tx, stateId, lockData, model.LockReleaseForced, &accountId); err != nil {
+		return err
+	}
+	var path string
+	err := tx.QueryRowContext(db.ctx,
 		`UPDATE states
-           SET lock = NULL
-           WHERE path = ? and lock = jsonb(?);`,
-		path, data)
+           SET lock = NULL,
+               lock_refreshed = NULL
+           WHERE id = ?
+           RETURNING path;`,
+		stateId).Scan(&path)
 	if err != nil {
-		return false, fmt.Errorf("failed to update state: %w", err)
+		return fmt.Errorf("failed to update state: %w", err)
 	}
-	n, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	return db.enqueueLockReleasedEvent(tx, model.WebhookLockForced, stateId, path, lockData, model.LockReleaseForced, &accountId)
+}
+
+// Queues the event of a lock released by something else than its owner, by an
+// account if accountId is not nil
+func (db *DB) enqueueLockReleasedEvent(tx *sql.Tx, event string, stateId uuid.UUID, path string, lockData []byte, reason string, accountId *uuid.UUID) error {
+	var lock model.Lock
+	if err := json.Unmarshal(lockData, &lock); err != nil {
+		return fmt.Errorf("failed to unmarshal lock data: %w", err)
 	}
-	return n == 1, nil
+	var account *model.WebhookAccount
+	if accountId != nil {
+		var err error
+		if account, err = db.webhookAccount(tx, *accountId); err != nil {
+			return err
+		}
+	}
+	return db.enqueueEvent(tx, path, &model.WebhookEvent{
+		Account: account,
+		Event:   event,
+		Lock:    &lock,
+		Reason:  reason,
+		State:   &model.WebhookState{Id: stateId, Path: path},
+	})
 }
 
-func (db *DB) ForceUnlock(state *model.State) error {
-	_, err := db.Exec(
-		`UPDATE states
-           SET lock = NULL
-           WHERE id = ?;`,
+func (db *DB) LoadLockReleasesByState(state *model.State) ([]model.LockRelease, error) {
+	rows, err := db.Query(
+		`SELECT account_id, created, id, json_extract(lock, '$'), reason
+           FROM locks_releases
+           WHERE state_id = ?
+           ORDER BY id DESC;`,
 		state.Id)
 	if err != nil {
-		return fmt.Errorf("failed to update state: %w", err)
+		return nil, fmt.Errorf("failed to load lock releases from database: %w", err)
+	}
+	defer rows.Close()
+	releases := make([]model.LockRelease, 0)
+	for rows.Next() {
+		var (
+			release model.LockRelease
+			created int64
+			lock    []byte
+		)
+		err = rows.Scan(&release.AccountId, &created, &release.Id, &lock, &release.Reason)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load lock release from row: %w", err)
+		}
+		if err := json.Unmarshal(lock, &release.Lock); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lock data: %w", err)
+		}
+		release.Created = time.Unix(created, 0)
+		release.StateId = state.Id
+		releases = append(releases, release)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load lock releases from rows: %w", err)
+	}
+	return releases, nil
+}
+
+func (db *DB) recordLockRelease(tx *sql.Tx, stateId uuid.UUID, lock []byte, reason string, accountId *uuid.UUID) error {
+	var releaseId uuid.UUID
+	if err := releaseId.Generate(uuid.V7); err != nil {
+		return fmt.Errorf("failed to generate lock release id: %w", err)
+	}
+	_, err := tx.ExecContext(db.ctx,
+		`INSERT INTO locks_releases(id, state_id, account_id, lock, reason)
+           VALUES (?, ?, ?, jsonb(?), ?);`,
+		releaseId, stateId, accountId, lock, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record lock release: %w", err)
 	}
 	return nil
 }
+
+// Releases all the expired locks and returns how many were released
+func (db *DB) ReleaseExpiredLocks() (int, error) {
+	return db.releaseExpiredLocks(db.ctx)
+}
+
+func (db *DB) releaseExpiredLocks(ctx context.Context) (int, error) {
+	released := 0
+	err := db.WithTransaction(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id, json_extract(lock, '$')
+               FROM states
+               WHERE json_extract(lock, '$') IS NOT NULL
+                 AND COALESCE(lock_ttl, :ttl) > 0
+                 AND COALESCE(lock_refreshed, created) + COALESCE(lock_ttl, :ttl) <= :now;`,
+			sql.Named("now", time.Now().Unix()),
+			sql.Named("ttl", int64(db.locksTTL/time.Second)))
+		if err != nil {
+			return fmt.Errorf("failed to select expired locks: %w", err)
+		}
+		type expired struct {
+			lock    []byte
+			stateId uuid.UUID
+		}
+		locks := make([]expired, 0)
+		for rows.Next() {
+			var e expired
+			if err := rows.Scan(&e.stateId, &e.lock); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to load expired lock from row: %w", err)
+			}
+			locks = append(locks, e)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to load expired locks from rows: %w", err)
+		}
+		for _, e := range locks {
+			if err := db.recordLockRelease(tx, e.stateId, e.lock, model.LockReleaseExpired, nil); err != nil {
+				return err
+			}
+			var path string
+			err := tx.QueryRowContext(ctx,
+				`UPDATE states
+                   SET lock = NULL,
+                       lock_refreshed = NULL
+                   WHERE id = ?
+                   RETURNING path;`,
+				e.stateId).Scan(&path)
+			if err != nil {
+				return fmt.Errorf("failed to release expired lock: %w", err)
+			}
+			if err := db.enqueueLockReleasedEvent(tx, model.WebhookLockReleased, e.stateId, path, e.lock, model.LockReleaseExpired, nil); err != nil {
+				return err
+			}
+			slog.Info("released expired lock", "state", e.stateId)
+		}
+		released = len(locks)
+		return nil
+	})
+	return released, err
+}
+
+func (db *DB) releaseExpiredLocksLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			if _, err := db.releaseExpiredLocks(context.Background()); err != nil {
+				slog.Error("failed to release expired locks", "err", err)
+			}
+			if _, err := db.notifyStaleLocks(context.Background()); err != nil {
+				slog.Error("failed to notify stale locks", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/mail"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"github.com/mattn/go-sqlite3"
+	"go.n16f.net/uuid"
+)
+
+// Accounts with an email address subscribe to path prefixes to be emailed
+// about the states under them. Like webhook deliveries, emails are queued in
+// the same transaction as the event they describe and sent by a background
+// loop which retries failures with an exponential backoff.
+
+var ErrMailDisabled = errors.New("emails are not configured")
+
+// The events which are emailed to subscribers
+var emailEvents = []string{
+	model.WebhookLockForced,
+	model.WebhookLockStale,
+	model.WebhookStateDeleted,
+	model.WebhookStateRenamed,
+	model.WebhookVersionCreated,
+}
+
+// Returns nil if TFSTATED_SMTP_HOST is not set
+func newMailer(getenv func(string) string) (*mail.Mailer, error) {
+	host := getenv("TFSTATED_SMTP_HOST")
+	if host == "" {
+		return nil, nil
+	}
+	port := 587
+	if s := getenv("TFSTATED_SMTP_PORT"); s != "" {
+		var err error
+		if port, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("failed to parse the TFSTATED_SMTP_PORT environment variable, expected an integer: %w", err)
+		}
+	}
+	from := getenv("TFSTATED_SMTP_FROM")
+	if from == "" {
+		return nil, fmt.Errorf("the TFSTATED_SMTP_FROM environment variable is not set")
+	}
+	var password string
+	if path := getenv("TFSTATED_SMTP_PASSWORD_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the TFSTATED_SMTP_PASSWORD_FILE: %w", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	mailer, err := mail.NewMailer(host, port, from, getenv("TFSTATED_SMTP_USERNAME"), password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the TFSTATED_SMTP_FROM environment variable: %w", err)
+	}
+	return mailer, nil
+}
+
+// Returns true if tfstated can send emails
+func (db *DB) MailEnabled() bool {
+	return db.mailer != nil
+}
+
+// Returns (nil, nil) if the account is already subscribed to the path prefix
+func (db *DB) CreateSubscription(account *model.Account, pathPrefix string) (*model.Subscription, error) {
+	subscription := model.Subscription{
+		AccountId:  account.Id,
+		Created:    time.Now(),
+		PathPrefix: pathPrefix,
+	}
+	if err := subscription.Id.Generate(uuid.V7); err != nil {
+		return nil, fmt.Errorf("failed to generate subscription id: %w", err)
+	}
+	_, err := db.Exec(
+		`INSERT INTO subscriptions(id, account_id, path_prefix, created)
+           VALUES (?, ?, ?, ?);`,
+		subscription.Id, account.Id, pathPrefix, subscription.Created.Unix())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) {
+			if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to insert new subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+// Returns true if the subscription existed
+func (db *DB) DeleteSubscription(account *model.Account, subscriptionId uuid.UUID) (bool, error) {
+	result, err := db.Exec(
+		`DELETE FROM subscriptions WHERE id = ? AND account_id = ?;`,
+		subscriptionId, account.Id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete subscription %s: %w", subscriptionId, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (db *DB) LoadSubscriptionsByAccount(account *model.Account) ([]model.Subscription, error) {
+	rows, err := db.Query(
+		`SELECT created, id, path_prefix
+           FROM subscriptions
+           WHERE account_id = ?
+           ORDER BY path_prefix;`,
+		account.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions from database: %w", err)
+	}
+	defer rows.Close()
+	subscriptions := make([]model.Subscription, 0)
+	for rows.Next() {
+		var (
+			subscription model.Subscription
+			created      int64
+		)
+		if err := rows.Scan(&created, &subscription.Id, &subscription.PathPrefix); err != nil {
+			return nil, fmt.Errorf("failed to load subscription from row: %w", err)
+		}
+		subscription.AccountId = account.Id
+		subscription.Created = time.Unix(created, 0)
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions from rows: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// Queues the webhook deliveries and the emails of an event. The path is the
+// one of the state the event is about, or empty for account events which are
+// delivered to webhooks whatever their path prefix
+func (db *DB) enqueueEvent(tx *sql.Tx, path string, event *model.WebhookEvent) error {
+	event.Created = time.Now()
+	if err := db.enqueueWebhookDeliveries(tx, path, event); err != nil {
+		return err
+	}
+	return db.enqueueEmails(tx, path, event)
+}
+
+// Queues an email of an event to every subscriber allowed to read the state
+func (db *DB) enqueueEmails(tx *sql.Tx, path string, event *model.WebhookEvent) error {
+	if db.mailer == nil || path == "" || !slices.Contains(emailEvents, event.Event) {
+		return nil
+	}
+	rows, err := tx.QueryContext(db.ctx,
+		`SELECT accounts.email, accounts.id, accounts.is_admin, subscriptions.path_prefix
+           FROM subscriptions
+           JOIN accounts ON accounts.id = subscriptions.account_id
+           WHERE NOT accounts.deleted AND accounts.email IS NOT NULL;`)
+	if err != nil {
+		return fmt.Errorf("failed to select subscriptions: %w", err)
+	}
+	recipients := make(map[string]*model.Account)
+	for rows.Next() {
+		var (
+			account      model.Account
+			subscription model.Subscription
+		)
+		if err := rows.Scan(&account.Email, &account.Id, &account.IsAdmin, &subscription.PathPrefix); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to load subscription from row: %w", err)
+		}
+		if subscription.Matches(path) {
+			recipients[account.Id.String()] = &account
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load subscriptions from rows: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+	subject, body := db.eventEmail(event)
+	for _, account := range recipients {
+		allowed, err := db.CheckPermission(account, path, model.PermissionRead)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			continue
+		}
+		if err := db.queueEmail(tx, account.Email, subject, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the subject and body of the email of an event
+func (db *DB) eventEmail(event *model.WebhookEvent) (string, string) {
+	var subject string
+	var body strings.Builder
+	path := event.State.Path
+	switch event.Event {
+	case model.WebhookLockForced:
+		subject = "Lock of " + path + " was forced"
+		fmt.Fprintf(&body, "The lock of the state %s was forcibly released", path)
+		if event.Account != nil {
+			fmt.Fprintf(&body, " by %s", event.Account.Username)
+		}
+		body.WriteString(".\n")
+	case model.WebhookLockStale:
+		subject = path + " is locked for too long"
+		fmt.Fprintf(&body, "The state %s is locked", path)
+		if event.LockAcquired != nil {
+			fmt.Fprintf(&body, " since %s", event.LockAcquired.UTC().Format(time.RFC1123))
+		}
+		body.WriteString(".\n")
+	case model.WebhookStateDeleted:
+		subject = path + " was deleted"
+		fmt.Fprintf(&body, "The state %s was deleted.\n", path)
+	case model.WebhookStateRenamed:
+		subject = event.PreviousPath + " was renamed"
+		fmt.Fprintf(&body, "The state %s was renamed to %s.\n", event.PreviousPath, path)
+	case model.WebhookVersionCreated:
+		subject = "New version of " + path
+		fmt.Fprintf(&body, "A new version of the state %s was pushed", path)
+		if event.Account != nil {
+			fmt.Fprintf(&body, " by %s", event.Account.Username)
+		}
+		body.WriteString(".\n")
+	}
+	if event.Lock != nil {
+		fmt.Fprintf(&body, "\nLock ID: %s\nOperation: %s\nWho: %s\nCreated: %s\n",
+			event.Lock.Id, event.Lock.Operation, event.Lock.Who, event.Lock.Created.UTC().Format(time.RFC1123))
+	}
+	if db.webuiURL != "" && event.Event != model.WebhookStateDeleted {
+		fmt.Fprintf(&body, "\n%s/states/%s\n", db.webuiURL, event.State.Id)
+	}
+	body.WriteString("\nYou receive this email because you subscribed to this state in your tfstated settings.\n")
+	return "[tfstated] " + subject, body.String()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (db *DB) queueEmail(e execer, to string, subject string, body string) error {
+	var emailId uuid.UUID
+	if err := emailId.Generate(uuid.V7); err != nil {
+		return fmt.Errorf("failed to generate email id: %w", err)
+	}
+	_, err := e.ExecContext(db.ctx,
+		`INSERT INTO emails(id, recipient, subject, body) VALUES (?, ?, ?, ?);`,
+		emailId, to, subject, body)
+	if err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
+	}
+	db.deliveriesQueued.Store(true)
+	return nil
+}
+
+// Queues an email with the password reset link of an account. Returns
+// ErrMailDisabled if emails or the webui URL are not configured
+func (db *DB) SendPasswordResetEmail(account *model.Account) error {
+	if db.mailer == nil || db.webuiURL == "" {
+		return ErrMailDisabled
+	}
+	if account.Email == "" || account.PasswordReset == nil {
+		return fmt.Errorf("account %s has no email address or password reset", account.Username)
+	}
+	body := fmt.Sprintf("A password reset was requested for your tfstated account %s.\n\n"+
+		"Follow this link to choose a new password:\n\n%s/accounts/%s/reset/%s\n",
+		account.Username, db.webuiURL, account.Id, account.PasswordReset)
+	return db.WithTransaction(func(tx *sql.Tx) error {
+		return db.queueEmail(tx, account.Email, "[tfstated] Password reset", body)
+	})
+}
+
+// Sends the due emails and returns how many were sent
+func (db *DB) DeliverEmails() (int, error) {
+	return db.deliverEmails(db.ctx)
+}
+
+func (db *DB) deliverEmails(ctx context.Context) (int, error) {
+	if db.mailer == nil {
+		return 0, nil
+	}
+	sent := 0
+	for {
+		type pending struct {
+			attempts  int
+			body      string
+			id        uuid.UUID
+			recipient string
+			subject   string
+		}
+		rows, err := db.readDB.QueryContext(ctx,
+			`SELECT attempts, body, id, recipient, subject
+               FROM emails
+               WHERE sent IS NULL AND attempts < ? AND next_attempt <= ?
+               ORDER BY rowid
+               LIMIT ?;`,
+			deliveriesMaxAttempts, time.Now().Unix(), deliveriesBatchSize)
+		if err != nil {
+			return sent, fmt.Errorf("failed to select due emails: %w", err)
+		}
+		batch := make([]pending, 0)
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.attempts, &p.body, &p.id, &p.recipient, &p.subject); err != nil {
+				_ = rows.Close()
+				return sent, fmt.Errorf("failed to load email from row: %w", err)
+			}
+			batch = append(batch, p)
+		}
+		if err := rows.Err(); err != nil {
+			return sent, fmt.Errorf("failed to load emails from rows: %w", err)
+		}
+		if len(batch) == 0 {
+			return sent, nil
+		}
+		for _, p := range batch {
+			sendCtx, cancel := context.WithTimeout(ctx, deliveriesTimeout)
+			err := db.mailer.Send(sendCtx, p.recipient, p.subject, p.body)
+			cancel()
+			if ctx.Err() != nil {
+				return sent, ctx.Err()
+			}
+			now := time.Now()
+			var (
+				lastError *string
+				success   *int64
+			)
+			if err != nil {
+				msg := err.Error()
+				lastError = &msg
+				slog.Warn("failed to send email", "email", p.id, "attempt", p.attempts+1, "err", err)
+			} else {
+				t := now.Unix()
+				success = &t
+				sent++
+			}
+			backoff := min(deliveriesBackoffMin<<min(p.attempts, 16), deliveriesBackoffMax)
+			_, err = db.Exec(
+				`UPDATE emails
+                   SET attempts = attempts + 1,
+                       last_error = ?,
+                       next_attempt = ?,
+                       sent = ?
+                   WHERE id = ?;`,
+				lastError, now.Add(backoff).Unix(), success, p.id)
+			if err != nil {
+				return sent, fmt.Errorf("failed to update email %s: %w", p.id, err)
+			}
+		}
+	}
+}
+
+// Deletes the emails which are done, successfully or not, and older than the
+// retention period
+func (db *DB) pruneEmails() error {
+	_, err := db.Exec(
+		`DELETE FROM emails
+           WHERE created < ?
+             AND (sent IS NOT NULL OR attempts >= ?);`,
+		time.Now().Add(-deliveriesRetentionPeriod).Unix(), deliveriesMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to prune emails: %w", err)
+	}
+	return nil
+}
+
+// Wakes up the email delivery loop
+func (db *DB) notifyEmails() {
+	select {
+	case db.emailsNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (db *DB) deliverEmailsLoop(interval time.Duration) {
+	if db.mailer == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-db.stop
+		cancel()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+		case <-db.emailsNotify:
+		}
+		if _, err := db.deliverEmails(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to send emails", "err", err)
+		}
+		if time.Since(lastPrune) > time.Hour {
+			if err := db.pruneEmails(); err != nil {
+				slog.Error("failed to prune emails", "err", err)
+			}
+			lastPrune = time.Now()
+		}
+	}
+}
+
+// Queues a lock.stale event for the locks held longer than
+// TFSTATED_LOCKS_STALE_AFTER, once per lock, and returns how many were found
+func (db *DB) NotifyStaleLocks() (int, error) {
+	return db.notifyStaleLocks(db.ctx)
+}
+
+func (db *DB) notifyStaleLocks(ctx context.Context) (int, error) {
+	if db.locksStaleAfter <= 0 {
+		return 0, nil
+	}
+	stale := 0
+	err := db.WithTransaction(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT COALESCE(lock_acquired, lock_refreshed, created), id, json_extract(lock, '$'), path
+               FROM states
+               WHERE json_extract(lock, '$') IS NOT NULL
+                 AND NOT lock_stale
+                 AND COALESCE(lock_acquired, lock_refreshed, created) <= ?;`,
+			time.Now().Add(-db.locksStaleAfter).Unix())
+		if err != nil {
+			return fmt.Errorf("failed to select stale locks: %w", err)
+		}
+		events := make([]model.WebhookEvent, 0)
+		for rows.Next() {
+			var (
+				acquired int64
+				event    = model.WebhookEvent{Event: model.WebhookLockStale, State: &model.WebhookState{}}
+				lockData []byte
+			)
+			if err := rows.Scan(&acquired, &event.State.Id, &lockData, &event.State.Path); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to load stale lock from row: %w", err)
+			}
+			if err := json.Unmarshal(lockData, &event.Lock); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to unmarshal lock data: %w", err)
+			}
+			t := time.Unix(acquired, 0)
+			event.LockAcquired = &t
+			events = append(events, event)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to load stale locks from rows: %w", err)
+		}
+		for _, event := range events {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE states SET lock_stale = TRUE WHERE id = ?;`,
+				event.State.Id)
+			if err != nil {
+				return fmt.Errorf("failed to flag stale lock: %w", err)
+			}
+			if err := db.enqueueEvent(tx, event.State.Path, &event); err != nil {
+				return err
+			}
+		}
+		stale = len(events)
+		return nil
+	})
+	return stale, err
+}
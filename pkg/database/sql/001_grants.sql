@@ -0,0 +1,29 @@
+CREATE TABLE groups (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  created INTEGER NOT NULL DEFAULT (unixepoch())
+) STRICT;
+CREATE UNIQUE INDEX groups_name ON groups(name);
+
+CREATE TABLE groups_members (
+  group_id TEXT NOT NULL,
+  account_id TEXT NOT NULL,
+  PRIMARY KEY(group_id, account_id),
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+  FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX groups_members_account_id ON groups_members(account_id);
+
+CREATE TABLE grants (
+  id TEXT PRIMARY KEY,
+  account_id TEXT,
+  group_id TEXT,
+  path_prefix TEXT NOT NULL,
+  permission TEXT NOT NULL,
+  created INTEGER NOT NULL DEFAULT (unixepoch()),
+  CHECK ((account_id IS NULL) != (group_id IS NULL)),
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+  FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX grants_account_id ON grants(account_id);
+CREATE INDEX grants_group_id ON grants(group_id);
@@ -0,0 +1,13 @@
+CREATE TABLE tokens (
+  id TEXT PRIMARY KEY,
+  account_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  hash BLOB NOT NULL,
+  scope TEXT NOT NULL,
+  created INTEGER NOT NULL DEFAULT (unixepoch()),
+  expires INTEGER,
+  last_used INTEGER,
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+) STRICT;
+CREATE UNIQUE INDEX tokens_hash ON tokens(hash);
+CREATE UNIQUE INDEX tokens_account_id_name ON tokens(account_id, name);
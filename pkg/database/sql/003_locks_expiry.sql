@@ -0,0 +1,15 @@
+ALTER TABLE states ADD COLUMN lock_refreshed INTEGER;
+ALTER TABLE states ADD COLUMN lock_ttl INTEGER;
+UPDATE states SET lock_refreshed = unixepoch() WHERE json_extract(lock, '$') IS NOT NULL;
+
+CREATE TABLE locks_releases (
+  id TEXT PRIMARY KEY,
+  state_id TEXT NOT NULL,
+  account_id TEXT,
+  lock BLOB NOT NULL,
+  reason TEXT NOT NULL,
+  created INTEGER NOT NULL DEFAULT (unixepoch()),
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE SET NULL
+  FOREIGN KEY(state_id) REFERENCES states(id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX locks_releases_state_id ON locks_releases(state_id);
@@ -0,0 +1 @@
+ALTER TABLE versions ADD COLUMN md5 BLOB;
@@ -0,0 +1,2 @@
+ALTER TABLE versions ADD COLUMN base_id TEXT REFERENCES versions(id);
+CREATE INDEX versions_base_id ON versions(base_id);
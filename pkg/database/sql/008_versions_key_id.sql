@@ -0,0 +1 @@
+ALTER TABLE versions ADD COLUMN key_id TEXT NOT NULL DEFAULT 'default';
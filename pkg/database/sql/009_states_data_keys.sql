@@ -0,0 +1,3 @@
+ALTER TABLE states ADD COLUMN data_key BLOB;
+ALTER TABLE states ADD COLUMN data_key_id TEXT;
+ALTER TABLE versions ADD COLUMN data_key INTEGER NOT NULL DEFAULT FALSE;
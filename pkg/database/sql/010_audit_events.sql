@@ -0,0 +1,26 @@
+CREATE TABLE audit_events (
+  id TEXT PRIMARY KEY,
+  action TEXT NOT NULL,
+  actor_id TEXT,
+  actor TEXT NOT NULL,
+  token_name TEXT,
+  source_ip TEXT NOT NULL,
+  target_type TEXT NOT NULL,
+  target_id TEXT,
+  target TEXT NOT NULL,
+  before BLOB,
+  after BLOB,
+  created INTEGER NOT NULL DEFAULT (unixepoch())
+) STRICT;
+CREATE INDEX audit_events_action ON audit_events(action);
+CREATE INDEX audit_events_actor ON audit_events(actor);
+CREATE INDEX audit_events_created ON audit_events(created);
+CREATE INDEX audit_events_target_id ON audit_events(target_id);
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit events are append only');
+END;
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit events are append only');
+END;
@@ -0,0 +1,13 @@
+ALTER TABLE versions ADD COLUMN data_hash BLOB;
+ALTER TABLE versions ADD COLUMN chain_hash BLOB;
+
+CREATE TABLE versions_prunes (
+  id TEXT PRIMARY KEY,
+  state_id TEXT NOT NULL,
+  version_id TEXT NOT NULL,
+  chain_hash BLOB NOT NULL,
+  prune_hash BLOB NOT NULL,
+  created INTEGER NOT NULL DEFAULT (unixepoch()),
+  FOREIGN KEY(state_id) REFERENCES states(id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX versions_prunes_state_id ON versions_prunes(state_id);
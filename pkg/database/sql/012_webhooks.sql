@@ -0,0 +1,27 @@
+CREATE TABLE webhooks (
+  id TEXT PRIMARY KEY,
+  url TEXT NOT NULL,
+  path_prefix TEXT NOT NULL,
+  events BLOB NOT NULL,
+  secret TEXT NOT NULL,
+  secret_key_id TEXT NOT NULL,
+  enabled INTEGER NOT NULL DEFAULT TRUE,
+  created INTEGER NOT NULL DEFAULT (unixepoch())
+) STRICT;
+
+CREATE TABLE webhooks_deliveries (
+  id TEXT PRIMARY KEY,
+  webhook_id TEXT NOT NULL,
+  event TEXT NOT NULL,
+  payload BLOB NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt INTEGER NOT NULL DEFAULT (unixepoch()),
+  last_status INTEGER,
+  last_error TEXT,
+  delivered INTEGER,
+  created INTEGER NOT NULL DEFAULT (unixepoch()),
+  FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX webhooks_deliveries_created ON webhooks_deliveries(created);
+CREATE INDEX webhooks_deliveries_pending ON webhooks_deliveries(next_attempt) WHERE delivered IS NULL;
+CREATE INDEX webhooks_deliveries_webhook_id ON webhooks_deliveries(webhook_id);
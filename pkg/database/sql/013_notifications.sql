@@ -0,0 +1,26 @@
+ALTER TABLE accounts ADD COLUMN email TEXT;
+ALTER TABLE states ADD COLUMN lock_acquired INTEGER;
+ALTER TABLE states ADD COLUMN lock_stale INTEGER NOT NULL DEFAULT FALSE;
+
+CREATE TABLE subscriptions (
+  id TEXT PRIMARY KEY,
+  account_id TEXT NOT NULL,
+  path_prefix TEXT NOT NULL,
+  created INTEGER NOT NULL DEFAULT (unixepoch()),
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+) STRICT;
+CREATE UNIQUE INDEX subscriptions_account_id_path_prefix ON subscriptions(account_id, path_prefix);
+
+CREATE TABLE emails (
+  id TEXT PRIMARY KEY,
+  recipient TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  body TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt INTEGER NOT NULL DEFAULT (unixepoch()),
+  last_error TEXT,
+  sent INTEGER,
+  created INTEGER NOT NULL DEFAULT (unixepoch())
+) STRICT;
+CREATE INDEX emails_created ON emails(created);
+CREATE INDEX emails_pending ON emails(next_attempt) WHERE sent IS NULL;
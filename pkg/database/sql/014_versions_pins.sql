@@ -0,0 +1,3 @@
+ALTER TABLE versions ADD COLUMN pinned_at INTEGER;
+ALTER TABLE versions ADD COLUMN pinned_by TEXT REFERENCES accounts(id) ON DELETE SET NULL;
+ALTER TABLE versions ADD COLUMN pinned_reason TEXT;
@@ -1,21 +1,26 @@
 package database
 
 import (
+	"bytes"
+	"crypto/md5"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/tfstate"
 	"github.com/mattn/go-sqlite3"
 	"go.n16f.net/uuid"
 )
 
+// Returns ErrInvalidState if the data is not a valid state, and (nil, nil) if
+// the path already exists
 func (db *DB) CreateState(path string, accountId uuid.UUID, data []byte) (*model.Version, error) {
-	encryptedData, err := db.dataEncryptionKey.EncryptAES256(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt state data: %w", err)
+	if _, err := tfstate.Parse(data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidState, err)
 	}
 	var stateId uuid.UUID
 	if err := stateId.Generate(uuid.V7); err != nil {
@@ -25,9 +30,11 @@ func (db *DB) CreateState(path string, accountId uuid.UUID, data []byte) (*model
 	if err := versionId.Generate(uuid.V7); err != nil {
 		return nil, fmt.Errorf("failed to generate version id: %w", err)
 	}
+	sum := md5.Sum(data)
 	version := &model.Version{
 		AccountId: accountId,
 		Id:        versionId,
+		MD5:       sum[:],
 		StateId:   stateId,
 	}
 	return version, db.WithTransaction(func(tx *sql.Tx) error {
@@ -42,53 +49,137 @@ func (db *DB) CreateState(path string, accountId uuid.UUID, data []byte) (*model
 			}
 			return fmt.Errorf("failed to insert new state: %w", err)
 		}
+		key, err := db.loadDataKey(tx, stateId)
+		if err != nil {
+			return err
+		}
+		sealed, err := db.sealVersionData(data, key, stateId, versionId)
+		if err != nil {
+			return err
+		}
+		created := time.Now().Unix()
+		dataHash, chainHash, err := db.chainVersion(tx, key, stateId, versionId, accountId, nil, created, data)
+		if err != nil {
+			return err
+		}
 		_, err = tx.ExecContext(db.ctx,
-			`INSERT INTO versions(id, account_id, data, state_id)
-               VALUES (:id, :accountID, :data, :stateID)`,
+			`INSERT INTO versions(id, account_id, chain_hash, compression, created, data, data_hash, data_key, envelope, key_id, md5, state_id)
+               VALUES (:id, :accountID, :chainHash, :compression, :created, :data, :dataHash, :dataKey, :envelope, :keyID, :md5, :stateID)`,
 			sql.Named("accountID", accountId),
-			sql.Named("data", encryptedData),
+			sql.Named("chainHash", chainHash),
+			sql.Named("compression", sealed.compression),
+			sql.Named("created", created),
+			sql.Named("data", sealed.data),
+			sql.Named("dataHash", dataHash),
+			sql.Named("dataKey", sealed.dataKey),
+			sql.Named("envelope", sealed.envelope),
+			sql.Named("keyID", sealed.keyId),
 			sql.Named("id", versionId),
+			sql.Named("md5", version.MD5),
 			sql.Named("stateID", stateId))
 		if err != nil {
 			return fmt.Errorf("failed to insert new state version: %w", err)
 		}
-		return nil
+		account, err := db.webhookAccount(tx, accountId)
+		if err != nil {
+			return err
+		}
+		webhookState := &model.WebhookState{Id: stateId, Path: path}
+		if err := db.enqueueEvent(tx, path, &model.WebhookEvent{
+			Account: account,
+			Event:   model.WebhookStateCreated,
+			State:   webhookState,
+		}); err != nil {
+			return err
+		}
+		return db.enqueueEvent(tx, path, &model.WebhookEvent{
+			Account:   account,
+			Event:     model.WebhookVersionCreated,
+			State:     webhookState,
+			VersionId: &versionId,
+		})
 	})
 }
 
-// returns true in case of successful deletion
-func (db *DB) DeleteState(path string) (bool, error) {
-	result, err := db.Exec(`DELETE FROM states WHERE path = ?;`, path)
-	if err != nil {
-		return false, err
+// Returns true in case of successful deletion. The lockId must match the lock
+// of the state unless force is true, see checkLockOwnership
+func (db *DB) DeleteState(path string, lockId string, force bool) (bool, error) {
+	var (
+		ret     bool
+		stateId uuid.UUID
+	)
+	err := db.WithTransaction(func(tx *sql.Tx) error {
+		var lockData *string
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT id, lock->>'ID' FROM states WHERE path = ?;`,
+			path).Scan(&stateId, &lockData)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to select lock data from state: %w", err)
+		}
+		if !force {
+			if err := db.checkLockOwnership(lockData, lockId); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(db.ctx, `DELETE FROM states WHERE id = ?;`, stateId); err != nil {
+			return fmt.Errorf("failed to delete state: %w", err)
+		}
+		ret = true
+		return db.enqueueEvent(tx, path, &model.WebhookEvent{
+			Event: model.WebhookStateDeleted,
+			State: &model.WebhookState{Id: stateId, Path: path},
+		})
+	})
+	if err != nil || !ret {
+		return ret, err
 	}
-	n, err := result.RowsAffected()
-	if err != nil {
-		return false, err
+	db.dataKeys.invalidate(stateId)
+	// secure_delete zeroed the pages that held the wrapped data key of the
+	// state, but the write-ahead log can still hold copies of them
+	if _, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		slog.Error("failed to checkpoint the write-ahead log after deleting a state", "err", err)
 	}
-	return n == 1, nil
+	return true, nil
 }
 
-func (db *DB) GetState(path string) ([]byte, error) {
-	var encryptedData []byte
+// Returns the latest version of a state, or nil if the state does not exist
+func (db *DB) GetState(path string) (*model.Version, error) {
+	var (
+		version model.Version
+		created int64
+		stored  storedVersion
+	)
 	err := db.QueryRow(
-		`SELECT versions.data
+		`SELECT versions.account_id, versions.created, `+storedVersionColumns+`
            FROM versions
            JOIN states ON states.id = versions.state_id
+           `+storedVersionJoin+`
            WHERE states.path = ?
            ORDER BY versions.id DESC
            LIMIT 1;`,
-		path).Scan(&encryptedData)
+		path).Scan(append([]any{&version.AccountId, &created}, stored.scanTargets()...)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return []byte{}, nil
+			return nil, nil
 		}
-		return nil, err
+		return nil, fmt.Errorf("failed to load latest version of state %s: %w", path, err)
+	}
+	version.Created = time.Unix(created, 0)
+	version.Id = stored.id
+	version.StateId = stored.stateId
+	if version.Data, err = db.openStoredVersion(&stored); err != nil {
+		return nil, fmt.Errorf("failed to open version %s data: %w", version.Id, err)
 	}
-	if encryptedData == nil {
-		return []byte{}, nil
+	version.MD5 = stored.md5
+	if version.MD5 == nil {
+		// versions stored before tfstated kept their digest
+		sum := md5.Sum(version.Data)
+		version.MD5 = sum[:]
 	}
-	return db.dataEncryptionKey.DecryptAES256(encryptedData)
+	return &version, nil
 }
 
 func (db *DB) LoadStateById(stateId uuid.UUID) (*model.State, error) {
@@ -96,31 +187,48 @@ func (db *DB) LoadStateById(stateId uuid.UUID) (*model.State, error) {
 		Id: stateId,
 	}
 	var (
-		created int64
-		updated int64
-		lock    []byte
+		created       int64
+		updated       int64
+		lock          []byte
+		lockRefreshed *int64
+		lockTTL       *int64
 	)
 	err := db.QueryRow(
-		`SELECT created, json_extract(lock, '$'), path, updated
+		`SELECT created, json_extract(lock, '$'), lock_refreshed, lock_ttl, path, updated
            FROM states
            WHERE id = ?;`,
-		stateId).Scan(&created, &lock, &state.Path, &updated)
+		stateId).Scan(&created, &lock, &lockRefreshed, &lockTTL, &state.Path, &updated)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to load state id %s from database: %w", stateId, err)
 	}
-	if lock != nil {
-		if err := json.Unmarshal(lock, &state.Lock); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal lock data: %w", err)
-		}
+	if err := scanStateLock(&state, lock, lockRefreshed, lockTTL); err != nil {
+		return nil, err
 	}
 	state.Created = time.Unix(created, 0)
 	state.Updated = time.Unix(updated, 0)
 	return &state, nil
 }
 
+func scanStateLock(state *model.State, lock []byte, lockRefreshed *int64, lockTTL *int64) error {
+	if lock != nil {
+		if err := json.Unmarshal(lock, &state.Lock); err != nil {
+			return fmt.Errorf("failed to unmarshal lock data: %w", err)
+		}
+	}
+	if lockRefreshed != nil {
+		refreshed := time.Unix(*lockRefreshed, 0)
+		state.LockRefreshed = &refreshed
+	}
+	if lockTTL != nil {
+		ttl := time.Duration(*lockTTL) * time.Second
+		state.LockTTL = &ttl
+	}
+	return nil
+}
+
 func (db *DB) LoadStatePaths() (map[string]string, error) {
 	rows, err := db.Query(
 		`SELECT id, path FROM states;`)
@@ -148,7 +256,8 @@ func (db *DB) LoadStatePaths() (map[string]string, error) {
 
 func (db *DB) LoadStates() ([]model.State, error) {
 	rows, err := db.Query(
-		`SELECT created, id, json_extract(lock, '$'), path, updated FROM states;`)
+		`SELECT created, id, json_extract(lock, '$'), lock_refreshed, lock_ttl, path, updated
+           FROM states;`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load states from database: %w", err)
 	}
@@ -156,19 +265,19 @@ func (db *DB) LoadStates() ([]model.State, error) {
 	states := make([]model.State, 0)
 	for rows.Next() {
 		var (
-			state   model.State
-			created int64
-			updated int64
-			lock    []byte
+			state         model.State
+			created       int64
+			updated       int64
+			lock          []byte
+			lockRefreshed *int64
+			lockTTL       *int64
 		)
-		err = rows.Scan(&created, &state.Id, &lock, &state.Path, &updated)
+		err = rows.Scan(&created, &state.Id, &lock, &lockRefreshed, &lockTTL, &state.Path, &updated)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load state from row: %w", err)
 		}
-		if lock != nil {
-			if err := json.Unmarshal(lock, &state.Lock); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal lock data: %w", err)
-			}
+		if err := scanStateLock(&state, lock, lockRefreshed, lockTTL); err != nil {
+			return nil, err
 		}
 		state.Created = time.Unix(created, 0)
 		state.Updated = time.Unix(updated, 0)
@@ -180,104 +289,271 @@ func (db *DB) LoadStates() ([]model.State, error) {
 	return states, nil
 }
 
+// Saves the path and lock time to live of a state. The lock itself is only
+// ever modified through the locking functions.
 // Returns (true, nil) on successful save
 func (db *DB) SaveState(state *model.State) (bool, error) {
-	lock, err := json.Marshal(state.Lock)
-	if err != nil {
-		return false, fmt.Errorf("failed to marshal lock data: %w", err)
-	}
-	_, err = db.Exec(
-		`UPDATE states
-           SET lock = jsonb(?),
-               path = ?
-           WHERE id = ?`,
-		lock,
-		state.Path,
-		state.Id)
-	if err != nil {
-		var sqliteErr sqlite3.Error
-		if errors.As(err, &sqliteErr) {
-			if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
-				return false, nil
+	var lockTTL *int64
+	if state.LockTTL != nil {
+		seconds := int64(*state.LockTTL / time.Second)
+		lockTTL = &seconds
+	}
+	ret := false
+	return ret, db.WithTransaction(func(tx *sql.Tx) error {
+		var previousPath string
+		err := tx.QueryRowContext(db.ctx,
+			`SELECT path FROM states WHERE id = ?;`,
+			state.Id).Scan(&previousPath)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				ret = true
+				return nil
 			}
+			return fmt.Errorf("failed to select path of state id %s: %w", state.Id, err)
 		}
-		return false, fmt.Errorf("failed to update state id %s: %w", state.Id, err)
+		_, err = tx.ExecContext(db.ctx,
+			`UPDATE states
+               SET lock_ttl = ?,
+                   path = ?
+               WHERE id = ?`,
+			lockTTL,
+			state.Path,
+			state.Id)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) {
+				if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
+					return nil
+				}
+			}
+			return fmt.Errorf("failed to update state id %s: %w", state.Id, err)
+		}
+		ret = true
+		if previousPath == state.Path {
+			return nil
+		}
+		return db.enqueueEvent(tx, state.Path, &model.WebhookEvent{
+			Event:        model.WebhookStateRenamed,
+			PreviousPath: previousPath,
+			State:        &model.WebhookState{Id: state.Id, Path: state.Path},
+		})
+	})
+}
+
+var (
+	ErrInvalidState     = errors.New("invalid state")
+	ErrLineageMismatch  = errors.New("state lineage mismatch")
+	ErrSerialRegression = errors.New("state serial regression")
+)
+
+// Returns nil if the next state can replace the current one, meaning it has
+// the same lineage and a greater serial
+func checkStateRegression(current []byte, next *tfstate.State) error {
+	currentState, err := tfstate.Parse(current)
+	if err != nil {
+		// versions pushed before tfstated validated states cannot be compared
+		return nil
 	}
-	return true, nil
+	if currentState.Lineage != next.Lineage {
+		return fmt.Errorf("%w: pushed lineage %s differs from current lineage %s",
+			ErrLineageMismatch, next.Lineage, currentState.Lineage)
+	}
+	if *next.Serial <= *currentState.Serial {
+		return fmt.Errorf("%w: pushed serial %d is not greater than current serial %d",
+			ErrSerialRegression, *next.Serial, *currentState.Serial)
+	}
+	return nil
 }
 
-// returns true in case of lock mismatch
-func (db *DB) SetState(path string, accountId uuid.UUID, data []byte, lockId string) (bool, error) {
-	encryptedData, err := db.dataEncryptionKey.EncryptAES256(data)
+// Returns ErrInvalidState if the data is not a valid state. Unless force is
+// true, returns ErrLockIdMismatch or ErrStateLocked when the lockId does not
+// match the lock of the state (see checkLockOwnership) and ErrLineageMismatch
+// or ErrSerialRegression when the data would replace the current version with
+// an older or unrelated state. Pushing the current version again is a no-op
+func (db *DB) SetState(path string, accountId uuid.UUID, data []byte, lockId string, force bool) error {
+	state, err := tfstate.Parse(data)
 	if err != nil {
-		return false, fmt.Errorf("failed to encrypt state data: %w", err)
+		return fmt.Errorf("%w: %w", ErrInvalidState, err)
 	}
-	ret := false
-	return ret, db.WithTransaction(func(tx *sql.Tx) error {
-		var (
-			stateId  string
-			lockData *string
-		)
-		if err := tx.QueryRowContext(db.ctx, `SELECT id, lock->>'ID' FROM states WHERE path = ?;`, path).Scan(&stateId, &lockData); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				var stateUUID uuid.UUID
-				if err := stateUUID.Generate(uuid.V7); err != nil {
-					return fmt.Errorf("failed to generate state id: %w", err)
-				}
-				_, err := tx.ExecContext(db.ctx, `INSERT INTO states(id, path) VALUES (?, ?)`, stateUUID, path)
-				if err != nil {
-					return fmt.Errorf("failed to insert new state: %w", err)
-				}
-				stateId = stateUUID.String()
-			} else {
-				return fmt.Errorf("failed to select lock data from state: %w", err)
+	return db.WithTransaction(func(tx *sql.Tx) error {
+		return db.setState(tx, path, accountId, data, state, lockId, force)
+	})
+}
+
+// Returns the latest version of a state and its data, or nil if the state has
+// no versions
+func (db *DB) loadLatestVersion(tx *sql.Tx, stateId uuid.UUID) (*storedVersion, []byte, error) {
+	var latest storedVersion
+	err := tx.QueryRowContext(db.ctx,
+		`SELECT `+storedVersionColumns+`
+           FROM versions
+           `+storedVersionJoin+`
+           WHERE versions.state_id = ?
+           ORDER BY versions.id DESC
+           LIMIT 1;`,
+		stateId).Scan(latest.scanTargets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to select latest version: %w", err)
+	}
+	current, err := db.openStoredVersion(&latest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open latest version: %w", err)
+	}
+	return &latest, current, nil
+}
+
+func (db *DB) setState(tx *sql.Tx, path string, accountId uuid.UUID, data []byte, state *tfstate.State, lockId string, force bool) error {
+	var (
+		stateId  uuid.UUID
+		lockData *string
+		newState bool
+	)
+	if err := tx.QueryRowContext(db.ctx, `SELECT id, lock->>'ID' FROM states WHERE path = ?;`, path).Scan(&stateId, &lockData); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			newState = true
+			if err := stateId.Generate(uuid.V7); err != nil {
+				return fmt.Errorf("failed to generate state id: %w", err)
+			}
+			_, err := tx.ExecContext(db.ctx, `INSERT INTO states(id, path) VALUES (?, ?)`, stateId, path)
+			if err != nil {
+				return fmt.Errorf("failed to insert new state: %w", err)
 			}
+		} else {
+			return fmt.Errorf("failed to select lock data from state: %w", err)
 		}
+	}
 
-		if lockId != "" && (lockData == nil || lockId != *lockData) {
-			ret = true
-			return fmt.Errorf("failed to update state: lock ID mismatch")
+	if !force {
+		if err := db.checkLockOwnership(lockData, lockId); err != nil {
+			return err
+		}
+	}
+	latest, current, err := db.loadLatestVersion(tx, stateId)
+	if err != nil {
+		return err
+	}
+	var (
+		latestBaseId *uuid.UUID
+		latestId     *uuid.UUID
+	)
+	if latest != nil {
+		latestBaseId = latest.baseId
+		latestId = &latest.id
+		if bytes.Equal(current, data) {
+			return nil
 		}
-		var versionId uuid.UUID
-		if err := versionId.Generate(uuid.V7); err != nil {
-			return fmt.Errorf("failed to generate version id: %w", err)
+		if !force {
+			if err := checkStateRegression(current, state); err != nil {
+				return err
+			}
 		}
-		_, err = tx.ExecContext(db.ctx,
-			`INSERT INTO versions(id, account_id, state_id, data, lock)
-               SELECT :versionId, :accountId, :stateId, :data, lock
+	}
+	baseId, stored, err := db.encodeVersionData(tx, latestId, latestBaseId, current, data)
+	if err != nil {
+		return fmt.Errorf("failed to encode new state version: %w", err)
+	}
+	// Version ids are UUIDv7 which only have a millisecond precision, make
+	// sure a version pushed in the same millisecond as the latest one, or
+	// after the clock went backwards, is ordered after it
+	var versionId uuid.UUID
+	if err := versionId.Generate(uuid.V7); err != nil {
+		return fmt.Errorf("failed to generate version id: %w", err)
+	}
+	if latestId != nil && bytes.Compare(versionId[:], latestId[:]) <= 0 {
+		if versionId, err = nextVersionId(*latestId); err != nil {
+			return err
+		}
+	}
+	key, err := db.loadDataKey(tx, stateId)
+	if err != nil {
+		return err
+	}
+	sealed, err := db.sealVersionData(stored, key, stateId, versionId)
+	if err != nil {
+		return err
+	}
+	created := time.Now().Unix()
+	dataHash, chainHash, err := db.chainVersion(tx, key, stateId, versionId, accountId, latestId, created, data)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+	_, err = tx.ExecContext(db.ctx,
+		`INSERT INTO versions(id, account_id, state_id, base_id, chain_hash, compression, created, data, data_hash, data_key, envelope, key_id, lock, md5)
+               SELECT :versionId, :accountId, :stateId, :baseId, :chainHash, :compression, :created, :data, :dataHash, :dataKey, :envelope, :keyId, lock, :md5
                  FROM states
                  WHERE states.id = :stateId;`,
-			sql.Named("accountId", accountId),
-			sql.Named("data", encryptedData),
-			sql.Named("stateId", stateId),
-			sql.Named("versionId", versionId))
-		if err != nil {
-			return fmt.Errorf("failed to insert new state version: %w", err)
-		}
-		_, err = tx.ExecContext(db.ctx,
-			`UPDATE states SET updated = ? WHERE id = ?;`,
-			time.Now().UTC().Unix(),
-			stateId)
-		if err != nil {
-			return fmt.Errorf("failed to touch updated for state: %w", err)
+		sql.Named("accountId", accountId),
+		sql.Named("baseId", baseId),
+		sql.Named("chainHash", chainHash),
+		sql.Named("compression", sealed.compression),
+		sql.Named("created", created),
+		sql.Named("data", sealed.data),
+		sql.Named("dataHash", dataHash),
+		sql.Named("dataKey", sealed.dataKey),
+		sql.Named("envelope", sealed.envelope),
+		sql.Named("keyId", sealed.keyId),
+		sql.Named("md5", sum[:]),
+		sql.Named("stateId", stateId),
+		sql.Named("versionId", versionId))
+	if err != nil {
+		return fmt.Errorf("failed to insert new state version: %w", err)
+	}
+	_, err = tx.ExecContext(db.ctx,
+		`UPDATE states SET updated = ? WHERE id = ?;`,
+		created,
+		stateId)
+	if err != nil {
+		return fmt.Errorf("failed to touch updated for state: %w", err)
+	}
+	account, err := db.webhookAccount(tx, accountId)
+	if err != nil {
+		return err
+	}
+	webhookState := &model.WebhookState{Id: stateId, Path: path}
+	if newState {
+		if err := db.enqueueEvent(tx, path, &model.WebhookEvent{
+			Account: account,
+			Event:   model.WebhookStateCreated,
+			State:   webhookState,
+		}); err != nil {
+			return err
 		}
-		min := time.Now().Add(time.Duration(db.versionsHistoryMinimumDays) * -24 * time.Hour)
-		_, err = tx.ExecContext(db.ctx,
-			`DELETE FROM versions
-               WHERE state_id = (SELECT id
-                                   FROM states
-                                   WHERE path = :path)
-               AND id < (SELECT MIN(id)
-                           FROM(SELECT versions.id
-                                  FROM versions
-                                  JOIN states ON states.id = versions.state_id
-                                  WHERE states.path = :path AND versions.created < :min
-                                  ORDER BY versions.id DESC
-                                  LIMIT :limit));`,
-			sql.Named("limit", db.versionsHistoryLimit),
-			sql.Named("min", min),
-			sql.Named("path", path),
-		)
+	}
+	if err := db.enqueueEvent(tx, path, &model.WebhookEvent{
+		Account:   account,
+		Event:     model.WebhookVersionCreated,
+		State:     webhookState,
+		VersionId: &versionId,
+	}); err != nil {
 		return err
-	})
+	}
+	return db.pruneVersions(tx, stateId)
+}
+
+// Returns the UUIDv7 following an id by incrementing its random bits, keeping
+// its timestamp, version and variant
+func nextVersionId(latest uuid.UUID) (uuid.UUID, error) {
+	id := latest
+	for i := 15; i >= 9; i-- {
+		if id[i]++; id[i] != 0 {
+			return id, nil
+		}
+	}
+	if id[8]&0x3f != 0x3f {
+		id[8]++
+		return id, nil
+	}
+	id[8] &^= 0x3f
+	if id[7]++; id[7] != 0 {
+		return id, nil
+	}
+	if id[6]&0x0f != 0x0f {
+		id[6]++
+		return id, nil
+	}
+	return uuid.UUID{}, fmt.Errorf("failed to generate a version id ordered after %s", latest)
 }
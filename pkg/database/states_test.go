@@ -0,0 +1,51 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+func TestNextVersionId(t *testing.T) {
+	latest := uuid.GenerateV7Zero(time.Now().Add(time.Hour))
+	tests := []struct {
+		latest uuid.UUID
+		msg    string
+	}{
+		{latest, "with zero random bits"},
+		{uuid.MustGenerate(uuid.V7), "with random bits"},
+		{func() uuid.UUID {
+			id := latest
+			id[8] |= 0x3f
+			for i := 9; i < 16; i++ {
+				id[i] = 0xff
+			}
+			return id
+		}(), "when the lower random bits overflow"},
+	}
+	for _, tt := range tests {
+		id, err := nextVersionId(tt.latest)
+		if err != nil {
+			t.Fatalf("failed to get the next version id %s: %+v", tt.msg, err)
+		}
+		if bytes.Compare(id[:], tt.latest[:]) <= 0 {
+			t.Errorf("%s should be ordered after %s %s", id, tt.latest, tt.msg)
+		}
+		if !id.V7Time().Equal(tt.latest.V7Time()) || id[6]>>4 != 7 || id[8]>>6 != 2 {
+			t.Errorf("%s should keep the timestamp, version and variant of %s %s", id, tt.latest, tt.msg)
+		}
+	}
+
+	exhausted := latest
+	exhausted[6] |= 0x0f
+	exhausted[7] = 0xff
+	exhausted[8] |= 0x3f
+	for i := 9; i < 16; i++ {
+		exhausted[i] = 0xff
+	}
+	if _, err := nextVersionId(exhausted); err == nil {
+		t.Error("getting the next version id should fail when the random bits are exhausted")
+	}
+}
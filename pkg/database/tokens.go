@@ -0,0 +1,153 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+	"github.com/mattn/go-sqlite3"
+	"go.n16f.net/uuid"
+)
+
+// Returns the token secret, which is not stored and cannot be retrieved later.
+// Returns ("", nil, nil) if the account already has a token with this name
+func (db *DB) CreateToken(account *model.Account, name string, scope model.Permission, expires *time.Time) (string, *model.Token, error) {
+	if !scope.Valid() {
+		return "", nil, fmt.Errorf("invalid token scope %s", scope)
+	}
+	var tokenId uuid.UUID
+	if err := tokenId.Generate(uuid.V7); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token id: %w", err)
+	}
+	secret := model.TokenPrefix + base64.RawURLEncoding.EncodeToString(scrypto.RandomBytes(32))
+	var expiresUnix *int64
+	if expires != nil {
+		e := expires.Unix()
+		expiresUnix = &e
+	}
+	_, err := db.Exec(
+		`INSERT INTO tokens(id, account_id, name, hash, scope, expires)
+           VALUES (?, ?, ?, ?, ?, ?);`,
+		tokenId,
+		account.Id,
+		name,
+		helpers.HashToken([]byte(secret), db.sessionsSalt.Bytes()),
+		scope,
+		expiresUnix,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) {
+			if sqliteErr.Code == sqlite3.ErrNo(sqlite3.ErrConstraint) {
+				return "", nil, nil
+			}
+		}
+		return "", nil, fmt.Errorf("failed to insert new token: %w", err)
+	}
+	return secret, &model.Token{
+		AccountId: account.Id,
+		Created:   time.Now(),
+		Expires:   expires,
+		Id:        tokenId,
+		Name:      name,
+		Scope:     scope,
+	}, nil
+}
+
+// returns true in case of successful deletion
+func (db *DB) DeleteToken(account *model.Account, id uuid.UUID) (bool, error) {
+	result, err := db.Exec(
+		`DELETE FROM tokens WHERE id = ? AND account_id = ?;`,
+		id,
+		account.Id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete token %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	db.credentials.invalidate(account.Id)
+	return n == 1, nil
+}
+
+func (db *DB) DeleteTokens(account *model.Account) error {
+	_, err := db.Exec(`DELETE FROM tokens WHERE account_id = ?;`, account.Id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tokens of account %s: %w", account.Username, err)
+	}
+	db.credentials.invalidate(account.Id)
+	return nil
+}
+
+// Returns (nil, nil) if the secret does not match any of the account's tokens
+func (db *DB) LoadTokenBySecret(account *model.Account, secret string) (*model.Token, error) {
+	tokens, err := db.loadTokens(
+		`SELECT account_id, created, expires, id, last_used, name, scope
+           FROM tokens
+           WHERE account_id = ? AND hash = ?;`,
+		account.Id,
+		helpers.HashToken([]byte(secret), db.sessionsSalt.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return &tokens[0], nil
+}
+
+func (db *DB) LoadTokensByAccount(account *model.Account) ([]model.Token, error) {
+	return db.loadTokens(
+		`SELECT account_id, created, expires, id, last_used, name, scope
+           FROM tokens
+           WHERE account_id = ?
+           ORDER BY name;`,
+		account.Id)
+}
+
+func (db *DB) loadTokens(query string, args ...any) ([]model.Token, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokens from database: %w", err)
+	}
+	defer rows.Close()
+	tokens := make([]model.Token, 0)
+	for rows.Next() {
+		var (
+			token    model.Token
+			created  int64
+			expires  *int64
+			lastUsed *int64
+		)
+		err = rows.Scan(
+			&token.AccountId,
+			&created,
+			&expires,
+			&token.Id,
+			&lastUsed,
+			&token.Name,
+			&token.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load token from row: %w", err)
+		}
+		token.Created = time.Unix(created, 0)
+		if expires != nil {
+			e := time.Unix(*expires, 0)
+			token.Expires = &e
+		}
+		if lastUsed != nil {
+			l := time.Unix(*lastUsed, 0)
+			token.LastUsed = &l
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load tokens from rows: %w", err)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+// Backend requests would serialize on the single write connection if each of
+// them updated the last_login or last_used timestamps. Those updates are
+// instead recorded in memory and written in batches
+type touches struct {
+	accounts map[uuid.UUID]int64
+	mutex    sync.Mutex
+	tokens   map[uuid.UUID]int64
+}
+
+func newTouches() *touches {
+	return &touches{
+		accounts: make(map[uuid.UUID]int64),
+		tokens:   make(map[uuid.UUID]int64),
+	}
+}
+
+func (t *touches) account(id uuid.UUID) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.accounts[id] = time.Now().UTC().Unix()
+}
+
+func (t *touches) token(id uuid.UUID) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tokens[id] = time.Now().UTC().Unix()
+}
+
+// Writes the pending last_login and last_used timestamps to the database
+func (db *DB) FlushTouches() error {
+	return db.flushTouches(db.ctx)
+}
+
+func (db *DB) flushTouches(ctx context.Context) error {
+	db.touches.mutex.Lock()
+	accounts := db.touches.accounts
+	tokens := db.touches.tokens
+	db.touches.accounts = make(map[uuid.UUID]int64)
+	db.touches.tokens = make(map[uuid.UUID]int64)
+	db.touches.mutex.Unlock()
+	if len(accounts) == 0 && len(tokens) == 0 {
+		return nil
+	}
+	tx, err := db.writeDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for id, now := range accounts {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE accounts SET last_login = MAX(last_login, ?) WHERE id = ?`,
+			now, id); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to update last_login for account %s: %w", id, err)
+		}
+	}
+	for id, now := range tokens {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE tokens SET last_used = ? WHERE id = ?`,
+			now, id); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to update last_used for token %s: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) flushTouchesLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			if err := db.flushTouches(context.Background()); err != nil {
+				slog.Error("failed to flush last login timestamps", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"sync"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/keyprovider"
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+)
+
+// When TFSTATED_UNSEAL_THRESHOLD is set, tfstated starts sealed: a data
+// encryption key is split in Shamir shares held by operators, and it remains
+// unavailable until enough shares are submitted to rebuild it. It is then the
+// only data encryption key, so that no key held in the environment can decrypt
+// the database. Versions and data keys encrypted with other keys must be moved
+// to it with the reencrypt command before tfstated is sealed.
+//
+// The check value of the key, which split-key prints, is configured with
+// TFSTATED_UNSEAL_KEY_CHECK so that shares made up by someone else cannot
+// rebuild a key of their own which tfstated would then use.
+
+var (
+	ErrSealed       = errors.New("tfstated is sealed")
+	ErrUnsealFailed = errors.New("failed to unseal")
+)
+
+type unsealer struct {
+	keyCheck  []byte // the check value of the unseal key, see scrypto.KeyCheckValue
+	keyId     string
+	mutex     sync.Mutex
+	shares    [][]byte
+	threshold int
+}
+
+func (db *DB) Sealed() bool {
+	return db.dataEncryptionKeys.Load() == nil
+}
+
+// Returns how many shares were submitted and how many are required to unseal
+func (db *DB) UnsealProgress() (int, int) {
+	if db.unsealer == nil {
+		return 0, 0
+	}
+	db.unsealer.mutex.Lock()
+	defer db.unsealer.mutex.Unlock()
+	return len(db.unsealer.shares), db.unsealer.threshold
+}
+
+// Submits a share of the unseal key and returns true once tfstated is
+// unsealed. Invalid shares return an error wrapping ErrUnsealFailed. Shares
+// which do not rebuild the key are kept, so that an invalid share cannot
+// discard the valid ones submitted before it.
+func (db *DB) SubmitUnsealShare(share string) (bool, error) {
+	if !db.Sealed() {
+		return true, nil
+	}
+	u := db.unsealer
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if !db.Sealed() {
+		return true, nil
+	}
+	data, err := scrypto.DecodeKeyShare(share)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrUnsealFailed, err)
+	}
+	for _, other := range u.shares {
+		if bytes.Equal(other, data) {
+			return false, fmt.Errorf("%w: this share was already submitted", ErrUnsealFailed)
+		}
+	}
+	u.shares = append(u.shares, data)
+	if len(u.shares) < u.threshold {
+		return false, nil
+	}
+	key := u.combine()
+	if key == nil {
+		return false, fmt.Errorf("%w: the submitted shares do not rebuild the unseal key, some of them are invalid or belong to another key", ErrUnsealFailed)
+	}
+	keys := keyring{
+		active: u.keyId,
+		keys:   map[string]keyprovider.Provider{u.keyId: keyprovider.NewStatic(key)},
+	}
+	if err := db.checkDataEncryptionKeys(&keys); err != nil {
+		return false, err
+	}
+	db.dataEncryptionKeys.Store(&keys)
+	u.shares = nil
+	return true, nil
+}
+
+// Returns the key rebuilt from the latest share and threshold - 1 of the
+// others which matches the key check value, or nil if none does. Combinations
+// without the latest share were tried when their own latest share was
+// submitted, and combinations of shares with the same x coordinate fail to
+// combine. Must be called with the mutex held
+func (u *unsealer) combine() *scrypto.AES256Key {
+	others := u.shares[:len(u.shares)-1]
+	chosen := make([][]byte, 1, u.threshold)
+	chosen[0] = u.shares[len(u.shares)-1]
+	var search func(start int) *scrypto.AES256Key
+	search = func(start int) *scrypto.AES256Key {
+		if len(chosen) == u.threshold {
+			key, err := scrypto.CombineKey(chosen)
+			if err != nil || !hmac.Equal(scrypto.KeyCheckValue(key), u.keyCheck) {
+				return nil
+			}
+			return key
+		}
+		for i := start; i < len(others); i++ {
+			chosen = append(chosen, others[i])
+			if key := search(i + 1); key != nil {
+				return key
+			}
+			chosen = chosen[:len(chosen)-1]
+		}
+		return nil
+	}
+	return search(0)
+}
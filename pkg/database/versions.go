@@ -1,6 +1,7 @@
 package database
 
 import (
+	"crypto/md5"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/tfstate"
 	"go.n16f.net/uuid"
 )
 
@@ -16,19 +18,24 @@ func (db *DB) LoadVersionById(id uuid.UUID) (*model.Version, error) {
 		Id: id,
 	}
 	var (
-		created       int64
-		encryptedData []byte
-		lock          []byte
+		created  int64
+		lock     []byte
+		pinnedAt *int64
+		stored   storedVersion
 	)
 	err := db.QueryRow(
-		`SELECT account_id, state_id, data, json_extract(lock, '$'), created
-           FROM versions WHERE id = ?;`,
-		id).Scan(
+		`SELECT versions.account_id, json_extract(versions.lock, '$'), versions.created,
+                versions.pinned_at, versions.pinned_by, COALESCE(versions.pinned_reason, ''), `+storedVersionColumns+`
+           FROM versions
+           `+storedVersionJoin+`
+           WHERE versions.id = ?;`,
+		id).Scan(append([]any{
 		&version.AccountId,
-		&version.StateId,
-		&encryptedData,
 		&lock,
-		&created)
+		&created,
+		&pinnedAt,
+		&version.PinnedBy,
+		&version.PinnedReason}, stored.scanTargets()...)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -41,16 +48,47 @@ func (db *DB) LoadVersionById(id uuid.UUID) (*model.Version, error) {
 		}
 	}
 	version.Created = time.Unix(created, 0)
-	version.Data, err = db.dataEncryptionKey.DecryptAES256(encryptedData)
+	if pinnedAt != nil {
+		t := time.Unix(*pinnedAt, 0)
+		version.PinnedAt = &t
+	}
+	version.StateId = stored.stateId
+	version.Data, err = db.openStoredVersion(&stored)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt version %s data: %w", id, err)
+		return nil, fmt.Errorf("failed to open version %s data: %w", id, err)
+	}
+	version.MD5 = stored.md5
+	if version.MD5 == nil {
+		sum := md5.Sum(version.Data)
+		version.MD5 = sum[:]
 	}
 	return &version, nil
 }
 
+// Returns the version of the same state which preceded a version, or nil for
+// the first version of a state
+func (db *DB) LoadPreviousVersion(version *model.Version) (*model.Version, error) {
+	var id uuid.UUID
+	err := db.QueryRow(
+		`SELECT id
+           FROM versions
+           WHERE state_id = ? AND id < ?
+           ORDER BY id DESC
+           LIMIT 1;`,
+		version.StateId, version.Id).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to select the version preceding %s: %w", version.Id, err)
+	}
+	return db.LoadVersionById(id)
+}
+
 func (db *DB) LoadVersionsByState(state *model.State) ([]model.Version, error) {
 	rows, err := db.Query(
-		`SELECT account_id, created, data, id, json_extract(lock, '$')
+		`SELECT account_id, created, id, json_extract(lock, '$'),
+                pinned_at, pinned_by, COALESCE(pinned_reason, '')
            FROM versions
            WHERE state_id = ?
            ORDER BY id DESC;`, state.Id)
@@ -61,9 +99,12 @@ func (db *DB) LoadVersionsByState(state *model.State) ([]model.Version, error) {
 	versions := make([]model.Version, 0)
 	for rows.Next() {
 		version := model.Version{StateId: state.Id}
-		var created int64
-		var lock []byte
-		err = rows.Scan(&version.AccountId, &created, &version.Data, &version.Id, &lock)
+		var (
+			created  int64
+			lock     []byte
+			pinnedAt *int64
+		)
+		err = rows.Scan(&version.AccountId, &created, &version.Id, &lock, &pinnedAt, &version.PinnedBy, &version.PinnedReason)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load version from row: %w", err)
 		}
@@ -73,6 +114,10 @@ func (db *DB) LoadVersionsByState(state *model.State) ([]model.Version, error) {
 			}
 		}
 		version.Created = time.Unix(created, 0)
+		if pinnedAt != nil {
+			t := time.Unix(*pinnedAt, 0)
+			version.PinnedAt = &t
+		}
 		versions = append(versions, version)
 	}
 	if err := rows.Err(); err != nil {
@@ -83,7 +128,8 @@ func (db *DB) LoadVersionsByState(state *model.State) ([]model.Version, error) {
 
 func (db *DB) LoadVersionsByAccount(account *model.Account) ([]model.Version, error) {
 	rows, err := db.Query(
-		`SELECT created, data, id, json_extract(lock, '$'), state_id
+		`SELECT created, id, json_extract(lock, '$'), state_id,
+                pinned_at, pinned_by, COALESCE(pinned_reason, '')
            FROM versions
            WHERE account_id = ?
            ORDER BY id DESC;`, account.Id)
@@ -94,9 +140,12 @@ func (db *DB) LoadVersionsByAccount(account *model.Account) ([]model.Version, er
 	versions := make([]model.Version, 0)
 	for rows.Next() {
 		version := model.Version{AccountId: account.Id}
-		var created int64
-		var lock []byte
-		err = rows.Scan(&created, &version.Data, &version.Id, &lock, &version.StateId)
+		var (
+			created  int64
+			lock     []byte
+			pinnedAt *int64
+		)
+		err = rows.Scan(&created, &version.Id, &lock, &version.StateId, &pinnedAt, &version.PinnedBy, &version.PinnedReason)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load version from row: %w", err)
 		}
@@ -106,6 +155,10 @@ func (db *DB) LoadVersionsByAccount(account *model.Account) ([]model.Version, er
 			}
 		}
 		version.Created = time.Unix(created, 0)
+		if pinnedAt != nil {
+			t := time.Unix(*pinnedAt, 0)
+			version.PinnedAt = &t
+		}
 		versions = append(versions, version)
 	}
 	if err := rows.Err(); err != nil {
@@ -113,3 +166,74 @@ func (db *DB) LoadVersionsByAccount(account *model.Account) ([]model.Version, er
 	}
 	return versions, nil
 }
+
+// Pushes the data of a past version again as the new current version of its
+// state, with the serial of the current version incremented. Returns
+// ErrStateLocked if the state is locked since the restoring account cannot
+// own the lock
+func (db *DB) RestoreVersion(version *model.Version, accountId uuid.UUID) error {
+	return db.WithTransaction(func(tx *sql.Tx) error {
+		var (
+			path     string
+			lockData *string
+		)
+		if err := tx.QueryRowContext(db.ctx,
+			`SELECT path, lock->>'ID' FROM states WHERE id = ?;`,
+			version.StateId).Scan(&path, &lockData); err != nil {
+			return fmt.Errorf("failed to select state %s: %w", version.StateId, err)
+		}
+		if lockData != nil {
+			return ErrStateLocked
+		}
+		_, current, err := db.loadLatestVersion(tx, version.StateId)
+		if err != nil {
+			return err
+		}
+		currentState, err := tfstate.Parse(current)
+		if err != nil {
+			return fmt.Errorf("%w: the current version cannot be parsed: %w", ErrInvalidState, err)
+		}
+		data, err := tfstate.SetSerial(version.Data, *currentState.Serial+1)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidState, err)
+		}
+		state, err := tfstate.Parse(data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidState, err)
+		}
+		return db.setState(tx, path, accountId, data, state, "", false)
+	})
+}
+
+// Exempts a version from the versions history pruning
+func (db *DB) PinVersion(version *model.Version, accountId uuid.UUID, reason string) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(
+		`UPDATE versions
+           SET pinned_at = ?, pinned_by = ?, pinned_reason = ?
+           WHERE id = ?;`,
+		now.Unix(), accountId, reason, version.Id)
+	if err != nil {
+		return fmt.Errorf("failed to pin version %s: %w", version.Id, err)
+	}
+	version.PinnedAt = &now
+	version.PinnedBy = &accountId
+	version.PinnedReason = reason
+	return nil
+}
+
+// Subjects a pinned version to the versions history pruning again
+func (db *DB) UnpinVersion(version *model.Version) error {
+	_, err := db.Exec(
+		`UPDATE versions
+           SET pinned_at = NULL, pinned_by = NULL, pinned_reason = NULL
+           WHERE id = ?;`,
+		version.Id)
+	if err != nil {
+		return fmt.Errorf("failed to unpin version %s: %w", version.Id, err)
+	}
+	version.PinnedAt = nil
+	version.PinnedBy = nil
+	version.PinnedReason = ""
+	return nil
+}
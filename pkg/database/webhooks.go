@@ -0,0 +1,528 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/webhooks"
+	"go.n16f.net/uuid"
+)
+
+// Webhook deliveries are queued in the same transaction as the event they
+// describe, then sent by a background loop which retries failed deliveries
+// with an exponential backoff.
+//
+// Webhook secrets are wrapped by a data encryption key from the keyring like
+// the data keys of states, and stored base64 encoded along with the id of the
+// key.
+
+const (
+	deliveriesBackoffMax      = time.Hour
+	deliveriesBackoffMin      = 30 * time.Second
+	deliveriesBatchSize       = 16
+	deliveriesTimeout         = 10 * time.Second
+	deliveriesMaxAttempts     = 10
+	deliveriesRetentionPeriod = 7 * 24 * time.Hour
+	webhooksSecretSizeInBits  = 256
+)
+
+func (db *DB) CreateWebhook(url string, pathPrefix string, events []string) (*model.Webhook, error) {
+	webhook := model.Webhook{
+		Created:    time.Now(),
+		Enabled:    true,
+		Events:     events,
+		PathPrefix: pathPrefix,
+		URL:        url,
+	}
+	if err := webhook.Id.Generate(uuid.V7); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook id: %w", err)
+	}
+	secret := make([]byte, webhooksSecretSizeInBits/8)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	webhook.Secret = hex.EncodeToString(secret)
+	wrapped, keyId, err := db.wrapWebhookSecret(secret, webhook.Id)
+	if err != nil {
+		return nil, err
+	}
+	eventsData, err := json.Marshal(events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook events: %w", err)
+	}
+	_, err = db.Exec(
+		`INSERT INTO webhooks(id, url, path_prefix, events, secret, secret_key_id, created)
+           VALUES (?, ?, ?, jsonb(?), ?, ?, ?);`,
+		webhook.Id, url, pathPrefix, eventsData, wrapped, keyId, webhook.Created.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert new webhook: %w", err)
+	}
+	return &webhook, nil
+}
+
+func (db *DB) DeleteWebhook(webhook *model.Webhook) error {
+	if _, err := db.Exec(`DELETE FROM webhooks WHERE id = ?;`, webhook.Id); err != nil {
+		return fmt.Errorf("failed to delete webhook %s: %w", webhook.Id, err)
+	}
+	return nil
+}
+
+// The additional data authenticated with a wrapped webhook secret, which binds
+// it to its webhook
+func webhookSecretAdditionalData(webhookId uuid.UUID) []byte {
+	return append([]byte("webhook secret "), webhookId[:]...)
+}
+
+// Wraps a webhook secret with the active data encryption key and returns it
+// base64 encoded along with the id of the key
+func (db *DB) wrapWebhookSecret(secret []byte, webhookId uuid.UUID) (string, string, error) {
+	keys, err := db.keyring()
+	if err != nil {
+		return "", "", err
+	}
+	active := keys.active
+	wrappingKey, err := keys.get(active)
+	if err != nil {
+		return "", "", err
+	}
+	wrapped, err := wrappingKey.Wrap(db.ctx, secret, webhookSecretAdditionalData(webhookId))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to wrap secret of webhook %s: %w", webhookId, err)
+	}
+	return base64.StdEncoding.EncodeToString(wrapped), active, nil
+}
+
+func (db *DB) unwrapWebhookSecret(stored string, keyId string, webhookId uuid.UUID) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret of webhook %s: %w", webhookId, err)
+	}
+	keys, err := db.keyring()
+	if err != nil {
+		return nil, err
+	}
+	wrappingKey, err := keys.get(keyId)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := wrappingKey.Unwrap(db.ctx, wrapped, webhookSecretAdditionalData(webhookId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap secret of webhook %s: %w", webhookId, err)
+	}
+	return secret, nil
+}
+
+// Loads a webhook along with its unwrapped secret
+func (db *DB) LoadWebhookById(id uuid.UUID) (*model.Webhook, error) {
+	webhooks, err := db.loadWebhooks(db.readDB,
+		`SELECT created, enabled, json(events), id, path_prefix, url
+           FROM webhooks
+           WHERE id = ?;`,
+		id)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	var keyId, stored string
+	err = db.QueryRow(`SELECT secret, secret_key_id FROM webhooks WHERE id = ?;`, id).Scan(&stored, &keyId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select secret of webhook %s: %w", id, err)
+	}
+	secret, err := db.unwrapWebhookSecret(stored, keyId, id)
+	if err != nil {
+		return nil, err
+	}
+	webhooks[0].Secret = hex.EncodeToString(secret)
+	return &webhooks[0], nil
+}
+
+// Loads the webhooks without their secrets
+func (db *DB) LoadWebhooks() ([]model.Webhook, error) {
+	return db.loadWebhooks(db.readDB,
+		`SELECT created, enabled, json(events), id, path_prefix, url
+           FROM webhooks
+           ORDER BY path_prefix, url;`)
+}
+
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (db *DB) loadWebhooks(q rowsQuerier, query string, args ...any) ([]model.Webhook, error) {
+	rows, err := q.QueryContext(db.ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhooks from database: %w", err)
+	}
+	defer rows.Close()
+	webhooks := make([]model.Webhook, 0)
+	for rows.Next() {
+		var (
+			webhook model.Webhook
+			created int64
+			events  []byte
+		)
+		err := rows.Scan(&created, &webhook.Enabled, &events, &webhook.Id, &webhook.PathPrefix, &webhook.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load webhook from row: %w", err)
+		}
+		if err := json.Unmarshal(events, &webhook.Events); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal webhook events: %w", err)
+		}
+		webhook.Created = time.Unix(created, 0)
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load webhooks from rows: %w", err)
+	}
+	return webhooks, nil
+}
+
+func (db *DB) SaveWebhook(webhook *model.Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook events: %w", err)
+	}
+	_, err = db.Exec(
+		`UPDATE webhooks
+           SET enabled = ?,
+               events = jsonb(?),
+               path_prefix = ?,
+               url = ?
+           WHERE id = ?;`,
+		webhook.Enabled, events, webhook.PathPrefix, webhook.URL, webhook.Id)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook %s: %w", webhook.Id, err)
+	}
+	return nil
+}
+
+// Returns the most recent deliveries of a webhook
+func (db *DB) LoadWebhookDeliveries(webhook *model.Webhook, limit int) ([]model.WebhookDelivery, error) {
+	rows, err := db.Query(
+		`SELECT attempts, created, delivered, event, id, last_error, last_status, next_attempt
+           FROM webhooks_deliveries
+           WHERE webhook_id = ?
+           ORDER BY rowid DESC
+           LIMIT ?;`,
+		webhook.Id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook deliveries from database: %w", err)
+	}
+	defer rows.Close()
+	deliveries := make([]model.WebhookDelivery, 0)
+	for rows.Next() {
+		var (
+			delivery    model.WebhookDelivery
+			created     int64
+			delivered   *int64
+			nextAttempt int64
+		)
+		err := rows.Scan(&delivery.Attempts, &created, &delivered, &delivery.Event, &delivery.Id,
+			&delivery.LastError, &delivery.LastStatus, &nextAttempt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load webhook delivery from row: %w", err)
+		}
+		delivery.Created = time.Unix(created, 0)
+		if delivered != nil {
+			t := time.Unix(*delivered, 0)
+			delivery.Delivered = &t
+		}
+		delivery.NextAttempt = time.Unix(nextAttempt, 0)
+		delivery.WebhookId = webhook.Id
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load webhook deliveries from rows: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Returns true if the delivery exists and was queued to be sent again
+func (db *DB) RetryWebhookDelivery(webhook *model.Webhook, deliveryId uuid.UUID) (bool, error) {
+	result, err := db.Exec(
+		`UPDATE webhooks_deliveries
+           SET attempts = 0,
+               delivered = NULL,
+               next_attempt = ?
+           WHERE id = ? AND webhook_id = ?;`,
+		time.Now().Unix(), deliveryId, webhook.Id)
+	if err != nil {
+		return false, fmt.Errorf("failed to retry webhook delivery %s: %w", deliveryId, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 1 {
+		db.notifyWebhooks()
+	}
+	return n == 1, nil
+}
+
+// Queues a delivery of an event to every enabled webhook subscribed to it
+func (db *DB) enqueueWebhookDeliveries(tx *sql.Tx, path string, event *model.WebhookEvent) error {
+	hooks, err := db.loadWebhooks(tx,
+		`SELECT created, enabled, json(events), id, path_prefix, url
+           FROM webhooks
+           WHERE enabled;`)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	for _, webhook := range hooks {
+		if !webhook.Matches(event.Event, path) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				return fmt.Errorf("failed to marshal webhook event: %w", err)
+			}
+		}
+		var deliveryId uuid.UUID
+		if err := deliveryId.Generate(uuid.V7); err != nil {
+			return fmt.Errorf("failed to generate webhook delivery id: %w", err)
+		}
+		_, err := tx.ExecContext(db.ctx,
+			`INSERT INTO webhooks_deliveries(id, webhook_id, event, payload, next_attempt)
+               VALUES (?, ?, ?, ?, ?);`,
+			deliveryId, webhook.Id, event.Event, payload, event.Created.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+	}
+	if payload != nil {
+		db.deliveriesQueued.Store(true)
+	}
+	return nil
+}
+
+// Returns the account of a webhook event, or nil if it does not exist
+func (db *DB) webhookAccount(tx *sql.Tx, accountId uuid.UUID) (*model.WebhookAccount, error) {
+	account := model.WebhookAccount{Id: accountId}
+	err := tx.QueryRowContext(db.ctx,
+		`SELECT username FROM accounts WHERE id = ?;`,
+		accountId).Scan(&account.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to select webhook event account: %w", err)
+	}
+	return &account, nil
+}
+
+// Wakes up the delivery loop
+func (db *DB) notifyWebhooks() {
+	select {
+	case db.webhooksNotify <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the due webhook deliveries and returns how many succeeded
+func (db *DB) DeliverWebhooks() (int, error) {
+	return db.deliverWebhooks(db.ctx)
+}
+
+func (db *DB) deliverWebhooks(ctx context.Context) (int, error) {
+	db.webhooksDelivering.Lock()
+	defer db.webhooksDelivering.Unlock()
+	delivered := 0
+	for {
+		type pending struct {
+			attempts  int
+			event     string
+			id        uuid.UUID
+			payload   []byte
+			secret    string
+			secretKey string
+			url       string
+			webhookId uuid.UUID
+		}
+		rows, err := db.readDB.QueryContext(ctx,
+			`SELECT webhooks_deliveries.attempts, webhooks_deliveries.event, webhooks_deliveries.id,
+                    webhooks_deliveries.payload, webhooks.secret, webhooks.secret_key_id, webhooks.url, webhooks.id
+               FROM webhooks_deliveries
+               JOIN webhooks ON webhooks.id = webhooks_deliveries.webhook_id
+               WHERE webhooks_deliveries.delivered IS NULL
+                 AND webhooks_deliveries.attempts < ?
+                 AND webhooks_deliveries.next_attempt <= ?
+                 AND webhooks.enabled
+               ORDER BY webhooks_deliveries.rowid
+               LIMIT ?;`,
+			deliveriesMaxAttempts, time.Now().Unix(), deliveriesBatchSize)
+		if err != nil {
+			return delivered, fmt.Errorf("failed to select due webhook deliveries: %w", err)
+		}
+		batch := make([]pending, 0)
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.attempts, &p.event, &p.id, &p.payload, &p.secret, &p.secretKey, &p.url, &p.webhookId); err != nil {
+				_ = rows.Close()
+				return delivered, fmt.Errorf("failed to load webhook delivery from row: %w", err)
+			}
+			batch = append(batch, p)
+		}
+		if err := rows.Err(); err != nil {
+			return delivered, fmt.Errorf("failed to load webhook deliveries from rows: %w", err)
+		}
+		if len(batch) == 0 {
+			return delivered, nil
+		}
+		for _, p := range batch {
+			// a secret which fails to unwrap fails this delivery only, unless
+			// the keyring is sealed and no secret can be unwrapped
+			var status int
+			secret, err := db.unwrapWebhookSecret(p.secret, p.secretKey, p.webhookId)
+			if errors.Is(err, ErrSealed) {
+				return delivered, err
+			}
+			if err == nil {
+				deliveryCtx, cancel := context.WithTimeout(ctx, deliveriesTimeout)
+				status, err = webhooks.Deliver(deliveryCtx, db.webhooksClient, p.url, secret, p.id.String(), p.event, p.payload)
+				cancel()
+				if ctx.Err() != nil {
+					return delivered, ctx.Err()
+				}
+			}
+			now := time.Now()
+			var (
+				lastError  *string
+				lastStatus *int
+				success    *int64
+			)
+			if status != 0 {
+				lastStatus = &status
+			}
+			if err != nil {
+				msg := err.Error()
+				lastError = &msg
+				slog.Warn("failed to deliver webhook", "webhook", p.webhookId, "delivery", p.id, "attempt", p.attempts+1, "err", err)
+			} else {
+				t := now.Unix()
+				success = &t
+				delivered++
+			}
+			backoff := min(deliveriesBackoffMin<<min(p.attempts, 16), deliveriesBackoffMax)
+			_, err = db.Exec(
+				`UPDATE webhooks_deliveries
+                   SET attempts = attempts + 1,
+                       delivered = ?,
+                       last_error = ?,
+                       last_status = ?,
+                       next_attempt = ?
+                   WHERE id = ?;`,
+				success, lastError, lastStatus, now.Add(backoff).Unix(), p.id)
+			if err != nil {
+				return delivered, fmt.Errorf("failed to update webhook delivery %s: %w", p.id, err)
+			}
+		}
+	}
+}
+
+// Deletes the deliveries which are done, successfully or not, and older than
+// the retention period
+func (db *DB) pruneWebhookDeliveries() error {
+	_, err := db.Exec(
+		`DELETE FROM webhooks_deliveries
+           WHERE created < ?
+             AND (delivered IS NOT NULL OR attempts >= ?);`,
+		time.Now().Add(-deliveriesRetentionPeriod).Unix(), deliveriesMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) deliverWebhooksLoop(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-db.stop
+		cancel()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+		case <-db.webhooksNotify:
+		}
+		if _, err := db.deliverWebhooks(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to deliver webhooks", "err", err)
+		}
+		if time.Since(lastPrune) > time.Hour {
+			if err := db.pruneWebhookDeliveries(); err != nil {
+				slog.Error("failed to prune webhook deliveries", "err", err)
+			}
+			lastPrune = time.Now()
+		}
+	}
+}
+
+// Wraps with the active data encryption key the webhook secrets wrapped by
+// another key and returns how many were wrapped again
+func (db *DB) RewrapWebhookSecrets() (int, error) {
+	keys, err := db.keyring()
+	if err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	err = db.WithTransaction(func(tx *sql.Tx) error {
+		type row struct {
+			id     uuid.UUID
+			keyId  string
+			stored string
+		}
+		result, err := tx.QueryContext(db.ctx,
+			`SELECT id, secret, secret_key_id
+               FROM webhooks
+               WHERE secret_key_id != ?;`,
+			keys.active)
+		if err != nil {
+			return fmt.Errorf("failed to select webhook secrets: %w", err)
+		}
+		rows := make([]row, 0)
+		for result.Next() {
+			var r row
+			if err := result.Scan(&r.id, &r.stored, &r.keyId); err != nil {
+				_ = result.Close()
+				return fmt.Errorf("failed to load webhook secret from row: %w", err)
+			}
+			rows = append(rows, r)
+		}
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("failed to load webhook secrets from rows: %w", err)
+		}
+		for _, r := range rows {
+			secret, err := db.unwrapWebhookSecret(r.stored, r.keyId, r.id)
+			if err != nil {
+				return err
+			}
+			wrapped, keyId, err := db.wrapWebhookSecret(secret, r.id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(db.ctx,
+				`UPDATE webhooks SET secret = ?, secret_key_id = ? WHERE id = ?;`,
+				wrapped, keyId, r.id)
+			if err != nil {
+				return fmt.Errorf("failed to save secret of webhook %s: %w", r.id, err)
+			}
+			rewrapped++
+		}
+		return nil
+	})
+	return rewrapped, err
+}
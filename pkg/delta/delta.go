@@ -0,0 +1,157 @@
+// Package delta implements a line based binary delta encoding well suited to
+// the pretty printed JSON states written by OpenTofu and Terraform.
+//
+// A delta is a sequence of operations that rebuild the target from the base:
+// either copy a range of bytes from the base, or insert literal bytes. Each
+// operation is an opcode byte followed by unsigned varints.
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	opCopy   byte = 'c' // followed by the offset and length in the base
+	opInsert byte = 'i' // followed by the length and the literal bytes
+
+	// how many candidate positions in the base are considered for each line
+	// of the target, which bounds the encoding time on repetitive inputs
+	maxCandidates = 32
+)
+
+// Splits data in lines that keep their trailing newline
+func splitLines(data []byte) [][]byte {
+	lines := make([][]byte, 0, bytes.Count(data, []byte{'\n'})+1)
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	return lines
+}
+
+type encoder struct {
+	buf     []byte
+	pending []byte
+}
+
+func (e *encoder) copy(offset int, length int) {
+	e.flush()
+	e.buf = append(e.buf, opCopy)
+	e.buf = binary.AppendUvarint(e.buf, uint64(offset))
+	e.buf = binary.AppendUvarint(e.buf, uint64(length))
+}
+
+func (e *encoder) insert(data []byte) {
+	e.pending = append(e.pending, data...)
+}
+
+func (e *encoder) flush() {
+	if len(e.pending) == 0 {
+		return
+	}
+	e.buf = append(e.buf, opInsert)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(e.pending)))
+	e.buf = append(e.buf, e.pending...)
+	e.pending = e.pending[:0]
+}
+
+// Returns a delta that rebuilds target when applied to base
+func Encode(base []byte, target []byte) []byte {
+	baseLines := splitLines(base)
+	offsets := make([]int, len(baseLines)+1)
+	positions := make(map[string][]int)
+	for i, line := range baseLines {
+		offsets[i+1] = offsets[i] + len(line)
+		positions[string(line)] = append(positions[string(line)], i)
+	}
+	// Returns how many lines match starting at base line b and target line t
+	matchLength := func(targetLines [][]byte, b int, t int) int {
+		n := 0
+		for b+n < len(baseLines) && t+n < len(targetLines) && bytes.Equal(baseLines[b+n], targetLines[t+n]) {
+			n++
+		}
+		return n
+	}
+
+	var e encoder
+	targetLines := splitLines(target)
+	next := -1 // the base line following the previous copy
+	for t := 0; t < len(targetLines); {
+		bestStart, bestLength := -1, 0
+		if next >= 0 && next < len(baseLines) {
+			if n := matchLength(targetLines, next, t); n > 0 {
+				bestStart, bestLength = next, n
+			}
+		}
+		if bestLength == 0 {
+			candidates := positions[string(targetLines[t])]
+			if len(candidates) > maxCandidates {
+				candidates = candidates[:maxCandidates]
+			}
+			for _, b := range candidates {
+				if n := matchLength(targetLines, b, t); n > bestLength {
+					bestStart, bestLength = b, n
+				}
+			}
+		}
+		if bestLength == 0 {
+			e.insert(targetLines[t])
+			next = -1
+			t++
+			continue
+		}
+		e.copy(offsets[bestStart], offsets[bestStart+bestLength]-offsets[bestStart])
+		next = bestStart + bestLength
+		t += bestLength
+	}
+	e.flush()
+	return e.buf
+}
+
+// Rebuilds the target from the base and a delta returned by Encode
+func Apply(base []byte, delta []byte) ([]byte, error) {
+	target := make([]byte, 0, len(base))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case opCopy:
+			offset, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errors.New("invalid delta: truncated copy offset")
+			}
+			delta = delta[n:]
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errors.New("invalid delta: truncated copy length")
+			}
+			delta = delta[n:]
+			if offset > uint64(len(base)) || length > uint64(len(base))-offset {
+				return nil, fmt.Errorf("invalid delta: copy of %d bytes at offset %d out of a %d bytes base", length, offset, len(base))
+			}
+			target = append(target, base[offset:offset+length]...)
+		case opInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errors.New("invalid delta: truncated insert length")
+			}
+			delta = delta[n:]
+			if length > uint64(len(delta)) {
+				return nil, fmt.Errorf("invalid delta: insert of %d bytes with only %d bytes left", length, len(delta))
+			}
+			target = append(target, delta[:length]...)
+			delta = delta[length:]
+		default:
+			return nil, fmt.Errorf("invalid delta: unknown operation %q", op)
+		}
+	}
+	return target, nil
+}
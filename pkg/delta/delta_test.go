@@ -0,0 +1,74 @@
+package delta
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEncodeApply(t *testing.T) {
+	state := func(serial int, names ...string) string {
+		resources := make([]string, 0, len(names))
+		for _, name := range names {
+			resources = append(resources, fmt.Sprintf("    {\n      \"name\": \"%s\",\n      \"type\": \"null_resource\"\n    }", name))
+		}
+		return fmt.Sprintf("{\n  \"serial\": %d,\n  \"resources\": [\n%s\n  ]\n}\n", serial, strings.Join(resources, ",\n"))
+	}
+	tests := []struct {
+		base   string
+		target string
+		msg    string
+	}{
+		{"", "", "empty base and target"},
+		{"", "hello\nworld", "empty base"},
+		{"hello\nworld\n", "", "empty target"},
+		{"hello\nworld\n", "hello\nworld\n", "identical"},
+		{"hello\nworld", "hello\nworld\nagain", "base without trailing newline"},
+		{"a\nb\nc\n", "c\nb\na\n", "reordered lines"},
+		{state(1, "a", "b", "c"), state(2, "a", "b", "c"), "a serial bump"},
+		{state(1, "a", "b", "c"), state(2, "a", "c", "d"), "a replaced resource"},
+		{state(1, "a", "b"), state(2, "b", "a", "a", "b"), "repeated resources"},
+	}
+	for _, tt := range tests {
+		delta := Encode([]byte(tt.base), []byte(tt.target))
+		target, err := Apply([]byte(tt.base), delta)
+		if err != nil {
+			t.Errorf("got unexpected error when applying delta for %s: %+v", tt.msg, err)
+		} else if !bytes.Equal(target, []byte(tt.target)) {
+			t.Errorf("got %q for %s, wanted %q", target, tt.msg, tt.target)
+		}
+	}
+}
+
+func TestEncodeSize(t *testing.T) {
+	names := make([]string, 0, 1000)
+	for i := range 1000 {
+		names = append(names, fmt.Sprintf("  \"resource_%d\": {\"type\": \"null_resource\"},\n", i))
+	}
+	base := []byte("{\n  \"serial\": 1,\n" + strings.Join(names, "") + "}\n")
+	target := []byte("{\n  \"serial\": 2,\n" + strings.Join(names, "") + "}\n")
+	delta := Encode(base, target)
+	if len(delta) > 64 {
+		t.Errorf("got a %d bytes delta for a one line change in a %d bytes state", len(delta), len(target))
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	base := []byte("hello\n")
+	tests := []struct {
+		delta []byte
+		msg   string
+	}{
+		{[]byte{'x'}, "an unknown operation"},
+		{[]byte{opCopy}, "a truncated copy"},
+		{[]byte{opCopy, 0}, "a copy without length"},
+		{[]byte{opCopy, 4, 4}, "a copy out of the base"},
+		{[]byte{opInsert, 4, 'a'}, "a truncated insert"},
+	}
+	for _, tt := range tests {
+		if _, err := Apply(base, tt.delta); err == nil {
+			t.Errorf("applying %s should have failed", tt.msg)
+		}
+	}
+}
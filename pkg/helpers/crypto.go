@@ -10,6 +10,7 @@ import (
 const (
 	PBKDF2PasswordIterations = 600000
 	PBKDF2SessionIterations  = 12
+	PBKDF2TokenIterations    = 12
 	SaltSize                 = 32
 )
 
@@ -24,3 +25,7 @@ func HashPassword(password string, salt []byte) []byte {
 func HashSessionId(id []byte, salt []byte) []byte {
 	return pbkdf2.Key(id, salt, PBKDF2SessionIterations, 32, sha256.New)
 }
+
+func HashToken(secret []byte, salt []byte) []byte {
+	return pbkdf2.Key(secret, salt, PBKDF2TokenIterations, 32, sha256.New)
+}
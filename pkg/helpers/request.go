@@ -0,0 +1,15 @@
+package helpers
+
+import (
+	"net"
+	"net/http"
+)
+
+// Returns the address of the client of a request without its port
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
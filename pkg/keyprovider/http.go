@@ -0,0 +1,105 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A provider delegating to a transit style key management service. The key
+// never leaves the service which exposes two endpoints:
+//   - POST <url>/wrap with a {"plaintext": <base64>, "context": <base64>} JSON
+//     body, responding with {"ciphertext": <string>}
+//   - POST <url>/unwrap with a {"ciphertext": <string>, "context": <base64>}
+//     JSON body, responding with {"plaintext": <base64>}
+//
+// The context is the additional data which the service must authenticate with
+// the ciphertext.
+type HTTP struct {
+	client *http.Client
+	token  string
+	url    string
+}
+
+func NewHTTP(url string, token string) *HTTP {
+	return &HTTP{
+		client: &http.Client{Timeout: 10 * time.Second},
+		token:  token,
+		url:    strings.TrimSuffix(url, "/"),
+	}
+}
+
+type httpRequest struct {
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Context    string `json:"context"`
+	Plaintext  string `json:"plaintext,omitempty"`
+}
+
+type httpResponse struct {
+	Ciphertext string `json:"ciphertext"`
+	Plaintext  string `json:"plaintext"`
+}
+
+func (h *HTTP) post(ctx context.Context, endpoint string, request *httpRequest) (*httpResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url+"/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s with key management service: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to %s with key management service: %s: %s", endpoint, resp.Status, strings.TrimSpace(string(message)))
+	}
+	var response httpResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", endpoint, err)
+	}
+	return &response, nil
+}
+
+func (h *HTTP) Wrap(ctx context.Context, data []byte, additionalData []byte) ([]byte, error) {
+	response, err := h.post(ctx, "wrap", &httpRequest{
+		Context:   base64.StdEncoding.EncodeToString(additionalData),
+		Plaintext: base64.StdEncoding.EncodeToString(data),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Ciphertext == "" {
+		return nil, fmt.Errorf("empty ciphertext in wrap response")
+	}
+	return []byte(response.Ciphertext), nil
+}
+
+func (h *HTTP) Unwrap(ctx context.Context, wrapped []byte, additionalData []byte) ([]byte, error) {
+	response, err := h.post(ctx, "unwrap", &httpRequest{
+		Ciphertext: string(wrapped),
+		Context:    base64.StdEncoding.EncodeToString(additionalData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode unwrap response plaintext: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,122 @@
+// Package keyprovider implements the sources of the data encryption keys that
+// protect the data keys of states.
+//
+// A provider either holds a key in memory, read from a file or from the
+// standard output of a helper command, or delegates wrapping and unwrapping
+// data keys to a remote key management service over HTTP so that the key
+// never enters the process.
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+)
+
+// How long a helper command has to print its key
+const commandTimeout = 30 * time.Second
+
+type Provider interface {
+	// Encrypts and authenticates a data key, the same additional data must be
+	// given to Unwrap
+	Wrap(ctx context.Context, data []byte, additionalData []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte, additionalData []byte) ([]byte, error)
+}
+
+// A provider holding its key in memory
+type Static struct {
+	key scrypto.AES256Key
+}
+
+func NewStatic(key *scrypto.AES256Key) *Static {
+	return &Static{key: *key}
+}
+
+// Returns the key itself, which is needed to decrypt the versions stored
+// before tfstated used data keys
+func (s *Static) Key() *scrypto.AES256Key {
+	return &s.key
+}
+
+func (s *Static) Wrap(_ context.Context, data []byte, additionalData []byte) ([]byte, error) {
+	return s.key.Seal(data, additionalData)
+}
+
+func (s *Static) Unwrap(_ context.Context, wrapped []byte, additionalData []byte) ([]byte, error) {
+	return s.key.Open(wrapped, additionalData)
+}
+
+func staticFromBase64(data []byte) (*Static, error) {
+	var s Static
+	if err := s.key.FromBase64(string(bytes.TrimSpace(data))); err != nil {
+		return nil, fmt.Errorf("expected 32 bytes base64 encoded: %w", err)
+	}
+	return &s, nil
+}
+
+// Reads a base64 encoded key from a file
+func FromFile(path string) (*Static, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	s, err := staticFromBase64(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Runs a helper command which must print a base64 encoded key on its standard
+// output
+func FromCommand(ctx context.Context, name string, args ...string) (*Static, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run key command %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	s, err := staticFromBase64(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the output of key command %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// Parses a provider specification:
+//   - file:<path> reads the key from a file
+//   - exec:<command> [<arguments>...] runs a helper command and reads the key
+//     from its standard output, the arguments are separated by spaces
+//   - http://<url> or https://<url> wraps and unwraps data keys with a remote
+//     key management service, see HTTP. The token is sent as a bearer token
+//     unless empty.
+func Parse(ctx context.Context, spec string, token string) (Provider, error) {
+	scheme, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, errors.New("expected file:<path>, exec:<command> or an http(s) url")
+	}
+	switch scheme {
+	case "file":
+		return FromFile(rest)
+	case "exec":
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, errors.New("empty key command")
+		}
+		return FromCommand(ctx, fields[0], fields[1:]...)
+	case "http", "https":
+		return NewHTTP(spec, token), nil
+	default:
+		return nil, fmt.Errorf("unknown key provider %s, expected file, exec, http or https", scheme)
+	}
+}
@@ -0,0 +1,167 @@
+package keyprovider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/scrypto"
+)
+
+const testKeyBase64 = "hP3ZSCnY3LMgfTQjwTaGrhKwdA0yXMXIfv67OJnntqM="
+
+func testWrapUnwrap(t *testing.T, provider Provider, msg string) {
+	data := []byte("a data key")
+	additionalData := []byte("a state")
+	wrapped, err := provider.Wrap(context.Background(), data, additionalData)
+	if err != nil {
+		t.Fatalf("got unexpected error when wrapping with %s: %+v", msg, err)
+	}
+	unwrapped, err := provider.Unwrap(context.Background(), wrapped, additionalData)
+	if err != nil {
+		t.Fatalf("got unexpected error when unwrapping with %s: %+v", msg, err)
+	}
+	if slices.Compare(data, unwrapped) != 0 {
+		t.Errorf("got %v when unwrapping with %s, wanted %v", unwrapped, msg, data)
+	}
+	if _, err := provider.Unwrap(context.Background(), wrapped, []byte("another state")); err == nil {
+		t.Errorf("unwrapping with %s and different additional data should have failed", msg)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(testKeyBase64+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %+v", err)
+	}
+	invalidFile := filepath.Join(dir, "invalid")
+	if err := os.WriteFile(invalidFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %+v", err)
+	}
+	var key scrypto.AES256Key
+	if err := key.FromBase64(testKeyBase64); err != nil {
+		t.Fatalf("failed to decode key: %+v", err)
+	}
+
+	tests := []struct {
+		spec string
+		ok   bool
+		msg  string
+	}{
+		{"file:" + keyFile, true, "a key file"},
+		{"file:" + invalidFile, false, "an invalid key file"},
+		{"file:" + filepath.Join(dir, "missing"), false, "a missing key file"},
+		{"exec:echo " + testKeyBase64, true, "a key command"},
+		{"exec:false", false, "a failing key command"},
+		{"exec:echo not a key", false, "a key command printing an invalid key"},
+		{"exec:", false, "an empty key command"},
+		{"unknown:" + keyFile, false, "an unknown provider"},
+		{keyFile, false, "a specification without provider"},
+	}
+	for _, tt := range tests {
+		provider, err := Parse(context.Background(), tt.spec, "")
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("parsing %s should have failed", tt.msg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got unexpected error when parsing %s: %+v", tt.msg, err)
+		}
+		static, ok := provider.(*Static)
+		if !ok {
+			t.Fatalf("parsing %s should return a static provider", tt.msg)
+		}
+		if *static.Key() != key {
+			t.Errorf("got an unexpected key from %s", tt.msg)
+		}
+		testWrapUnwrap(t, provider, tt.msg)
+	}
+}
+
+// A stand-in for a transit style key management service
+func newTestKMS(t *testing.T, token string) *httptest.Server {
+	var key scrypto.AES256Key
+	if err := key.FromBase64(testKeyBase64); err != nil {
+		t.Fatalf("failed to decode key: %+v", err)
+	}
+	handle := func(fn func(req *httpRequest) (*httpResponse, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+token {
+				http.Error(w, "permission denied", http.StatusForbidden)
+				return
+			}
+			var req httpRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resp, err := fn(&req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /v1/tfstated/wrap", handle(func(req *httpRequest) (*httpResponse, error) {
+		plaintext, err := base64.StdEncoding.DecodeString(req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		context, err := base64.StdEncoding.DecodeString(req.Context)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := key.Seal(plaintext, context)
+		if err != nil {
+			return nil, err
+		}
+		return &httpResponse{Ciphertext: "kms:v1:" + base64.StdEncoding.EncodeToString(ciphertext)}, nil
+	}))
+	mux.Handle("POST /v1/tfstated/unwrap", handle(func(req *httpRequest) (*httpResponse, error) {
+		ciphertext, err := base64.StdEncoding.DecodeString(req.Ciphertext[len("kms:v1:"):])
+		if err != nil {
+			return nil, err
+		}
+		context, err := base64.StdEncoding.DecodeString(req.Context)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := key.Open(ciphertext, context)
+		if err != nil {
+			return nil, err
+		}
+		return &httpResponse{Plaintext: base64.StdEncoding.EncodeToString(plaintext)}, nil
+	}))
+	return httptest.NewServer(mux)
+}
+
+func TestHTTP(t *testing.T) {
+	kms := newTestKMS(t, "secret")
+	defer kms.Close()
+
+	provider, err := Parse(context.Background(), kms.URL+"/v1/tfstated/", "secret")
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing an http provider: %+v", err)
+	}
+	testWrapUnwrap(t, provider, "an http provider")
+
+	wrongToken := NewHTTP(kms.URL+"/v1/tfstated", "wrong")
+	if _, err := wrongToken.Wrap(context.Background(), []byte("a data key"), nil); err == nil {
+		t.Errorf("wrapping with a wrong token should have failed")
+	}
+	notFound := NewHTTP(kms.URL+"/v1/unknown", "secret")
+	if _, err := notFound.Wrap(context.Background(), []byte("a data key"), nil); err == nil {
+		t.Errorf("wrapping with an unknown key should have failed")
+	}
+}
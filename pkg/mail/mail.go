@@ -0,0 +1,121 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A mailer sends plain text emails through an SMTP relay. The connection is
+// upgraded with STARTTLS when the server offers it, except on port 465 which
+// uses implicit TLS. Credentials are only sent over TLS connections, or to a
+// server on the loopback interface.
+type Mailer struct {
+	envelope string // the address of the sender
+	from     string
+	host     string
+	password string
+	port     int
+	username string
+}
+
+func NewMailer(host string, port int, from string, username string, password string) (*Mailer, error) {
+	address, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sender address %s: %w", from, err)
+	}
+	return &Mailer{
+		envelope: address.Address,
+		from:     address.String(),
+		host:     host,
+		password: password,
+		port:     port,
+		username: username,
+	}, nil
+}
+
+// Returns the RFC 5322 message of an email
+func Message(from string, to string, subject string, body string, date time.Time) []byte {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	domain := "tfstated"
+	if address, err := netmail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(address.Address, "@"); ok {
+			domain = d
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id[:]), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	for line := range strings.SplitSeq(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+func (m *Mailer) Send(ctx context.Context, to string, subject string, body string) error {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: m.host}
+	if m.port == 465 {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to start smtp session with %s: %w", addr, err)
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && m.port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start tls with %s: %w", addr, err)
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("failed to authenticate with %s: %w", addr, err)
+		}
+	}
+	if err := client.Mail(m.envelope); err != nil {
+		return fmt.Errorf("failed to set the sender: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("failed to set the recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start the message: %w", err)
+	}
+	if _, err := w.Write(Message(m.from, to, subject, body, time.Now())); err != nil {
+		return fmt.Errorf("failed to write the message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send the message: %w", err)
+	}
+	return client.Quit()
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/mail/mailtest"
+)
+
+func TestMessage(t *testing.T) {
+	date := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := string(Message("tfstated <tfstated@example.com>", "user@example.com", "State /prod changed", "line 1\nline 2", date))
+	tests := []struct {
+		expected string
+		msg      string
+	}{
+		{"From: tfstated <tfstated@example.com>\r\n", "sender"},
+		{"To: user@example.com\r\n", "recipient"},
+		{"Subject: State /prod changed\r\n", "subject"},
+		{"Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n", "date"},
+		{"@example.com>\r\n", "message id domain"},
+		{"\r\n\r\nline 1\r\nline 2\r\n", "body"},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(msg, tt.expected) {
+			t.Errorf("message is missing the %s %q:\n%s", tt.msg, tt.expected, msg)
+		}
+	}
+	msg = string(Message("tfstated@example.com", "user@example.com", "État modifié", "", date))
+	if !strings.Contains(msg, "Subject: =?utf-8?q?") {
+		t.Errorf("non ascii subject should be encoded:\n%s", msg)
+	}
+}
+
+func TestSend(t *testing.T) {
+	server := mailtest.NewServer()
+	defer server.Close()
+	if _, err := NewMailer(server.Host, server.Port, "not an address", "", ""); err == nil {
+		t.Errorf("creating a mailer with an invalid sender should have failed")
+	}
+	mailer, err := NewMailer(server.Host, server.Port, "tfstated <tfstated@example.com>", "", "")
+	if err != nil {
+		t.Fatalf("failed to create mailer: %+v", err)
+	}
+	if err := mailer.Send(context.Background(), "user@example.com", "subject", "body"); err != nil {
+		t.Fatalf("failed to send: %+v", err)
+	}
+	server.SetReject(true)
+	if err := mailer.Send(context.Background(), "user@example.com", "subject", "rejected"); err == nil {
+		t.Errorf("sending to a server rejecting messages should have failed")
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, wanted 1", len(messages))
+	}
+	if messages[0].From != "tfstated@example.com" {
+		t.Errorf("got sender %s, wanted tfstated@example.com", messages[0].From)
+	}
+	if len(messages[0].To) != 1 || messages[0].To[0] != "user@example.com" {
+		t.Errorf("got recipients %v, wanted [user@example.com]", messages[0].To)
+	}
+	if !strings.HasSuffix(messages[0].Data, "\r\n\r\nbody\r\n") {
+		t.Errorf("got unexpected message data:\n%s", messages[0].Data)
+	}
+}
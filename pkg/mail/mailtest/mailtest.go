@@ -0,0 +1,139 @@
+// Package mailtest provides an SMTP server stand-in for tests
+package mailtest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	Data string
+	From string
+	To   []string
+}
+
+// A server accepting every message on the loopback interface without
+// authentication nor TLS
+type Server struct {
+	Host string
+	Port int
+
+	listener net.Listener
+	messages []Message
+	mutex    sync.Mutex
+	reject   bool
+	wg       sync.WaitGroup
+}
+
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("mailtest: failed to listen on a port: " + err.Error())
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	s := &Server{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		listener: listener,
+	}
+	s.wg.Go(s.serve)
+	return s
+}
+
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+// Returns a copy of the messages received so far
+func (s *Server) Messages() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Makes the server answer messages with a transient failure
+func (s *Server) SetReject(reject bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reject = reject
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Go(func() { s.handle(conn) })
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(code int, msg string) {
+		_, _ = conn.Write([]byte(strconv.Itoa(code) + " " + msg + "\r\n"))
+	}
+	reply(220, "mailtest ready")
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply(250, "mailtest")
+		case "MAIL":
+			msg = Message{From: trimAddress(arg)}
+			reply(250, "ok")
+		case "RCPT":
+			msg.To = append(msg.To, trimAddress(arg))
+			reply(250, "ok")
+		case "DATA":
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.Data = data.String()
+			s.mutex.Lock()
+			reject := s.reject
+			if !reject {
+				s.messages = append(s.messages, msg)
+			}
+			s.mutex.Unlock()
+			if reject {
+				reply(451, "try again later")
+			} else {
+				reply(250, "ok")
+			}
+		case "NOOP", "RSET":
+			reply(250, "ok")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+func trimAddress(arg string) string {
+	_, address, _ := strings.Cut(arg, ":")
+	address, _, _ = strings.Cut(strings.TrimSpace(address), " ")
+	return strings.Trim(address, "<>")
+}
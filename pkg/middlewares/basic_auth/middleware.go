@@ -10,6 +10,8 @@ import (
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
 )
 
+// The password can either be the account's password or one of its API tokens,
+// in which case the token is set in the request context as well
 func Middleware(db *database.DB) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -19,20 +21,19 @@ func Middleware(db *database.DB) func(http.Handler) http.Handler {
 				helpers.ErrorResponse(w, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
 				return
 			}
-			account, err := db.LoadAccountByUsername(username)
+			account, token, err := db.LoadAccountByCredentials(username, password)
 			if err != nil {
 				helpers.ErrorResponse(w, http.StatusInternalServerError, err)
 				return
 			}
-			if account == nil || !account.CheckPassword(password) {
+			if account == nil {
 				helpers.ErrorResponse(w, http.StatusForbidden, fmt.Errorf("Forbidden"))
 				return
 			}
-			if err := db.TouchAccount(account); err != nil {
-				helpers.ErrorResponse(w, http.StatusInternalServerError, err)
-				return
-			}
 			ctx := context.WithValue(r.Context(), model.AccountContextKey{}, account)
+			if token != nil {
+				ctx = context.WithValue(ctx, model.TokenContextKey{}, token)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
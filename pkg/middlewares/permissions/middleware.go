@@ -0,0 +1,43 @@
+package permissions
+
+import (
+	"fmt"
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+// Returns true if the request's account is allowed the permission on the
+// state path. When the request was authenticated with an API token, the
+// token's scope must imply the permission too
+func Allowed(db *database.DB, r *http.Request, path string, permission model.Permission) (bool, error) {
+	if token, ok := r.Context().Value(model.TokenContextKey{}).(*model.Token); ok {
+		if !token.Scope.Implies(permission) {
+			return false, nil
+		}
+	}
+	account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
+	return db.CheckPermission(account, path, permission)
+}
+
+// Must be chained after the basic_auth middleware which sets the account in
+// the request context
+func Middleware(db *database.DB, permission model.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, err := Allowed(db, r, r.URL.Path, permission)
+			if err != nil {
+				helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+				return
+			}
+			if !allowed {
+				helpers.ErrorResponse(w, http.StatusForbidden,
+					fmt.Errorf("missing %s permission on %s", permission, r.URL.Path))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
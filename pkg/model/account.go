@@ -22,6 +22,7 @@ type Account struct {
 	Settings      *Settings  `json:"settings"`
 	PasswordReset *uuid.UUID `json:"password_reset"`
 	Deleted       bool       `json:"deleted"`
+	Email         string     `json:"email"`
 }
 
 func (account *Account) CheckPassword(password string) bool {
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+const (
+	AuditAccountCreate        = "account.create"
+	AuditAccountDelete        = "account.delete"
+	AuditAccountEdit          = "account.edit"
+	AuditAccountGrant         = "account.grant"
+	AuditAccountResetPassword = "account.reset-password"
+	AuditAccountRevoke        = "account.revoke"
+	AuditGroupAddMember       = "group.add-member"
+	AuditGroupCreate          = "group.create"
+	AuditGroupDelete          = "group.delete"
+	AuditGroupGrant           = "group.grant"
+	AuditGroupRemoveMember    = "group.remove-member"
+	AuditGroupRevoke          = "group.revoke"
+	AuditStateDelete          = "state.delete"
+	AuditStateForceUnlock     = "state.force-unlock"
+	AuditStateLock            = "state.lock"
+	AuditStateLockTTL         = "state.lock-ttl"
+	AuditStatePin             = "state.pin"
+	AuditStatePush            = "state.push"
+	AuditStateRename          = "state.rename"
+	AuditStateRestore         = "state.restore"
+	AuditStateReveal          = "state.reveal"
+	AuditStateUnlock          = "state.unlock"
+	AuditStateUnpin           = "state.unpin"
+	AuditTokenCreate          = "token.create"
+	AuditTokenRevoke          = "token.revoke"
+	AuditWebhookCreate        = "webhook.create"
+	AuditWebhookDelete        = "webhook.delete"
+	AuditWebhookEdit          = "webhook.edit"
+	AuditWebhookRetry         = "webhook.retry"
+)
+
+var AuditActions = []string{
+	AuditAccountCreate,
+	AuditAccountDelete,
+	AuditAccountEdit,
+	AuditAccountGrant,
+	AuditAccountResetPassword,
+	AuditAccountRevoke,
+	AuditGroupAddMember,
+	AuditGroupCreate,
+	AuditGroupDelete,
+	AuditGroupGrant,
+	AuditGroupRemoveMember,
+	AuditGroupRevoke,
+	AuditStateDelete,
+	AuditStateForceUnlock,
+	AuditStateLock,
+	AuditStateLockTTL,
+	AuditStatePin,
+	AuditStatePush,
+	AuditStateRename,
+	AuditStateRestore,
+	AuditStateReveal,
+	AuditStateUnlock,
+	AuditStateUnpin,
+	AuditTokenCreate,
+	AuditTokenRevoke,
+	AuditWebhookCreate,
+	AuditWebhookDelete,
+	AuditWebhookEdit,
+	AuditWebhookRetry,
+}
+
+const (
+	AuditTargetAccount = "account"
+	AuditTargetGroup   = "group"
+	AuditTargetState   = "state"
+	AuditTargetWebhook = "webhook"
+)
+
+// A record of an action on a state, an account, a group or a webhook. The
+// actor and target names are copied so that events remain readable after a
+// rename
+type AuditEvent struct {
+	Action     string          `json:"action"`
+	Actor      string          `json:"actor"`
+	ActorId    *uuid.UUID      `json:"actor_id"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	Created    time.Time       `json:"created"`
+	Id         uuid.UUID       `json:"id"`
+	SourceIP   string          `json:"source_ip"`
+	Target     string          `json:"target"`
+	TargetId   *uuid.UUID      `json:"target_id"`
+	TargetType string          `json:"target_type"`
+	TokenName  *string         `json:"token_name,omitempty"`
+}
+
+// Selects audit events, zero values match everything
+type AuditFilter struct {
+	Action     string
+	Actor      string
+	Before     *uuid.UUID // only events older than this one
+	Limit      int
+	Since      *time.Time
+	Target     string // a substring of the target name
+	TargetType string
+	Until      *time.Time
+}
+
+// Returns an audit event for an action of an account. The before and after
+// values are marshalled to JSON unless nil
+func NewAuditEvent(actor *Account, action string, targetType string, targetId *uuid.UUID, target string, before any, after any) (*AuditEvent, error) {
+	event := &AuditEvent{
+		Action:     action,
+		Actor:      actor.Username,
+		ActorId:    &actor.Id,
+		Target:     target,
+		TargetId:   targetId,
+		TargetType: targetType,
+	}
+	var err error
+	if before != nil {
+		if event.Before, err = json.Marshal(before); err != nil {
+			return nil, fmt.Errorf("failed to marshal audit event before value: %w", err)
+		}
+	}
+	if after != nil {
+		if event.After, err = json.Marshal(after); err != nil {
+			return nil, fmt.Errorf("failed to marshal audit event after value: %w", err)
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"go.n16f.net/uuid"
+)
+
+// The result of the verification of the hash chain of a state's versions
+type ChainReport struct {
+	Breaks []ChainBreak
+	// The chain hash of the latest version, which can be recorded outside of
+	// tfstated to detect a rewrite of the whole chain
+	Head    []byte
+	Path    string
+	StateId uuid.UUID
+	// Versions stored before tfstated chained them
+	Unchained int
+	Versions  int
+}
+
+type ChainBreak struct {
+	Reason    string
+	VersionId uuid.UUID
+}
+
+func (r *ChainReport) Valid() bool {
+	return len(r.Breaks) == 0
+}
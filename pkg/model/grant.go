@@ -0,0 +1,89 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+type Permission string
+
+const (
+	PermissionOutputs Permission = "outputs"
+	PermissionRead    Permission = "read"
+	PermissionReveal  Permission = "reveal"
+	PermissionLock    Permission = "lock"
+	PermissionWrite   Permission = "write"
+	PermissionDelete  Permission = "delete"
+	PermissionAdmin   Permission = "admin"
+)
+
+var Permissions = []Permission{
+	PermissionOutputs,
+	PermissionRead,
+	PermissionReveal,
+	PermissionLock,
+	PermissionWrite,
+	PermissionDelete,
+	PermissionAdmin,
+}
+
+// A permission always implies itself, and sometimes other permissions
+var impliedPermissions = map[Permission][]Permission{
+	PermissionOutputs: {PermissionOutputs},
+	PermissionRead:    {PermissionRead, PermissionOutputs},
+	PermissionReveal:  {PermissionReveal, PermissionRead, PermissionOutputs},
+	PermissionLock:    {PermissionLock, PermissionRead, PermissionOutputs},
+	PermissionWrite:   {PermissionWrite, PermissionLock, PermissionRead, PermissionOutputs},
+	PermissionDelete:  {PermissionDelete, PermissionRead, PermissionOutputs},
+	PermissionAdmin:   Permissions,
+}
+
+func (permission Permission) Implies(other Permission) bool {
+	return slices.Contains(impliedPermissions[permission], other)
+}
+
+func (permission Permission) Valid() bool {
+	_, ok := impliedPermissions[permission]
+	return ok
+}
+
+// A grant gives a permission on all the states whose path starts with
+// PathPrefix to either an account or a group of accounts
+type Grant struct {
+	AccountId  *uuid.UUID
+	Created    time.Time
+	GroupId    *uuid.UUID
+	Id         uuid.UUID
+	PathPrefix string
+	Permission Permission
+}
+
+// Returns true if the grant's path prefix covers the path. A prefix only
+// matches on a path element boundary: /foo covers /foo and /foo/bar but not
+// /foobar
+func (grant *Grant) Matches(path string) bool {
+	return pathPrefixCovers(grant.PathPrefix, path)
+}
+
+func pathPrefixCovers(prefix string, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) ||
+		strings.HasSuffix(prefix, "/") ||
+		path[len(prefix)] == '/'
+}
+
+type Grants []Grant
+
+func (grants Grants) Allow(path string, permission Permission) bool {
+	for _, grant := range grants {
+		if grant.Permission.Implies(permission) && grant.Matches(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+type Group struct {
+	Created time.Time
+	Id      uuid.UUID
+	Name    string
+}
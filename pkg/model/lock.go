@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"time"
+
+	"go.n16f.net/uuid"
+)
 
 type Lock struct {
 	Created   time.Time `json:"Created"`
@@ -11,3 +15,18 @@ type Lock struct {
 	Version   string    `json:"Version"`
 	Who       string    `json:"Who"`
 }
+
+const (
+	LockReleaseExpired = "expired"
+	LockReleaseForced  = "forced"
+)
+
+// A record of a lock that was released by something else than its owner
+type LockRelease struct {
+	AccountId *uuid.UUID
+	Created   time.Time
+	Id        uuid.UUID
+	Lock      *Lock
+	Reason    string
+	StateId   uuid.UUID
+}
@@ -7,9 +7,13 @@ import (
 )
 
 type State struct {
-	Created time.Time
-	Id      uuid.UUID
-	Lock    *Lock
+	Created       time.Time
+	Id            uuid.UUID
+	Lock          *Lock
+	LockRefreshed *time.Time
+	// Overrides the default lock time to live when not nil, zero meaning
+	// that locks never expire
+	LockTTL *time.Duration
 	Path    string
 	Updated time.Time
 }
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+// A subscription emails an account about the states whose path starts with
+// PathPrefix
+type Subscription struct {
+	AccountId  uuid.UUID
+	Created    time.Time
+	Id         uuid.UUID
+	PathPrefix string
+}
+
+func (subscription *Subscription) Matches(path string) bool {
+	return pathPrefixCovers(subscription.PathPrefix, path)
+}
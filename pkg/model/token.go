@@ -0,0 +1,34 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+// Prefix of API tokens secrets, which allows the backend to tell them apart
+// from account passwords
+const TokenPrefix = "tfstated_"
+
+type TokenContextKey struct{}
+
+// An API token authenticates backend requests on behalf of an account. Its
+// scope caps the permissions the account would otherwise have
+type Token struct {
+	AccountId uuid.UUID
+	Created   time.Time
+	Expires   *time.Time
+	Id        uuid.UUID
+	LastUsed  *time.Time
+	Name      string
+	Scope     Permission
+}
+
+func IsTokenSecret(s string) bool {
+	return strings.HasPrefix(s, TokenPrefix)
+}
+
+func (token *Token) IsExpired() bool {
+	return token.Expires != nil && time.Now().After(*token.Expires)
+}
@@ -7,11 +7,16 @@ import (
 	"go.n16f.net/uuid"
 )
 
+// A pinned version is exempt from the versions history pruning
 type Version struct {
-	AccountId uuid.UUID
-	Created   time.Time
-	Data      json.RawMessage
-	Id        uuid.UUID
-	Lock      *Lock
-	StateId   uuid.UUID
+	AccountId    uuid.UUID
+	Created      time.Time
+	Data         json.RawMessage
+	Id           uuid.UUID
+	Lock         *Lock
+	MD5          []byte
+	PinnedAt     *time.Time
+	PinnedBy     *uuid.UUID
+	PinnedReason string
+	StateId      uuid.UUID
 }
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"slices"
+	"time"
+
+	"go.n16f.net/uuid"
+)
+
+const (
+	WebhookAccountCreated = "account.created"
+	WebhookAccountDeleted = "account.deleted"
+	WebhookLockAcquired   = "lock.acquired"
+	WebhookLockForced     = "lock.forced"
+	WebhookLockReleased   = "lock.released"
+	WebhookLockStale      = "lock.stale"
+	WebhookStateCreated   = "state.created"
+	WebhookStateDeleted   = "state.deleted"
+	WebhookStateRenamed   = "state.renamed"
+	WebhookVersionCreated = "version.created"
+)
+
+var WebhookEvents = []string{
+	WebhookAccountCreated,
+	WebhookAccountDeleted,
+	WebhookLockAcquired,
+	WebhookLockForced,
+	WebhookLockReleased,
+	WebhookLockStale,
+	WebhookStateCreated,
+	WebhookStateDeleted,
+	WebhookStateRenamed,
+	WebhookVersionCreated,
+}
+
+// A webhook receives the events it subscribed to for the states whose path
+// starts with PathPrefix, and all the account events
+type Webhook struct {
+	Created    time.Time
+	Enabled    bool
+	Events     []string
+	Id         uuid.UUID
+	PathPrefix string
+	// The HMAC-SHA256 key signing the deliveries, hex encoded
+	Secret string
+	URL    string
+}
+
+// Returns true if the webhook should receive an event about a state path,
+// which is empty for account events
+func (webhook *Webhook) Matches(event string, path string) bool {
+	if !webhook.Enabled || !slices.Contains(webhook.Events, event) {
+		return false
+	}
+	return path == "" || pathPrefixCovers(webhook.PathPrefix, path)
+}
+
+// The payload of a webhook delivery
+type WebhookEvent struct {
+	Account      *WebhookAccount `json:"account,omitempty"`
+	Created      time.Time       `json:"created"`
+	Event        string          `json:"event"`
+	Lock         *Lock           `json:"lock,omitempty"`
+	LockAcquired *time.Time      `json:"lock_acquired,omitempty"`
+	PreviousPath string          `json:"previous_path,omitempty"`
+	// Why a lock was released by something else than its owner
+	Reason    string        `json:"reason,omitempty"`
+	State     *WebhookState `json:"state,omitempty"`
+	VersionId *uuid.UUID    `json:"version_id,omitempty"`
+}
+
+type WebhookAccount struct {
+	Id       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+type WebhookState struct {
+	Id   uuid.UUID `json:"id"`
+	Path string    `json:"path"`
+}
+
+// A webhook delivery is retried with an exponential backoff until it succeeds
+// or reaches the maximum number of attempts
+type WebhookDelivery struct {
+	Attempts    int
+	Created     time.Time
+	Delivered   *time.Time
+	Event       string
+	Id          uuid.UUID
+	LastError   *string
+	LastStatus  *int
+	NextAttempt time.Time
+	WebhookId   uuid.UUID
+}
@@ -0,0 +1,68 @@
+package scrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// An envelope starts with a version byte which selects how the rest of it is
+// encrypted:
+//   - EnvelopeAES256GCM: a 12 bytes nonce followed by the AES-256-GCM
+//     ciphertext and its 16 bytes tag
+const (
+	EnvelopeAES256GCM byte = 1
+)
+
+func (key *AES256Key) newGCM() (cipher.AEAD, error) {
+	blockCipher, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(blockCipher)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create gcm: %w", err)
+	}
+	return aead, nil
+}
+
+// Encrypts and authenticates data in an envelope. The additional data is
+// authenticated but not stored, the same must be given to Open.
+func (key *AES256Key) Seal(data []byte, additionalData []byte) ([]byte, error) {
+	aead, err := key.newGCM()
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(data)+aead.Overhead())
+	envelope[0] = EnvelopeAES256GCM
+	nonce := envelope[1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %w", err)
+	}
+	return aead.Seal(envelope, nonce, data, additionalData), nil
+}
+
+// Decrypts an envelope returned by Seal, failing if it was tampered with or if
+// the additional data does not match
+func (key *AES256Key) Open(envelope []byte, additionalData []byte) ([]byte, error) {
+	if len(envelope) == 0 {
+		return nil, fmt.Errorf("truncated envelope")
+	}
+	if envelope[0] != EnvelopeAES256GCM {
+		return nil, fmt.Errorf("unsupported envelope version %d", envelope[0])
+	}
+	aead, err := key.newGCM()
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("truncated envelope")
+	}
+	nonce := envelope[1 : 1+aead.NonceSize()]
+	data, err := aead.Open(nil, nonce, envelope[1+aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot authenticate envelope: %w", err)
+	}
+	return data, nil
+}
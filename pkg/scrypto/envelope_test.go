@@ -0,0 +1,73 @@
+package scrypto
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	keyHex := "28278b7c0a25f01d3cab639633b9487f9ea1e9a2176dc9595a3f01323aa44284"
+	var key AES256Key
+	if err := key.FromHex(keyHex); err != nil {
+		t.Errorf("got unexpected error %+v", err)
+	}
+
+	data := []byte("Hello world!")
+	additionalData := []byte("state and version")
+	envelope, err := key.Seal(data, additionalData)
+	if err != nil {
+		t.Errorf("got unexpected error when sealing data %+v", err)
+	}
+	if envelope[0] != EnvelopeAES256GCM {
+		t.Errorf("got envelope version %d, wanted %d", envelope[0], EnvelopeAES256GCM)
+	}
+
+	openedData, err := key.Open(envelope, additionalData)
+	if err != nil {
+		t.Errorf("got unexpected error when opening envelope %+v", err)
+	}
+	if slices.Compare(data, openedData) != 0 {
+		t.Errorf("got %v, wanted %v", openedData, data)
+	}
+}
+
+func TestEnvelopeInvalid(t *testing.T) {
+	keyHex := "28278b7c0a25f01d3cab639633b9487f9ea1e9a2176dc9595a3f01323aa44284"
+	var key AES256Key
+	if err := key.FromHex(keyHex); err != nil {
+		t.Errorf("got unexpected error %+v", err)
+	}
+	var otherKey AES256Key
+	if err := otherKey.FromHex(keyHex[2:] + "00"); err != nil {
+		t.Errorf("got unexpected error %+v", err)
+	}
+
+	additionalData := []byte("state and version")
+	envelope, err := key.Seal([]byte("Hello world!"), additionalData)
+	if err != nil {
+		t.Errorf("got unexpected error when sealing data %+v", err)
+	}
+	tampered := slices.Clone(envelope)
+	tampered[len(tampered)-1] ^= 1
+	unknownVersion := slices.Clone(envelope)
+	unknownVersion[0] = 0
+
+	tests := []struct {
+		key            *AES256Key
+		envelope       []byte
+		additionalData []byte
+		msg            string
+	}{
+		{&key, nil, additionalData, "an empty envelope"},
+		{&key, envelope[:20], additionalData, "a truncated envelope"},
+		{&key, tampered, additionalData, "a tampered envelope"},
+		{&key, unknownVersion, additionalData, "an unknown envelope version"},
+		{&key, envelope, []byte("another state"), "different additional data"},
+		{&otherKey, envelope, additionalData, "another key"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.key.Open(tt.envelope, tt.additionalData); err == nil {
+			t.Errorf("opening %s should have failed", tt.msg)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package scrypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(256). A share is its x coordinate followed by
+// the evaluation of one random polynomial per byte of the secret, of which the
+// constant term is that byte.
+
+// The exponentials and logarithms of GF(256) in base 3, with the AES
+// irreducible polynomial
+var gfExp, gfLog = func() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := byte(1)
+	for i := range 255 {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// multiply by 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return exp, log
+}()
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a byte, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// Splits a secret in n shares, any threshold of which can rebuild it
+func SplitSecret(secret []byte, n int, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid secret sharing of %d shares with a threshold of %d", n, threshold)
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+	coefficients := make([]byte, threshold)
+	for _, b := range secret {
+		coefficients[0] = b
+		copy(coefficients[1:], RandomBytes(threshold-1))
+		for i := range shares {
+			x := shares[i][0]
+			// Horner's method
+			y := byte(0)
+			for j := threshold - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coefficients[j]
+			}
+			shares[i] = append(shares[i], y)
+		}
+	}
+	return shares, nil
+}
+
+// Rebuilds a secret from at least the threshold of its shares. Fewer shares
+// yield a wrong secret without error.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	length := len(shares[0])
+	if length < 2 {
+		return nil, errors.New("truncated share")
+	}
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != length {
+			return nil, errors.New("shares have different lengths")
+		}
+		if share[0] == 0 {
+			return nil, errors.New("invalid share coordinate")
+		}
+		if seen[share[0]] {
+			return nil, errors.New("duplicate share")
+		}
+		seen[share[0]] = true
+	}
+	secret := make([]byte, length-1)
+	for i := range secret {
+		// Lagrange interpolation at x = 0, where subtraction is xor
+		var y byte
+		for j, share := range shares {
+			basis := byte(1)
+			for k, other := range shares {
+				if j != k {
+					basis = gfMul(basis, gfDiv(other[0], other[0]^share[0]))
+				}
+			}
+			y ^= gfMul(share[i+1], basis)
+		}
+		secret[i] = y
+	}
+	return secret, nil
+}
+
+// The bytes of a key checksum appended to it before splitting, so that
+// combining shares of different keys or too few shares is detected
+const keyChecksumSize = 8
+
+func keyChecksum(key *AES256Key) []byte {
+	sum := sha256.Sum256(key[:])
+	return sum[:keyChecksumSize]
+}
+
+// Returns a value which identifies a key without revealing it, so that a key
+// rebuilt from shares can be checked against the one which was split
+func KeyCheckValue(key *AES256Key) []byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("tfstated key check v1"))
+	return mac.Sum(nil)
+}
+
+// Splits a key in n base64 encoded shares, any threshold of which can rebuild
+// it with CombineKey
+func SplitKey(key *AES256Key, n int, threshold int) ([]string, error) {
+	secret := append(key[:], keyChecksum(key)...)
+	shares, err := SplitSecret(secret, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]string, n)
+	for i, share := range shares {
+		encoded[i] = base64.StdEncoding.EncodeToString(share)
+	}
+	return encoded, nil
+}
+
+// Decodes a share returned by SplitKey
+func DecodeKeyShare(share string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(share)
+	if err != nil {
+		return nil, fmt.Errorf("invalid share encoding: %w", err)
+	}
+	if len(data) != 1+len(AES256Key{})+keyChecksumSize || data[0] == 0 {
+		return nil, errors.New("invalid share")
+	}
+	return data, nil
+}
+
+// Rebuilds a key from shares decoded with DecodeKeyShare
+func CombineKey(shares [][]byte) (*AES256Key, error) {
+	secret, err := CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	var key AES256Key
+	if len(secret) != len(key)+keyChecksumSize {
+		return nil, errors.New("invalid share")
+	}
+	copy(key[:], secret)
+	if !bytes.Equal(secret[len(key):], keyChecksum(&key)) {
+		return nil, errors.New("the shares do not combine into a valid key, they might belong to different keys")
+	}
+	return &key, nil
+}
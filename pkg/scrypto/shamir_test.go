@@ -0,0 +1,103 @@
+package scrypto
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestShamir(t *testing.T) {
+	secret := []byte("Hello world!")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("got unexpected error when splitting secret %+v", err)
+	}
+	subsets := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, subset := range subsets {
+		selected := make([][]byte, 0, len(subset))
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+		combined, err := CombineShares(selected)
+		if err != nil {
+			t.Fatalf("got unexpected error when combining shares %v: %+v", subset, err)
+		}
+		if slices.Compare(secret, combined) != 0 {
+			t.Errorf("got %v when combining shares %v, wanted %v", combined, subset, secret)
+		}
+	}
+
+	invalid := []struct {
+		n         int
+		threshold int
+		msg       string
+	}{
+		{3, 1, "a threshold of 1"},
+		{2, 3, "a threshold greater than the number of shares"},
+		{256, 3, "too many shares"},
+	}
+	for _, tt := range invalid {
+		if _, err := SplitSecret(secret, tt.n, tt.threshold); err == nil {
+			t.Errorf("splitting a secret with %s should have failed", tt.msg)
+		}
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Errorf("combining duplicate shares should have failed")
+	}
+}
+
+func TestShamirKey(t *testing.T) {
+	var key AES256Key
+	if err := key.FromHex("28278b7c0a25f01d3cab639633b9487f9ea1e9a2176dc9595a3f01323aa44284"); err != nil {
+		t.Fatalf("got unexpected error %+v", err)
+	}
+	decode := func(shares []string) [][]byte {
+		decoded := make([][]byte, 0, len(shares))
+		for _, share := range shares {
+			data, err := DecodeKeyShare(share)
+			if err != nil {
+				t.Fatalf("got unexpected error when decoding share %+v", err)
+			}
+			decoded = append(decoded, data)
+		}
+		return decoded
+	}
+	shares, err := SplitKey(&key, 3, 2)
+	if err != nil {
+		t.Fatalf("got unexpected error when splitting key %+v", err)
+	}
+	combined, err := CombineKey(decode(shares[1:]))
+	if err != nil {
+		t.Fatalf("got unexpected error when combining key %+v", err)
+	}
+	if *combined != key {
+		t.Errorf("got %v, wanted %v", combined.Hex(), key.Hex())
+	}
+
+	otherShares, err := SplitKey(&key, 3, 2)
+	if err != nil {
+		t.Fatalf("got unexpected error when splitting key %+v", err)
+	}
+	if _, err := CombineKey(decode([]string{shares[0], otherShares[1]})); err == nil {
+		t.Errorf("combining shares of different splits should have failed")
+	}
+	for _, share := range []string{"not base64!", "", "AQID"} {
+		if _, err := DecodeKeyShare(share); err == nil {
+			t.Errorf("decoding the invalid share %q should have failed", share)
+		}
+	}
+}
+
+func TestKeyCheckValue(t *testing.T) {
+	var key, other AES256Key
+	if err := key.FromHex("28278b7c0a25f01d3cab639633b9487f9ea1e9a2176dc9595a3f01323aa44284"); err != nil {
+		t.Fatalf("got unexpected error %+v", err)
+	}
+	copy(other[:], key[:])
+	other[0] ^= 1
+	if !slices.Equal(KeyCheckValue(&key), KeyCheckValue(&key)) {
+		t.Errorf("the check value of a key should not change")
+	}
+	if slices.Equal(KeyCheckValue(&key), KeyCheckValue(&other)) {
+		t.Errorf("the check values of different keys should differ")
+	}
+}
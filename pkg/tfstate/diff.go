@@ -0,0 +1,234 @@
+package tfstate
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	ActionAdded   = "added"
+	ActionChanged = "changed"
+	ActionRemoved = "removed"
+)
+
+// A change of an attribute or of an output. Sensitive values are replaced
+// with Masked
+type Change struct {
+	Action string
+	After  string
+	Before string
+	Path   string
+}
+
+type ResourceChange struct {
+	Action     string
+	Address    string
+	Attributes []Change
+}
+
+// The differences between two states
+type Diff struct {
+	Outputs   []Change
+	Resources []ResourceChange
+}
+
+func (diff *Diff) Empty() bool {
+	return len(diff.Outputs) == 0 && len(diff.Resources) == 0
+}
+
+type instanceLeaves struct {
+	leaves    map[string]Leaf
+	sensitive []Path
+}
+
+func indexInstances(document *Document) (map[string]instanceLeaves, error) {
+	instances := make(map[string]instanceLeaves)
+	if document == nil {
+		return instances, nil
+	}
+	for i := range document.Resources {
+		resource := &document.Resources[i]
+		for j := range resource.Instances {
+			instance := &resource.Instances[j]
+			attributes, err := Decode(instance.Attributes)
+			if err != nil {
+				return nil, err
+			}
+			sensitive, err := instance.SensitivePaths()
+			if err != nil {
+				return nil, err
+			}
+			leaves := make(map[string]Leaf)
+			for _, leaf := range Flatten(attributes) {
+				leaves[leaf.Path.String()] = leaf
+			}
+			instances[resource.InstanceAddress(instance)] = instanceLeaves{
+				leaves:    leaves,
+				sensitive: sensitive,
+			}
+		}
+	}
+	return instances, nil
+}
+
+func display(leaf Leaf, sensitive []Path) string {
+	if leaf.Path.Under(sensitive) {
+		return Masked
+	}
+	return leaf.Value
+}
+
+// Compares the attributes of an instance. Sensitive values are compared
+// before being masked so that their changes are still reported
+func compareLeaves(before instanceLeaves, after instanceLeaves) []Change {
+	changes := make([]Change, 0)
+	for path, b := range before.leaves {
+		a, ok := after.leaves[path]
+		if !ok {
+			changes = append(changes, Change{
+				Action: ActionRemoved,
+				Before: display(b, before.sensitive),
+				Path:   path,
+			})
+		} else if a.Value != b.Value {
+			changes = append(changes, Change{
+				Action: ActionChanged,
+				After:  display(a, after.sensitive),
+				Before: display(b, before.sensitive),
+				Path:   path,
+			})
+		}
+	}
+	for path, a := range after.leaves {
+		if _, ok := before.leaves[path]; !ok {
+			changes = append(changes, Change{
+				Action: ActionAdded,
+				After:  display(a, after.sensitive),
+				Path:   path,
+			})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return changes
+}
+
+func outputValue(output Output) (string, error) {
+	if output.Sensitive {
+		return Masked, nil
+	}
+	value, err := Decode(output.Value)
+	if err != nil {
+		return "", err
+	}
+	return Format(value), nil
+}
+
+// Returns the resources and outputs added, removed or changed between two
+// states. A nil state compares as an empty one
+func Compare(before *Document, after *Document) (*Diff, error) {
+	diff := Diff{
+		Outputs:   make([]Change, 0),
+		Resources: make([]ResourceChange, 0),
+	}
+	beforeInstances, err := indexInstances(before)
+	if err != nil {
+		return nil, err
+	}
+	afterInstances, err := indexInstances(after)
+	if err != nil {
+		return nil, err
+	}
+	empty := instanceLeaves{leaves: map[string]Leaf{}}
+	for address, b := range beforeInstances {
+		a, ok := afterInstances[address]
+		if !ok {
+			diff.Resources = append(diff.Resources, ResourceChange{
+				Action:     ActionRemoved,
+				Address:    address,
+				Attributes: compareLeaves(b, empty),
+			})
+		} else if changes := compareLeaves(b, a); len(changes) > 0 {
+			diff.Resources = append(diff.Resources, ResourceChange{
+				Action:     ActionChanged,
+				Address:    address,
+				Attributes: changes,
+			})
+		}
+	}
+	for address, a := range afterInstances {
+		if _, ok := beforeInstances[address]; !ok {
+			diff.Resources = append(diff.Resources, ResourceChange{
+				Action:     ActionAdded,
+				Address:    address,
+				Attributes: compareLeaves(empty, a),
+			})
+		}
+	}
+	slices.SortFunc(diff.Resources, func(a, b ResourceChange) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	var beforeOutputs, afterOutputs map[string]Output
+	if before != nil {
+		beforeOutputs = before.Outputs
+	}
+	if after != nil {
+		afterOutputs = after.Outputs
+	}
+	for name, b := range beforeOutputs {
+		beforeValue, err := outputValue(b)
+		if err != nil {
+			return nil, err
+		}
+		a, ok := afterOutputs[name]
+		if !ok {
+			diff.Outputs = append(diff.Outputs, Change{
+				Action: ActionRemoved,
+				Before: beforeValue,
+				Path:   name,
+			})
+			continue
+		}
+		afterValue, err := outputValue(a)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(a.Value, b.Value) || a.Sensitive != b.Sensitive {
+			diff.Outputs = append(diff.Outputs, Change{
+				Action: ActionChanged,
+				After:  afterValue,
+				Before: beforeValue,
+				Path:   name,
+			})
+		}
+	}
+	for name, a := range afterOutputs {
+		if _, ok := beforeOutputs[name]; ok {
+			continue
+		}
+		afterValue, err := outputValue(a)
+		if err != nil {
+			return nil, err
+		}
+		diff.Outputs = append(diff.Outputs, Change{
+			Action: ActionAdded,
+			After:  afterValue,
+			Path:   name,
+		})
+	}
+	slices.SortFunc(diff.Outputs, func(a, b Change) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return &diff, nil
+}
+
+// Compares two JSON values regardless of their formatting
+func jsonEqual(a []byte, b []byte) bool {
+	va, errA := Decode(a)
+	vb, errB := Decode(b)
+	if errA != nil || errB != nil {
+		return string(a) == string(b)
+	}
+	return Format(va) == Format(vb)
+}
@@ -0,0 +1,81 @@
+package tfstate
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	before, err := ParseDocument([]byte(`{"version":4,"serial":1,"lineage":"l",
+	  "outputs":{"ip":{"value":"10.0.0.1","type":"string"},"token":{"value":"a","type":"string","sensitive":true},"old":{"value":1,"type":"number"}},
+	  "resources":[
+	    {"mode":"managed","type":"random_password","name":"db","instances":[{"attributes":{"length":16,"result":"aaa"},"sensitive_attributes":[[{"type":"get_attr","value":"result"}]]}]},
+	    {"mode":"managed","type":"null_resource","name":"gone","instances":[{"attributes":{"id":"1"}}]},
+	    {"mode":"managed","type":"null_resource","name":"same","instances":[{"attributes":{"id":"2"}}]}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing the before document: %+v", err)
+	}
+	after, err := ParseDocument([]byte(`{"version":4,"serial":2,"lineage":"l",
+	  "outputs":{"ip":{"value":"10.0.0.2","type":"string"},"token":{"value":"b","type":"string","sensitive":true},"new":{"value":true,"type":"bool"}},
+	  "resources":[
+	    {"mode":"managed","type":"random_password","name":"db","instances":[{"attributes":{"length":20,"result":"bbb"},"sensitive_attributes":[[{"type":"get_attr","value":"result"}]]}]},
+	    {"mode":"managed","type":"null_resource","name":"same","instances":[{"attributes":{"id":"2"}}]},
+	    {"mode":"managed","type":"null_resource","name":"new","instances":[{"index_key":0,"attributes":{"id":"3"}}]}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing the after document: %+v", err)
+	}
+	diff, err := Compare(before, after)
+	if err != nil {
+		t.Fatalf("got unexpected error when comparing documents: %+v", err)
+	}
+	resources := []struct {
+		action  string
+		address string
+		changes []Change
+	}{
+		{ActionRemoved, "null_resource.gone", []Change{{Action: ActionRemoved, Before: `"1"`, Path: "id"}}},
+		{ActionAdded, "null_resource.new[0]", []Change{{Action: ActionAdded, After: `"3"`, Path: "id"}}},
+		{ActionChanged, "random_password.db", []Change{
+			{Action: ActionChanged, After: "20", Before: "16", Path: "length"},
+			{Action: ActionChanged, After: Masked, Before: Masked, Path: "result"},
+		}},
+	}
+	if len(diff.Resources) != len(resources) {
+		t.Fatalf("got %d resource changes, wanted %d: %+v", len(diff.Resources), len(resources), diff.Resources)
+	}
+	for i, tt := range resources {
+		got := diff.Resources[i]
+		if got.Action != tt.action || got.Address != tt.address {
+			t.Errorf("got %s %s, wanted %s %s", got.Action, got.Address, tt.action, tt.address)
+			continue
+		}
+		if len(got.Attributes) != len(tt.changes) {
+			t.Errorf("got %+v attribute changes for %s, wanted %+v", got.Attributes, tt.address, tt.changes)
+			continue
+		}
+		for j, change := range tt.changes {
+			if got.Attributes[j] != change {
+				t.Errorf("got %+v for %s, wanted %+v", got.Attributes[j], tt.address, change)
+			}
+		}
+	}
+	outputs := []Change{
+		{Action: ActionChanged, After: `"10.0.0.2"`, Before: `"10.0.0.1"`, Path: "ip"},
+		{Action: ActionAdded, After: "true", Path: "new"},
+		{Action: ActionRemoved, Before: "1", Path: "old"},
+		{Action: ActionChanged, After: Masked, Before: Masked, Path: "token"},
+	}
+	if len(diff.Outputs) != len(outputs) {
+		t.Fatalf("got %d output changes, wanted %d: %+v", len(diff.Outputs), len(outputs), diff.Outputs)
+	}
+	for i, change := range outputs {
+		if diff.Outputs[i] != change {
+			t.Errorf("got %+v, wanted %+v", diff.Outputs[i], change)
+		}
+	}
+	if diff, err := Compare(after, after); err != nil || !diff.Empty() {
+		t.Errorf("comparing a document with itself should be empty, got %+v and %+v", diff, err)
+	}
+	if diff, err := Compare(nil, after); err != nil || len(diff.Resources) != 3 {
+		t.Errorf("comparing with a nil document should add every resource, got %+v and %+v", diff, err)
+	}
+}
@@ -0,0 +1,399 @@
+package tfstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The value shown in place of sensitive values
+const Masked = "(sensitive value)"
+
+// A whole OpenTofu or Terraform state, as opposed to State which only holds
+// its metadata. Encrypted states have no outputs nor resources
+type Document struct {
+	CheckResults     json.RawMessage   `json:"check_results"`
+	EncryptedData    json.RawMessage   `json:"encrypted_data"`
+	Lineage          string            `json:"lineage"`
+	Outputs          map[string]Output `json:"outputs"`
+	Resources        []Resource        `json:"resources"`
+	Serial           uint64            `json:"serial"`
+	TerraformVersion string            `json:"terraform_version"`
+	Version          int               `json:"version"`
+}
+
+type Output struct {
+	Sensitive bool            `json:"sensitive,omitempty"`
+	Type      json.RawMessage `json:"type,omitempty"`
+	Value     json.RawMessage `json:"value"`
+}
+
+type Resource struct {
+	Each      string     `json:"each"`
+	Instances []Instance `json:"instances"`
+	Mode      string     `json:"mode"`
+	Module    string     `json:"module"`
+	Name      string     `json:"name"`
+	Provider  string     `json:"provider"`
+	Type      string     `json:"type"`
+}
+
+type Instance struct {
+	Attributes          json.RawMessage `json:"attributes"`
+	CreateBeforeDestroy bool            `json:"create_before_destroy"`
+	Dependencies        []string        `json:"dependencies"`
+	Deposed             string          `json:"deposed"`
+	IndexKey            any             `json:"index_key"`
+	SchemaVersion       int             `json:"schema_version"`
+	SensitiveAttributes json.RawMessage `json:"sensitive_attributes"`
+	Status              string          `json:"status"`
+}
+
+func ParseDocument(data []byte) (*Document, error) {
+	var document Document
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid state JSON: %w", err)
+	}
+	return &document, nil
+}
+
+func (document *Document) Encrypted() bool {
+	return len(document.EncryptedData) > 0
+}
+
+// Returns the address of a resource, like module.network.aws_vpc.main
+func (resource *Resource) Address() string {
+	var b strings.Builder
+	if resource.Module != "" {
+		b.WriteString(resource.Module)
+		b.WriteString(".")
+	}
+	if resource.Mode == "data" {
+		b.WriteString("data.")
+	}
+	b.WriteString(resource.Type)
+	b.WriteString(".")
+	b.WriteString(resource.Name)
+	return b.String()
+}
+
+// Returns the address of an instance of a resource, like aws_instance.web[0]
+func (resource *Resource) InstanceAddress(instance *Instance) string {
+	address := resource.Address()
+	switch key := instance.IndexKey.(type) {
+	case nil:
+	case json.Number:
+		address += "[" + key.String() + "]"
+	case string:
+		address += "[" + strconv.Quote(key) + "]"
+	default:
+		address += fmt.Sprintf("[%v]", key)
+	}
+	if instance.Deposed != "" {
+		address += " (deposed " + instance.Deposed + ")"
+	}
+	return address
+}
+
+// A path to a value nested in attributes, made of string keys and integer
+// indexes
+type Path []any
+
+var identifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// Returns the path in the OpenTofu/Terraform syntax, like ingress[0].cidr
+func (path Path) String() string {
+	var b strings.Builder
+	for i, step := range path {
+		switch s := step.(type) {
+		case int:
+			b.WriteString("[" + strconv.Itoa(s) + "]")
+		case string:
+			if !identifier.MatchString(s) {
+				b.WriteString("[" + strconv.Quote(s) + "]")
+				continue
+			}
+			if i > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+func (path Path) hasPrefix(prefix Path) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, step := range prefix {
+		if path[i] != step {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the path is or is nested under one of the paths
+func (path Path) Under(paths []Path) bool {
+	for _, p := range paths {
+		if path.hasPrefix(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the paths of the sensitive attributes of an instance
+func (instance *Instance) SensitivePaths() ([]Path, error) {
+	if len(instance.SensitiveAttributes) == 0 {
+		return nil, nil
+	}
+	type step struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	var raw [][]step
+	if err := json.Unmarshal(instance.SensitiveAttributes, &raw); err != nil {
+		return nil, fmt.Errorf("invalid sensitive attributes: %w", err)
+	}
+	paths := make([]Path, 0, len(raw))
+	for _, steps := range raw {
+		path := make(Path, 0, len(steps))
+		for _, s := range steps {
+			switch s.Type {
+			case "get_attr":
+				var name string
+				if err := json.Unmarshal(s.Value, &name); err != nil {
+					return nil, fmt.Errorf("invalid sensitive attribute name: %w", err)
+				}
+				path = append(path, name)
+			case "index":
+				var key struct {
+					Type  string          `json:"type"`
+					Value json.RawMessage `json:"value"`
+				}
+				if err := json.Unmarshal(s.Value, &key); err != nil {
+					return nil, fmt.Errorf("invalid sensitive attribute index: %w", err)
+				}
+				if key.Type == "number" {
+					var index int
+					if err := json.Unmarshal(key.Value, &index); err != nil {
+						return nil, fmt.Errorf("invalid sensitive attribute index: %w", err)
+					}
+					path = append(path, index)
+				} else {
+					var name string
+					if err := json.Unmarshal(key.Value, &name); err != nil {
+						return nil, fmt.Errorf("invalid sensitive attribute key: %w", err)
+					}
+					path = append(path, name)
+				}
+			default:
+				return nil, fmt.Errorf("invalid sensitive attribute step type %s", s.Type)
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Decodes a JSON value keeping the exact representation of numbers
+func Decode(data json.RawMessage) (any, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON value: %w", err)
+	}
+	return value, nil
+}
+
+// Returns a copy of a decoded value where the values under the paths are
+// replaced with Masked
+func Mask(value any, paths []Path) any {
+	return mask(value, Path{}, paths)
+}
+
+func mask(value any, path Path, paths []Path) any {
+	if len(paths) == 0 {
+		return value
+	}
+	if path.Under(paths) {
+		return Masked
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		masked := make(map[string]any, len(v))
+		for key, child := range v {
+			masked[key] = mask(child, append(path[:len(path):len(path)], key), paths)
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(v))
+		for i, child := range v {
+			masked[i] = mask(child, append(path[:len(path):len(path)], i), paths)
+		}
+		return masked
+	default:
+		return value
+	}
+}
+
+// Returns the attributes of an instance with its sensitive values masked
+func (instance *Instance) MaskedAttributes() (any, error) {
+	attributes, err := Decode(instance.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := instance.SensitivePaths()
+	if err != nil {
+		return nil, err
+	}
+	return Mask(attributes, paths), nil
+}
+
+// A leaf of a flattened value: a scalar, an empty object or an empty array
+type Leaf struct {
+	Path  Path
+	Value string // compact JSON
+}
+
+// Returns the leaves of a decoded value, sorted by path
+func Flatten(value any) []Leaf {
+	leaves := make([]Leaf, 0)
+	flatten(value, Path{}, &leaves)
+	return leaves
+}
+
+func flatten(value any, path Path, leaves *[]Leaf) {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) > 0 {
+			for _, key := range sortedKeys(v) {
+				flatten(v[key], append(path[:len(path):len(path)], key), leaves)
+			}
+			return
+		}
+	case []any:
+		if len(v) > 0 {
+			for i, child := range v {
+				flatten(child, append(path[:len(path):len(path)], i), leaves)
+			}
+			return
+		}
+	}
+	*leaves = append(*leaves, Leaf{Path: path, Value: Format(value)})
+}
+
+// Returns the compact JSON representation of a decoded value, or Masked
+func Format(value any) string {
+	if s, ok := value.(string); ok && s == Masked {
+		return Masked
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Returns the JSON of a state with its sensitive attributes and outputs
+// masked. A masked state is meant to be read, it cannot be pushed back
+func MaskDocument(data []byte) ([]byte, error) {
+	value, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	document, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid state JSON: not an object")
+	}
+	resources, _ := document["resources"].([]any)
+	for _, resource := range resources {
+		r, _ := resource.(map[string]any)
+		instances, _ := r["instances"].([]any)
+		for _, instance := range instances {
+			i, ok := instance.(map[string]any)
+			if !ok {
+				continue
+			}
+			sensitive, err := json.Marshal(i["sensitive_attributes"])
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal sensitive attributes: %w", err)
+			}
+			paths, err := (&Instance{SensitiveAttributes: sensitive}).SensitivePaths()
+			if err != nil {
+				return nil, err
+			}
+			if attributes, ok := i["attributes"]; ok {
+				i["attributes"] = Mask(attributes, paths)
+			}
+		}
+	}
+	outputs, _ := document["outputs"].(map[string]any)
+	for _, output := range outputs {
+		if o, ok := output.(map[string]any); ok && o["sensitive"] == true {
+			o["value"] = Masked
+		}
+	}
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return nil, fmt.Errorf("failed to marshal masked state: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// Returns a minimal state holding only the outputs of a state, which is
+// enough for terraform_remote_state data sources
+func (document *Document) OutputsOnly(excludeSensitive bool) ([]byte, error) {
+	if document.Encrypted() {
+		return nil, fmt.Errorf("the outputs of an encrypted state cannot be read")
+	}
+	outputs := make(map[string]Output, len(document.Outputs))
+	for name, output := range document.Outputs {
+		if !excludeSensitive || !output.Sensitive {
+			outputs[name] = output
+		}
+	}
+	data, err := json.MarshalIndent(struct {
+		Version          int               `json:"version"`
+		TerraformVersion string            `json:"terraform_version"`
+		Serial           uint64            `json:"serial"`
+		Lineage          string            `json:"lineage"`
+		Outputs          map[string]Output `json:"outputs"`
+		Resources        []Resource        `json:"resources"`
+	}{
+		Version:          document.Version,
+		TerraformVersion: document.TerraformVersion,
+		Serial:           document.Serial,
+		Lineage:          document.Lineage,
+		Outputs:          outputs,
+		Resources:        []Resource{},
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outputs: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,131 @@
+package tfstate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInstanceAddress(t *testing.T) {
+	document, err := ParseDocument([]byte(`{"version":4,"serial":1,"lineage":"l","resources":[
+	  {"mode":"managed","type":"aws_instance","name":"web","instances":[{"attributes":{}},{"index_key":0,"attributes":{}}]},
+	  {"module":"module.net","mode":"data","type":"aws_vpc","name":"main","instances":[{"index_key":"eu","attributes":{}}]},
+	  {"mode":"managed","type":"null_resource","name":"x","instances":[{"deposed":"abcd1234","attributes":{}}]}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing a document: %+v", err)
+	}
+	tests := []struct {
+		resource int
+		instance int
+		expected string
+	}{
+		{0, 0, "aws_instance.web"},
+		{0, 1, "aws_instance.web[0]"},
+		{1, 0, `module.net.data.aws_vpc.main["eu"]`},
+		{2, 0, "null_resource.x (deposed abcd1234)"},
+	}
+	for _, tt := range tests {
+		resource := &document.Resources[tt.resource]
+		if got := resource.InstanceAddress(&resource.Instances[tt.instance]); got != tt.expected {
+			t.Errorf("got address %s, wanted %s", got, tt.expected)
+		}
+	}
+}
+
+func TestMaskedAttributes(t *testing.T) {
+	instance := Instance{
+		Attributes: []byte(`{"name":"db","password":"hunter2","ports":[5432,5433],"tags":{"Owner":"me","secret key":"s"}}`),
+		SensitiveAttributes: []byte(`[[{"type":"get_attr","value":"password"}],
+		  [{"type":"get_attr","value":"ports"},{"type":"index","value":{"value":1,"type":"number"}}],
+		  [{"type":"get_attr","value":"tags"},{"type":"index","value":{"value":"secret key","type":"string"}}]]`),
+	}
+	attributes, err := instance.MaskedAttributes()
+	if err != nil {
+		t.Fatalf("got unexpected error when masking attributes: %+v", err)
+	}
+	expected := map[string]string{
+		"name":               `"db"`,
+		"password":           Masked,
+		"ports[0]":           "5432",
+		"ports[1]":           Masked,
+		"tags.Owner":         `"me"`,
+		`tags["secret key"]`: Masked,
+	}
+	leaves := Flatten(attributes)
+	if len(leaves) != len(expected) {
+		t.Fatalf("got %d leaves, wanted %d: %+v", len(leaves), len(expected), leaves)
+	}
+	for _, leaf := range leaves {
+		if got := expected[leaf.Path.String()]; got != leaf.Value {
+			t.Errorf("got %s for %s, wanted %s", leaf.Value, leaf.Path, got)
+		}
+	}
+	instance.SensitiveAttributes = []byte(`[[{"type":"unknown","value":"x"}]]`)
+	if _, err := instance.MaskedAttributes(); err == nil {
+		t.Errorf("masking with an invalid sensitive attribute step should have failed")
+	}
+}
+
+func TestMaskDocument(t *testing.T) {
+	data := []byte(`{"version":4,"serial":1,"lineage":"l",
+	  "outputs":{"ip":{"value":"10.0.0.1","type":"string"},"token":{"value":"the-token","type":"string","sensitive":true}},
+	  "resources":[{"mode":"managed","type":"random_password","name":"db","instances":[
+	    {"attributes":{"length":16,"result":"the-password"},"sensitive_attributes":[[{"type":"get_attr","value":"result"}]]},
+	    {"attributes":{"id":"<&>"}}]}]}`)
+	masked, err := MaskDocument(data)
+	if err != nil {
+		t.Fatalf("got unexpected error when masking a document: %+v", err)
+	}
+	for _, secret := range []string{"the-token", "the-password"} {
+		if strings.Contains(string(masked), secret) {
+			t.Errorf("the masked document should not contain %s: %s", secret, masked)
+		}
+	}
+	for _, kept := range []string{`"10.0.0.1"`, `"length": 16`, `"<&>"`, `"lineage": "l"`} {
+		if !strings.Contains(string(masked), kept) {
+			t.Errorf("the masked document should contain %s: %s", kept, masked)
+		}
+	}
+	if _, err := MaskDocument([]byte(`[]`)); err == nil {
+		t.Errorf("masking a JSON array should have failed")
+	}
+}
+
+func TestOutputsOnly(t *testing.T) {
+	document, err := ParseDocument([]byte(`{"version":4,"terraform_version":"1.10.0","serial":3,"lineage":"l",
+	  "outputs":{"ip":{"value":"10.0.0.1","type":"string"},"token":{"value":"the-token","type":"string","sensitive":true}},
+	  "resources":[{"mode":"managed","type":"null_resource","name":"x","instances":[{"attributes":{"id":"the-id"}}]}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing a document: %+v", err)
+	}
+	tests := []struct {
+		excludeSensitive bool
+		contains         []string
+		excludes         []string
+	}{
+		{false, []string{`"serial": 3`, `"lineage": "l"`, `"resources": []`, "10.0.0.1", "the-token"}, []string{"the-id"}},
+		{true, []string{"10.0.0.1"}, []string{"the-id", "the-token"}},
+	}
+	for _, tt := range tests {
+		data, err := document.OutputsOnly(tt.excludeSensitive)
+		if err != nil {
+			t.Fatalf("got unexpected error when extracting outputs: %+v", err)
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(string(data), s) {
+				t.Errorf("the outputs document should contain %s: %s", s, data)
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(string(data), s) {
+				t.Errorf("the outputs document should not contain %s: %s", s, data)
+			}
+		}
+	}
+	encrypted, err := ParseDocument([]byte(`{"serial":1,"lineage":"l","meta":{},"encrypted_data":"x","encryption_version":"v0"}`))
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing an encrypted document: %+v", err)
+	}
+	if _, err := encrypted.OutputsOnly(false); err == nil {
+		t.Errorf("extracting the outputs of an encrypted state should have failed")
+	}
+}
@@ -0,0 +1,162 @@
+package tfstate
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A node of the tree of a decoded value. Leaves have no children and hold the
+// compact JSON of their value
+type Node struct {
+	Children []Node
+	Name     string
+	Value    string
+}
+
+// Returns the tree of a decoded value: objects and arrays become nodes with
+// children while scalars and empty objects or arrays become leaves
+func NewNode(name string, value any) Node {
+	node := Node{Name: name}
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) > 0 {
+			node.Children = make([]Node, 0, len(v))
+			for _, key := range sortedKeys(v) {
+				node.Children = append(node.Children, NewNode(Path{key}.String(), v[key]))
+			}
+			return node
+		}
+	case []any:
+		if len(v) > 0 {
+			node.Children = make([]Node, 0, len(v))
+			for i, child := range v {
+				node.Children = append(node.Children, NewNode(Path{i}.String(), child))
+			}
+			return node
+		}
+	}
+	node.Value = Format(value)
+	return node
+}
+
+// A state arranged for browsing
+type Explorer struct {
+	CheckResults []Node
+	Modules      []ExplorerModule
+	Outputs      []Node
+}
+
+type ExplorerModule struct {
+	Address   string // empty for the root module
+	Resources []ExplorerResource
+}
+
+type ExplorerResource struct {
+	Address   string
+	Each      string
+	Instances []ExplorerInstance
+	Provider  string
+}
+
+type ExplorerInstance struct {
+	Address             string
+	Attributes          []Node
+	CreateBeforeDestroy bool
+	Dependencies        []string
+	SchemaVersion       int
+	Status              string
+}
+
+// Returns the state arranged for browsing, with its sensitive values masked
+// unless reveal is true
+func (document *Document) Explore(reveal bool) (*Explorer, error) {
+	explorer := Explorer{
+		CheckResults: make([]Node, 0),
+		Modules:      make([]ExplorerModule, 0),
+		Outputs:      make([]Node, 0),
+	}
+	modules := make(map[string]int)
+	for i := range document.Resources {
+		resource := &document.Resources[i]
+		m, ok := modules[resource.Module]
+		if !ok {
+			m = len(explorer.Modules)
+			modules[resource.Module] = m
+			explorer.Modules = append(explorer.Modules, ExplorerModule{
+				Address:   resource.Module,
+				Resources: make([]ExplorerResource, 0),
+			})
+		}
+		r := ExplorerResource{
+			Address:   resource.Address(),
+			Each:      resource.Each,
+			Instances: make([]ExplorerInstance, 0, len(resource.Instances)),
+			Provider:  resource.Provider,
+		}
+		for j := range resource.Instances {
+			instance := &resource.Instances[j]
+			var (
+				attributes any
+				err        error
+			)
+			if reveal {
+				attributes, err = Decode(instance.Attributes)
+			} else {
+				attributes, err = instance.MaskedAttributes()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the attributes of %s: %w", resource.InstanceAddress(instance), err)
+			}
+			r.Instances = append(r.Instances, ExplorerInstance{
+				Address:             resource.InstanceAddress(instance),
+				Attributes:          NewNode("", attributes).Children,
+				CreateBeforeDestroy: instance.CreateBeforeDestroy,
+				Dependencies:        instance.Dependencies,
+				SchemaVersion:       instance.SchemaVersion,
+				Status:              instance.Status,
+			})
+		}
+		explorer.Modules[m].Resources = append(explorer.Modules[m].Resources, r)
+	}
+	slices.SortStableFunc(explorer.Modules, func(a, b ExplorerModule) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	names := make([]string, 0, len(document.Outputs))
+	for name := range document.Outputs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		output := document.Outputs[name]
+		if output.Sensitive && !reveal {
+			explorer.Outputs = append(explorer.Outputs, Node{Name: name, Value: Masked})
+			continue
+		}
+		value, err := Decode(output.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode output %s: %w", name, err)
+		}
+		explorer.Outputs = append(explorer.Outputs, NewNode(name, value))
+	}
+	checkResults, err := Decode(document.CheckResults)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode check results: %w", err)
+	}
+	if results, ok := checkResults.([]any); ok {
+		for i, result := range results {
+			name := strconv.Itoa(i)
+			if m, ok := result.(map[string]any); ok {
+				if address, ok := m["config_addr"].(string); ok {
+					name = address
+				}
+				if status, ok := m["status"].(string); ok {
+					name += " (" + status + ")"
+				}
+			}
+			explorer.CheckResults = append(explorer.CheckResults, NewNode(name, result))
+		}
+	}
+	return &explorer, nil
+}
@@ -0,0 +1,54 @@
+package tfstate
+
+import (
+	"testing"
+)
+
+func TestExplore(t *testing.T) {
+	document, err := ParseDocument([]byte(`{"version":4,"serial":1,"lineage":"l",
+	  "outputs":{"vpc":{"value":{"id":"vpc-1","cidrs":["10.0.0.0/16"]},"type":"object"},"password":{"value":"p","type":"string","sensitive":true}},
+	  "resources":[
+	    {"module":"module.net","mode":"managed","type":"aws_vpc","name":"main","provider":"provider[\"registry.opentofu.org/hashicorp/aws\"]","instances":[{"attributes":{"id":"vpc-1","tags":{}},"dependencies":["aws_iam_role.x"]}]},
+	    {"mode":"managed","type":"random_password","name":"db","each":"list","instances":[{"index_key":0,"attributes":{"result":"secret"},"sensitive_attributes":[[{"type":"get_attr","value":"result"}]]}]}],
+	  "check_results":[{"object_kind":"resource","config_addr":"aws_vpc.main","status":"pass","objects":null}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing a document: %+v", err)
+	}
+	explorer, err := document.Explore(false)
+	if err != nil {
+		t.Fatalf("got unexpected error when exploring a document: %+v", err)
+	}
+	if len(explorer.Modules) != 2 || explorer.Modules[0].Address != "" || explorer.Modules[1].Address != "module.net" {
+		t.Fatalf("got modules %+v, wanted the root module then module.net", explorer.Modules)
+	}
+	root := explorer.Modules[0].Resources
+	if len(root) != 1 || root[0].Address != "random_password.db" || root[0].Instances[0].Address != "random_password.db[0]" {
+		t.Fatalf("got root module resources %+v", root)
+	}
+	if attributes := root[0].Instances[0].Attributes; len(attributes) != 1 || attributes[0].Value != Masked {
+		t.Errorf("sensitive attributes should be masked, got %+v", attributes)
+	}
+	instance := explorer.Modules[1].Resources[0].Instances[0]
+	if len(instance.Dependencies) != 1 || len(instance.Attributes) != 2 || instance.Attributes[1].Value != "{}" {
+		t.Errorf("got instance %+v", instance)
+	}
+	if len(explorer.Outputs) != 2 || explorer.Outputs[0].Value != Masked {
+		t.Fatalf("got outputs %+v, wanted a masked password then the vpc", explorer.Outputs)
+	}
+	vpc := explorer.Outputs[1]
+	if vpc.Name != "vpc" || len(vpc.Children) != 2 || vpc.Children[0].Name != "cidrs" || vpc.Children[0].Children[0].Name != "[0]" {
+		t.Errorf("got vpc output %+v", vpc)
+	}
+	if len(explorer.CheckResults) != 1 || explorer.CheckResults[0].Name != "aws_vpc.main (pass)" {
+		t.Errorf("got check results %+v", explorer.CheckResults)
+	}
+	if explorer, err = document.Explore(true); err != nil {
+		t.Fatalf("got unexpected error when exploring a revealed document: %+v", err)
+	}
+	if attributes := explorer.Modules[0].Resources[0].Instances[0].Attributes; attributes[0].Value != `"secret"` {
+		t.Errorf("revealed sensitive attributes should not be masked, got %+v", attributes)
+	}
+	if explorer.Outputs[0].Value != `"p"` {
+		t.Errorf("revealed sensitive outputs should not be masked, got %+v", explorer.Outputs[0])
+	}
+}
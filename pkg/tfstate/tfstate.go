@@ -0,0 +1,63 @@
+package tfstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The state format version written by OpenTofu and Terraform
+const FormatVersion = 4
+
+// The metadata of an OpenTofu or Terraform state that tfstated cares about.
+// OpenTofu's encrypted states do not have a version field but keep their
+// lineage and serial in clear text, which is why Version is a pointer
+type State struct {
+	Lineage string  `json:"lineage"`
+	Serial  *uint64 `json:"serial"`
+	Version *int    `json:"version"`
+}
+
+// Parses and validates the metadata of a state
+func Parse(data []byte) (*State, error) {
+	var state State
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&state); err != nil {
+		return nil, fmt.Errorf("invalid state JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid state JSON: trailing data after the state object")
+	}
+	if state.Version != nil && *state.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported state format version %d, expected %d", *state.Version, FormatVersion)
+	}
+	if state.Lineage == "" {
+		return nil, errors.New("invalid state: missing lineage")
+	}
+	if state.Serial == nil {
+		return nil, errors.New("invalid state: missing serial")
+	}
+	return &state, nil
+}
+
+// Returns the data of a state with another serial, which is how a past version
+// can replace the current one
+func SetSerial(data []byte, serial uint64) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid state JSON: %w", err)
+	}
+	if fields == nil {
+		return nil, errors.New("invalid state JSON: not an object")
+	}
+	fields["serial"] = json.RawMessage(fmt.Sprintf("%d", serial))
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(fields); err != nil {
+		return nil, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return b.Bytes(), nil
+}
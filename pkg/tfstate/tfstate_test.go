@@ -0,0 +1,62 @@
+package tfstate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		data    string
+		lineage string
+		serial  uint64
+		valid   bool
+		msg     string
+	}{
+		{`{"version":4,"serial":3,"lineage":"a-lineage","outputs":{},"resources":[]}`, "a-lineage", 3, true, "a plain state"},
+		{`{"serial":1,"lineage":"a-lineage","meta":{},"encrypted_data":"","encryption_version":"v0"}`, "a-lineage", 1, true, "an encrypted state"},
+		{`{"version":4,"serial":0,"lineage":"a-lineage"}`, "a-lineage", 0, true, "a zero serial"},
+		{``, "", 0, false, "an empty body"},
+		{`the_state`, "", 0, false, "a body that is not JSON"},
+		{`[]`, "", 0, false, "a JSON array"},
+		{`{"version":3,"serial":1,"lineage":"a-lineage"}`, "", 0, false, "an unsupported format version"},
+		{`{"version":4,"serial":1}`, "", 0, false, "a missing lineage"},
+		{`{"version":4,"lineage":"a-lineage"}`, "", 0, false, "a missing serial"},
+		{`{"version":4,"serial":-1,"lineage":"a-lineage"}`, "", 0, false, "a negative serial"},
+		{`{"version":4,"serial":1,"lineage":"a-lineage"}{}`, "", 0, false, "trailing data"},
+	}
+	for _, tt := range tests {
+		state, err := Parse([]byte(tt.data))
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("parsing %s should have failed", tt.msg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("got unexpected error when parsing %s: %+v", tt.msg, err)
+		} else if state.Lineage != tt.lineage || *state.Serial != tt.serial {
+			t.Errorf("got lineage %s and serial %d for %s, wanted %s and %d", state.Lineage, *state.Serial, tt.msg, tt.lineage, tt.serial)
+		}
+	}
+}
+
+func TestSetSerial(t *testing.T) {
+	data, err := SetSerial([]byte(`{"version":4,"serial":3,"lineage":"a-lineage","outputs":{"url":{"value":"https://a?b=1&c=<d>","type":"string"}},"resources":[]}`), 7)
+	if err != nil {
+		t.Fatalf("got unexpected error when setting the serial: %+v", err)
+	}
+	state, err := Parse(data)
+	if err != nil {
+		t.Fatalf("got unexpected error when parsing a state with a new serial: %+v", err)
+	}
+	if state.Lineage != "a-lineage" || *state.Serial != 7 {
+		t.Errorf("got lineage %s and serial %d, wanted a-lineage and 7", state.Lineage, *state.Serial)
+	}
+	if !strings.Contains(string(data), `"https://a?b=1&c=<d>"`) {
+		t.Errorf("the other fields should be kept verbatim: %s", data)
+	}
+	if _, err := SetSerial([]byte(`null`), 1); err == nil {
+		t.Errorf("setting the serial of a null state should have failed")
+	}
+}
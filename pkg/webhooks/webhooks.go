@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook deliveries are JSON POST requests carrying the following headers:
+//   - X-Tfstated-Delivery: the delivery id, identical across retries
+//   - X-Tfstated-Event: the event name
+//   - X-Tfstated-Timestamp: the unix time of the attempt
+//   - X-Tfstated-Signature: "sha256=" followed by the hex encoded
+//     HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret
+//
+// Receivers should recompute the signature and reject stale timestamps.
+
+const (
+	HeaderDelivery  = "X-Tfstated-Delivery"
+	HeaderEvent     = "X-Tfstated-Event"
+	HeaderSignature = "X-Tfstated-Signature"
+	HeaderTimestamp = "X-Tfstated-Timestamp"
+)
+
+// Returns the signature header value of a body sent at timestamp
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns true if signature is valid for a body sent at timestamp
+func Verify(secret []byte, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Sends a delivery and returns the response status code. Any status outside
+// the 2xx range is an error
+func Deliver(ctx context.Context, client *http.Client, url string, secret []byte, deliveryId string, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tfstated-webhooks")
+	req.Header.Set(HeaderDelivery, deliveryId)
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
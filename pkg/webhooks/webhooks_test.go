@@ -0,0 +1,73 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"event":"lock.acquired"}`)
+	signature := Sign(secret, 1700000000, body)
+	if want := "sha256=98d54221bf1e691cf3da798075893bd3059141a2321047932b7e58c986d63ebe"; signature != want {
+		t.Errorf("got signature %s, wanted %s", signature, want)
+	}
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		expected  bool
+		msg       string
+	}{
+		{"secret", 1700000000, `{"event":"lock.acquired"}`, true, "the signed body"},
+		{"other", 1700000000, `{"event":"lock.acquired"}`, false, "another secret"},
+		{"secret", 1700000001, `{"event":"lock.acquired"}`, false, "another timestamp"},
+		{"secret", 1700000000, `{"event":"lock.released"}`, false, "another body"},
+	}
+	for _, tt := range tests {
+		if got := Verify([]byte(tt.secret), tt.timestamp, []byte(tt.body), signature); got != tt.expected {
+			t.Errorf("got %v when verifying %s, wanted %v", got, tt.msg, tt.expected)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	secret := []byte("secret")
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read body: %+v", err)
+		}
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if err != nil {
+			t.Errorf("failed to parse timestamp: %+v", err)
+		}
+		if !Verify(secret, timestamp, body, r.Header.Get(HeaderSignature)) {
+			t.Errorf("got invalid signature %s", r.Header.Get(HeaderSignature))
+		}
+		if got := r.Header.Get(HeaderDelivery); got != "delivery" {
+			t.Errorf("got delivery %s, wanted delivery", got)
+		}
+		if got := r.Header.Get(HeaderEvent); got != "lock.acquired" {
+			t.Errorf("got event %s, wanted lock.acquired", got)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	body := []byte(`{"event":"lock.acquired"}`)
+	code, err := Deliver(context.Background(), server.Client(), server.URL, secret, "delivery", "lock.acquired", body)
+	if err != nil || code != http.StatusNoContent {
+		t.Errorf("got %d and error %+v when delivering, wanted %d", code, err, http.StatusNoContent)
+	}
+	status = http.StatusInternalServerError
+	code, err = Deliver(context.Background(), server.Client(), server.URL, secret, "delivery", "lock.acquired", body)
+	if err == nil || code != http.StatusInternalServerError {
+		t.Errorf("got %d and error %+v when delivering to a failing receiver, wanted %d and an error", code, err, http.StatusInternalServerError)
+	}
+}
@@ -73,6 +73,8 @@ func handleAccountsPOST(db *database.DB) http.Handler {
 			render(w, accountsTemplates, http.StatusBadRequest, page)
 			return
 		}
+		recordAuditEvent(db, r, model.AuditAccountCreate, model.AuditTargetAccount, account.Id, account.Username,
+			nil, map[string]any{"is_admin": account.IsAdmin, "username": account.Username})
 		destination := path.Join("/accounts", account.Id.String())
 		http.Redirect(w, r, destination, http.StatusFound)
 	})
@@ -1,9 +1,12 @@
 package webui
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"slices"
+	"time"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
@@ -11,17 +14,40 @@ import (
 )
 
 type AccountsIdPage struct {
-	Account           *model.Account
-	IsAdmin           string
-	Page              *Page
-	Username          string
-	StatePaths        map[string]string
-	UsernameDuplicate bool
-	UsernameInvalid   bool
-	Versions          []model.Version
+	Account             *model.Account
+	CanManageTokens     bool
+	Email               string
+	EmailInvalid        bool
+	Grants              model.Grants
+	GroupNames          map[string]string
+	Groups              []model.Group
+	IsAdmin             string
+	Page                *Page
+	PathPrefix          string
+	PathPrefixError     bool
+	Permission          string
+	Permissions         []model.Permission
+	NewToken            string
+	ResetEmailed        bool
+	Username            string
+	StatePaths          map[string]string
+	TokenExpires        string
+	TokenExpiresInvalid bool
+	TokenName           string
+	TokenNameDuplicate  bool
+	TokenNameInvalid    bool
+	TokenScope          string
+	Tokens              []model.Token
+	UsernameDuplicate   bool
+	UsernameInvalid     bool
+	Versions            []model.Version
 }
 
-var accountsIdTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/accountsId.html"))
+var accountsIdTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/accountsId.html", "html/grantForm.html"))
+
+func (page *AccountsIdPage) GrantAction() string {
+	return "/accounts/" + page.Account.Id.String()
+}
 
 func prepareAccountsIdPage(db *database.DB, w http.ResponseWriter, r *http.Request) *AccountsIdPage {
 	var accountId uuid.UUID
@@ -48,19 +74,57 @@ func prepareAccountsIdPage(db *database.DB, w http.ResponseWriter, r *http.Reque
 		errorResponse(w, r, http.StatusInternalServerError, err)
 		return nil
 	}
+	sessionGrants, err := loadSessionGrants(db, r)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	versions = slices.DeleteFunc(versions, func(version model.Version) bool {
+		return !sessionAllows(r, sessionGrants, statePaths[version.StateId.String()], model.PermissionRead)
+	})
+	grants, err := db.LoadGrantsByAccount(account.Id)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	groups, err := db.LoadGroupsByAccount(account.Id)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	groupNames := make(map[string]string)
+	for _, group := range groups {
+		groupNames[group.Id.String()] = group.Name
+	}
+	session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+	canManageTokens := session.Data.Account.IsAdmin || session.Data.Account.Id.Equal(account.Id)
+	var tokens []model.Token
+	if canManageTokens {
+		if tokens, err = db.LoadTokensByAccount(account); err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return nil
+		}
+	}
 	isAdmin := ""
 	if account.IsAdmin {
 		isAdmin = "1"
 	}
 	return &AccountsIdPage{
-		Account: account,
-		IsAdmin: isAdmin,
+		Account:         account,
+		CanManageTokens: canManageTokens,
+		Email:           account.Email,
+		Grants:          grants,
+		GroupNames:      groupNames,
+		Groups:          groups,
+		IsAdmin:         isAdmin,
 		Page: makePage(r, &Page{
 			Section: "accounts",
 			Title:   account.Username,
 		}),
-		StatePaths: statePaths,
-		Versions:   versions,
+		Permissions: model.Permissions,
+		StatePaths:  statePaths,
+		Tokens:      tokens,
+		Versions:    versions,
 	}
 
 }
@@ -90,6 +154,95 @@ func handleAccountsIdPOST(db *database.DB) http.Handler {
 		session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
 		action := r.FormValue("action")
 		switch action {
+		case "create-token", "revoke-token":
+			if !page.CanManageTokens {
+				errorResponse(w, r, http.StatusForbidden,
+					fmt.Errorf("Only administrators can manage the API tokens of other accounts."))
+				return
+			}
+		default:
+			if !session.Data.Account.IsAdmin {
+				errorResponse(w, r, http.StatusForbidden,
+					fmt.Errorf("Only administrators can perform this request."))
+				return
+			}
+		}
+		switch action {
+		case "create-token":
+			if page.Account.Deleted {
+				errorResponse(w, r, http.StatusBadRequest,
+					fmt.Errorf("You cannot create an API token for this account because it is marked for deletion."))
+				return
+			}
+			page.TokenExpires = r.FormValue("token-expires")
+			page.TokenName = r.FormValue("token-name")
+			page.TokenScope = r.FormValue("token-scope")
+			scope := model.Permission(page.TokenScope)
+			if !scope.Valid() {
+				errorResponse(w, r, http.StatusBadRequest,
+					fmt.Errorf("invalid token scope %s", page.TokenScope))
+				return
+			}
+			if ok := validUsername.MatchString(page.TokenName); !ok {
+				page.TokenNameInvalid = true
+				render(w, accountsIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			var expires *time.Time
+			if page.TokenExpires != "" {
+				e, err := time.Parse(time.DateOnly, page.TokenExpires)
+				if err != nil || e.Before(time.Now()) {
+					page.TokenExpiresInvalid = true
+					render(w, accountsIdTemplates, http.StatusBadRequest, page)
+					return
+				}
+				expires = &e
+			}
+			secret, token, err := db.CreateToken(page.Account, page.TokenName, scope, expires)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to create token: %w", err))
+				return
+			}
+			if token == nil {
+				page.TokenNameDuplicate = true
+				render(w, accountsIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditTokenCreate, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+				nil, map[string]any{"expires": token.Expires, "name": token.Name, "scope": token.Scope})
+			page.NewToken = secret
+			page.Tokens = append(page.Tokens, *token)
+			page.TokenExpires = ""
+			page.TokenName = ""
+			page.TokenScope = ""
+		case "revoke-token":
+			var tokenId uuid.UUID
+			if err := tokenId.Parse(r.FormValue("token")); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			i := slices.IndexFunc(page.Tokens, func(token model.Token) bool {
+				return token.Id.Equal(tokenId)
+			})
+			success, err := db.DeleteToken(page.Account, tokenId)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to revoke token: %w", err))
+				return
+			}
+			if !success {
+				errorResponse(w, r, http.StatusNotFound,
+					fmt.Errorf("The token Id could not be found for this account."))
+				return
+			}
+			before := map[string]any{"id": tokenId}
+			if i >= 0 {
+				before["name"] = page.Tokens[i].Name
+				page.Tokens = slices.Delete(page.Tokens, i, i+1)
+			}
+			recordAuditEvent(db, r, model.AuditTokenRevoke, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+				before, nil)
 		case "delete":
 			if !page.Account.Deleted {
 				page.Account.MarkForDeletion()
@@ -109,8 +262,17 @@ func handleAccountsIdPOST(db *database.DB) http.Handler {
 						fmt.Errorf("failed to delete sessions: %w", err))
 					return
 				}
+				if err := db.DeleteTokens(page.Account); err != nil {
+					errorResponse(w, r, http.StatusInternalServerError,
+						fmt.Errorf("failed to delete tokens: %w", err))
+					return
+				}
+				page.Tokens = nil
+				recordAuditEvent(db, r, model.AuditAccountDelete, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+					nil, nil)
 			}
 		case "edit":
+			page.Email = r.FormValue("email")
 			page.Username = r.FormValue("username")
 			isAdmin := r.FormValue("is-admin")
 			if ok := validUsername.MatchString(page.Username); !ok {
@@ -118,10 +280,17 @@ func handleAccountsIdPOST(db *database.DB) http.Handler {
 				render(w, accountsIdTemplates, http.StatusBadRequest, page)
 				return
 			}
+			if page.Email != "" && !validEmail(page.Email) {
+				page.EmailInvalid = true
+				render(w, accountsIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			before := map[string]any{"email": page.Account.Email, "is_admin": page.Account.IsAdmin, "username": page.Account.Username}
 			if page.Account.Id != session.Data.Account.Id {
 				page.Account.IsAdmin = isAdmin == "1"
 			}
 			prev := page.Account.Username
+			page.Account.Email = page.Email
 			page.Account.Username = page.Username
 			success, err := db.SaveAccount(page.Account)
 			if err != nil {
@@ -135,6 +304,55 @@ func handleAccountsIdPOST(db *database.DB) http.Handler {
 				render(w, accountsIdTemplates, http.StatusBadRequest, page)
 				return
 			}
+			recordAuditEvent(db, r, model.AuditAccountEdit, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+				before, map[string]any{"email": page.Account.Email, "is_admin": page.Account.IsAdmin, "username": page.Account.Username})
+		case "grant":
+			page.PathPrefix = r.FormValue("path-prefix")
+			page.Permission = r.FormValue("permission")
+			permission := model.Permission(page.Permission)
+			if !permission.Valid() {
+				errorResponse(w, r, http.StatusBadRequest,
+					fmt.Errorf("invalid permission %s", page.Permission))
+				return
+			}
+			if !validPath(page.PathPrefix) {
+				page.PathPrefixError = true
+				render(w, accountsIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			grant, err := db.CreateGrant(&page.Account.Id, nil, page.PathPrefix, permission)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to create grant: %w", err))
+				return
+			}
+			recordAuditEvent(db, r, model.AuditAccountGrant, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+				nil, map[string]any{"path_prefix": grant.PathPrefix, "permission": grant.Permission})
+			page.Grants = append(page.Grants, *grant)
+			page.PathPrefix = ""
+			page.Permission = ""
+		case "revoke":
+			var grantId uuid.UUID
+			if err := grantId.Parse(r.FormValue("grant")); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			i := slices.IndexFunc(page.Grants, func(grant model.Grant) bool {
+				return grant.Id.Equal(grantId) && grant.AccountId != nil
+			})
+			if i < 0 {
+				errorResponse(w, r, http.StatusNotFound,
+					fmt.Errorf("The grant Id could not be found for this account."))
+				return
+			}
+			if _, err := db.DeleteGrant(grantId); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to delete grant: %w", err))
+				return
+			}
+			recordAuditEvent(db, r, model.AuditAccountRevoke, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+				map[string]any{"path_prefix": page.Grants[i].PathPrefix, "permission": page.Grants[i].Permission}, nil)
+			page.Grants = slices.Delete(page.Grants, i, i+1)
 		case "reset-password":
 			if page.Account.Deleted {
 				errorResponse(w, r, http.StatusBadRequest,
@@ -162,6 +380,17 @@ func handleAccountsIdPOST(db *database.DB) http.Handler {
 					fmt.Errorf("failed to delete sessions: %w", err))
 				return
 			}
+			recordAuditEvent(db, r, model.AuditAccountResetPassword, model.AuditTargetAccount, page.Account.Id, page.Account.Username,
+				nil, nil)
+			if page.Account.Email != "" {
+				err := db.SendPasswordResetEmail(page.Account)
+				if err != nil && !errors.Is(err, database.ErrMailDisabled) {
+					errorResponse(w, r, http.StatusInternalServerError,
+						fmt.Errorf("failed to send password reset email: %w", err))
+					return
+				}
+				page.ResetEmailed = err == nil
+			}
 		default:
 			errorResponse(w, r, http.StatusBadRequest, nil)
 			return
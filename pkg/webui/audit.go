@@ -0,0 +1,139 @@
+package webui
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+// Records an action of the session's account. Failures are only logged
+// because the action already happened
+func recordAuditEvent(db *database.DB, r *http.Request, action string, targetType string, targetId uuid.UUID, target string, before any, after any) {
+	account := r.Context().Value(model.SessionContextKey{}).(*model.Session).Data.Account
+	event, err := model.NewAuditEvent(account, action, targetType, &targetId, target, before, after)
+	if err == nil {
+		event.SourceIP = helpers.RemoteIP(r)
+		err = db.RecordAuditEvent(event)
+	}
+	if err != nil {
+		slog.Error("failed to record audit event", "action", action, "target", target, "err", err)
+	}
+}
+
+const auditEventsPageSize = 100
+
+type AuditPage struct {
+	Action      string
+	Actions     []string
+	Actor       string
+	DateError   bool
+	Events      []model.AuditEvent
+	Export      template.URL
+	Older       template.URL
+	Page        *Page
+	Since       string
+	Target      string
+	TargetType  string
+	TargetTypes []string
+	Until       string
+}
+
+var auditTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/audit.html"))
+
+// Parses the filter query parameters shared by the audit page and its export.
+// Returns false when a date is invalid
+func parseAuditFilter(r *http.Request) (*model.AuditFilter, bool) {
+	query := r.URL.Query()
+	filter := &model.AuditFilter{
+		Action:     query.Get("action"),
+		Actor:      query.Get("actor"),
+		Target:     query.Get("target"),
+		TargetType: query.Get("target-type"),
+	}
+	if s := query.Get("before"); s != "" {
+		var before uuid.UUID
+		if err := before.Parse(s); err != nil {
+			return nil, false
+		}
+		filter.Before = &before
+	}
+	if s := query.Get("since"); s != "" {
+		since, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+		if err != nil {
+			return nil, false
+		}
+		filter.Since = &since
+	}
+	if s := query.Get("until"); s != "" {
+		until, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+		if err != nil {
+			return nil, false
+		}
+		until = until.AddDate(0, 0, 1)
+		filter.Until = &until
+	}
+	return filter, true
+}
+
+func handleAuditGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page := &AuditPage{
+			Action:      query.Get("action"),
+			Actions:     model.AuditActions,
+			Actor:       query.Get("actor"),
+			Page:        makePage(r, &Page{Title: "Audit Log", Section: "audit"}),
+			Since:       query.Get("since"),
+			Target:      query.Get("target"),
+			TargetType:  query.Get("target-type"),
+			TargetTypes: []string{model.AuditTargetAccount, model.AuditTargetGroup, model.AuditTargetState, model.AuditTargetWebhook},
+			Until:       query.Get("until"),
+		}
+		filter, ok := parseAuditFilter(r)
+		if !ok {
+			page.DateError = true
+			render(w, auditTemplates, http.StatusBadRequest, page)
+			return
+		}
+		filter.Limit = auditEventsPageSize
+		events, err := db.LoadAuditEvents(filter)
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		page.Events = events
+		page.Export = template.URL("/audit/export?" + query.Encode())
+		if len(events) == auditEventsPageSize {
+			query.Set("before", events[len(events)-1].Id.String())
+			page.Older = template.URL("/audit?" + query.Encode())
+		}
+		render(w, auditTemplates, http.StatusOK, page)
+	})
+}
+
+// Exports every audit event matching the filter as a JSON array
+func handleAuditExportGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, ok := parseAuditFilter(r)
+		if !ok {
+			helpers.ErrorResponse(w, http.StatusBadRequest,
+				fmt.Errorf("invalid filter, dates are expected as YYYY-MM-DD"))
+			return
+		}
+		filter.Limit = -1
+		events, err := db.LoadAuditEvents(filter)
+		if err != nil {
+			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="tfstated-audit.json"`)
+		_ = helpers.Encode(w, http.StatusOK, events)
+	})
+}
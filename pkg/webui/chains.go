@@ -0,0 +1,37 @@
+package webui
+
+import (
+	"html/template"
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+type ChainsPage struct {
+	Broken  int
+	Page    *Page
+	Reports []model.ChainReport
+}
+
+var chainsTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/chains.html"))
+
+func handleChainsGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports, err := db.VerifyChains()
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		page := &ChainsPage{
+			Page:    makePage(r, &Page{Title: "Version Chains", Section: "chains"}),
+			Reports: reports,
+		}
+		for _, report := range reports {
+			if !report.Valid() {
+				page.Broken++
+			}
+		}
+		render(w, chainsTemplates, http.StatusOK, page)
+	})
+}
@@ -0,0 +1,77 @@
+package webui
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+type GroupsPage struct {
+	Groups        []model.Group
+	Name          string
+	NameDuplicate bool
+	NameInvalid   bool
+	Page          *Page
+}
+
+var groupsTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/groups.html"))
+
+func handleGroupsGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		groups, err := db.LoadGroups()
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		render(w, groupsTemplates, http.StatusOK, GroupsPage{
+			Groups: groups,
+			Page:   makePage(r, &Page{Title: "Groups", Section: "groups"}),
+		})
+	})
+}
+
+func handleGroupsPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			errorResponse(w, r, http.StatusBadRequest,
+				fmt.Errorf("failed to parse form: %w", err))
+			return
+		}
+		if !verifyCSRFToken(w, r) {
+			return
+		}
+		groups, err := db.LoadGroups()
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		page := GroupsPage{
+			Groups: groups,
+			Name:   r.FormValue("name"),
+			Page:   makePage(r, &Page{Title: "New Group", Section: "groups"}),
+		}
+		if ok := validUsername.MatchString(page.Name); !ok {
+			page.NameInvalid = true
+			render(w, groupsTemplates, http.StatusBadRequest, page)
+			return
+		}
+		group, err := db.CreateGroup(page.Name)
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if group == nil {
+			page.NameDuplicate = true
+			render(w, groupsTemplates, http.StatusBadRequest, page)
+			return
+		}
+		recordAuditEvent(db, r, model.AuditGroupCreate, model.AuditTargetGroup, group.Id, group.Name,
+			nil, map[string]any{"name": group.Name})
+		destination := path.Join("/groups", group.Id.String())
+		http.Redirect(w, r, destination, http.StatusFound)
+	})
+}
@@ -0,0 +1,198 @@
+package webui
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+type GroupsIdPage struct {
+	Grants          model.Grants
+	Group           *model.Group
+	Members         []uuid.UUID
+	Page            *Page
+	PathPrefix      string
+	PathPrefixError bool
+	Permission      string
+	Permissions     []model.Permission
+	Username        string
+	UsernameInvalid bool
+	Usernames       map[string]string
+}
+
+var groupsIdTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/groupsId.html", "html/grantForm.html"))
+
+func (page *GroupsIdPage) GrantAction() string {
+	return "/groups/" + page.Group.Id.String()
+}
+
+func prepareGroupsIdPage(db *database.DB, w http.ResponseWriter, r *http.Request) *GroupsIdPage {
+	var groupId uuid.UUID
+	if err := groupId.Parse(r.PathValue("id")); err != nil {
+		errorResponse(w, r, http.StatusBadRequest, err)
+		return nil
+	}
+	group, err := db.LoadGroupById(groupId)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	if group == nil {
+		errorResponse(w, r, http.StatusNotFound, fmt.Errorf("The group Id could not be found."))
+		return nil
+	}
+	grants, err := db.LoadGrantsByGroup(group.Id)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	members, err := db.LoadGroupMembers(group)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	usernames, err := db.LoadAccountUsernames()
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	return &GroupsIdPage{
+		Grants:  grants,
+		Group:   group,
+		Members: members,
+		Page: makePage(r, &Page{
+			Section: "groups",
+			Title:   group.Name,
+		}),
+		Permissions: model.Permissions,
+		Usernames:   usernames,
+	}
+}
+
+func handleGroupsIdGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := prepareGroupsIdPage(db, w, r)
+		if page != nil {
+			render(w, groupsIdTemplates, http.StatusOK, page)
+		}
+	})
+}
+
+func handleGroupsIdPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			errorResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if !verifyCSRFToken(w, r) {
+			return
+		}
+		page := prepareGroupsIdPage(db, w, r)
+		if page == nil {
+			return
+		}
+		action := r.FormValue("action")
+		switch action {
+		case "add-member":
+			page.Username = r.FormValue("username")
+			account, err := db.LoadAccountByUsername(page.Username)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if account == nil || account.Deleted {
+				page.UsernameInvalid = true
+				render(w, groupsIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			if err := db.AddGroupMember(page.Group, account.Id); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditGroupAddMember, model.AuditTargetGroup, page.Group.Id, page.Group.Name,
+				nil, map[string]any{"account_id": account.Id, "username": account.Username})
+			if !slices.ContainsFunc(page.Members, account.Id.Equal) {
+				page.Members = append(page.Members, account.Id)
+			}
+			page.Username = ""
+		case "delete":
+			if err := db.DeleteGroup(page.Group); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditGroupDelete, model.AuditTargetGroup, page.Group.Id, page.Group.Name,
+				nil, nil)
+			http.Redirect(w, r, "/groups", http.StatusFound)
+			return
+		case "grant":
+			page.PathPrefix = r.FormValue("path-prefix")
+			page.Permission = r.FormValue("permission")
+			permission := model.Permission(page.Permission)
+			if !permission.Valid() {
+				errorResponse(w, r, http.StatusBadRequest,
+					fmt.Errorf("invalid permission %s", page.Permission))
+				return
+			}
+			if !validPath(page.PathPrefix) {
+				page.PathPrefixError = true
+				render(w, groupsIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			grant, err := db.CreateGrant(nil, &page.Group.Id, page.PathPrefix, permission)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to create grant: %w", err))
+				return
+			}
+			recordAuditEvent(db, r, model.AuditGroupGrant, model.AuditTargetGroup, page.Group.Id, page.Group.Name,
+				nil, map[string]any{"path_prefix": grant.PathPrefix, "permission": grant.Permission})
+			page.Grants = append(page.Grants, *grant)
+			page.PathPrefix = ""
+			page.Permission = ""
+		case "remove-member":
+			var accountId uuid.UUID
+			if err := accountId.Parse(r.FormValue("account")); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if err := db.RemoveGroupMember(page.Group, accountId); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditGroupRemoveMember, model.AuditTargetGroup, page.Group.Id, page.Group.Name,
+				map[string]any{"account_id": accountId, "username": page.Usernames[accountId.String()]}, nil)
+			page.Members = slices.DeleteFunc(page.Members, accountId.Equal)
+		case "revoke":
+			var grantId uuid.UUID
+			if err := grantId.Parse(r.FormValue("grant")); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			i := slices.IndexFunc(page.Grants, func(grant model.Grant) bool {
+				return grant.Id.Equal(grantId)
+			})
+			if i < 0 {
+				errorResponse(w, r, http.StatusNotFound,
+					fmt.Errorf("The grant Id could not be found for this group."))
+				return
+			}
+			if _, err := db.DeleteGrant(grantId); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to delete grant: %w", err))
+				return
+			}
+			recordAuditEvent(db, r, model.AuditGroupRevoke, model.AuditTargetGroup, page.Group.Id, page.Group.Name,
+				map[string]any{"path_prefix": page.Grants[i].PathPrefix, "permission": page.Grants[i].Permission}, nil)
+			page.Grants = slices.Delete(page.Grants, i, i+1)
+		default:
+			errorResponse(w, r, http.StatusBadRequest, nil)
+			return
+		}
+		render(w, groupsIdTemplates, http.StatusOK, page)
+	})
+}
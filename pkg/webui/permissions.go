@@ -0,0 +1,49 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+// Renders an error page and returns false if the logged in account is not
+// allowed the permission on the state path
+func checkPermission(db *database.DB, w http.ResponseWriter, r *http.Request, statePath string, permission model.Permission) bool {
+	session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+	allowed, err := db.CheckPermission(session.Data.Account, statePath, permission)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	if !allowed {
+		errorResponse(w, r, http.StatusForbidden,
+			fmt.Errorf("You do not have the %s permission on %s.", permission, statePath))
+		return false
+	}
+	return true
+}
+
+// Returns the grants of the logged in account, or nil for administrators who
+// are allowed everything
+func loadSessionGrants(db *database.DB, r *http.Request) (model.Grants, error) {
+	session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+	if session.Data.Account.IsAdmin {
+		return nil, nil
+	}
+	return db.LoadGrantsByAccount(session.Data.Account.Id)
+}
+
+func sessionAllows(r *http.Request, grants model.Grants, statePath string, permission model.Permission) bool {
+	session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+	return session.Data.Account.IsAdmin || grants.Allow(statePath, permission)
+}
+
+// A valid path is absolute and clean
+func validPath(p string) bool {
+	parsed, err := url.Parse(p)
+	return err == nil && p != "" && p[0] == '/' && path.Clean(parsed.Path) == p
+}
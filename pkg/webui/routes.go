@@ -10,26 +10,45 @@ func addRoutes(
 	mux *http.ServeMux,
 	db *database.DB,
 ) {
-	requireSession := sessionsMiddleware(db)
+	allowSealed := sessionsMiddleware(db)
+	requireSession := sealedMiddleware(db, allowSealed)
 	requireLogin := loginMiddleware(requireSession)
 	requireAdmin := adminMiddleware(requireLogin)
+	requireSealedAdmin := adminMiddleware(loginMiddleware(allowSealed))
 	mux.Handle("GET /accounts", requireLogin(handleAccountsGET(db)))
 	mux.Handle("GET /accounts/{id}", requireLogin(handleAccountsIdGET(db)))
-	mux.Handle("POST /accounts/{id}", requireAdmin(handleAccountsIdPOST(db)))
+	mux.Handle("POST /accounts/{id}", requireLogin(handleAccountsIdPOST(db)))
 	mux.Handle("GET /accounts/{id}/reset/{token}", requireSession(handleAccountsIdResetPasswordGET(db)))
 	mux.Handle("POST /accounts/{id}/reset/{token}", requireSession(handleAccountsIdResetPasswordPOST(db)))
 	mux.Handle("POST /accounts", requireAdmin(handleAccountsPOST(db)))
+	mux.Handle("POST /api/unseal", handleAPIUnsealPOST(db))
+	mux.Handle("GET /audit", requireAdmin(handleAuditGET(db)))
+	mux.Handle("GET /audit/export", requireAdmin(handleAuditExportGET(db)))
+	mux.Handle("GET /chains", requireAdmin(handleChainsGET(db)))
+	mux.Handle("GET /groups", requireLogin(handleGroupsGET(db)))
+	mux.Handle("POST /groups", requireAdmin(handleGroupsPOST(db)))
+	mux.Handle("GET /groups/{id}", requireLogin(handleGroupsIdGET(db)))
+	mux.Handle("POST /groups/{id}", requireAdmin(handleGroupsIdPOST(db)))
 	mux.Handle("GET /healthz", handleHealthz())
-	mux.Handle("GET /login", requireSession(handleLoginGET()))
-	mux.Handle("POST /login", requireSession(handleLoginPOST(db)))
+	mux.Handle("GET /login", allowSealed(handleLoginGET()))
+	mux.Handle("POST /login", allowSealed(handleLoginPOST(db)))
 	mux.Handle("GET /logout", requireLogin(handleLogoutGET(db)))
-	mux.Handle("GET /settings", requireLogin(handleSettingsGET()))
+	mux.Handle("GET /settings", requireLogin(handleSettingsGET(db)))
 	mux.Handle("POST /settings", requireLogin(handleSettingsPOST(db)))
 	mux.Handle("GET /states", requireLogin(handleStatesGET(db)))
 	mux.Handle("POST /states", requireLogin(handleStatesPOST(db)))
 	mux.Handle("GET /states/{id}", requireLogin(handleStatesIdGET(db)))
 	mux.Handle("POST /states/{id}", requireLogin(handleStatesIdPOST(db)))
 	mux.Handle("GET /static/", cache(http.FileServer(http.FS(staticFS))))
+	mux.Handle("GET /unseal", requireSealedAdmin(handleUnsealGET(db)))
+	mux.Handle("POST /unseal", requireSealedAdmin(handleUnsealPOST(db)))
 	mux.Handle("GET /versions/{id}", requireLogin(handleVersionsGET(db)))
+	mux.Handle("POST /versions/{id}", requireLogin(handleVersionsPOST(db)))
+	mux.Handle("GET /versions/{id}/diff", requireLogin(handleVersionsIdDiffGET(db)))
+	mux.Handle("GET /versions/{id}/download", requireLogin(handleVersionsDownloadGET(db)))
+	mux.Handle("GET /webhooks", requireAdmin(handleWebhooksGET(db)))
+	mux.Handle("POST /webhooks", requireAdmin(handleWebhooksPOST(db)))
+	mux.Handle("GET /webhooks/{id}", requireAdmin(handleWebhooksIdGET(db)))
+	mux.Handle("POST /webhooks/{id}", requireAdmin(handleWebhooksIdPOST(db)))
 	mux.Handle("GET /", requireSession(handleIndexGET()))
 }
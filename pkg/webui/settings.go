@@ -2,25 +2,62 @@ package webui
 
 import (
 	"context"
+	"fmt"
 	"html/template"
 	"net/http"
+	"net/mail"
+	"slices"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
 )
 
 type SettingsPage struct {
-	Page     *Page
-	Settings *model.Settings
+	Email           string
+	EmailInvalid    bool
+	MailEnabled     bool
+	Page            *Page
+	PathPrefix      string
+	PathPrefixError bool
+	Settings        *model.Settings
+	Subscriptions   []model.Subscription
 }
 
 var settingsTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/settings.html"))
 
-func handleSettingsGET() http.Handler {
+// Returns true if s is a bare email address
+func validEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+	return err == nil && address.Address == s
+}
+
+func prepareSettingsPage(db *database.DB, w http.ResponseWriter, r *http.Request) (*SettingsPage, *model.Account) {
+	session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+	account, err := db.LoadAccountById(&session.Data.Account.Id)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil, nil
+	}
+	subscriptions, err := db.LoadSubscriptionsByAccount(account)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil, nil
+	}
+	return &SettingsPage{
+		Email:         account.Email,
+		MailEnabled:   db.MailEnabled(),
+		Page:          makePage(r, &Page{Title: "Settings", Section: "settings"}),
+		Subscriptions: subscriptions,
+	}, account
+}
+
+func handleSettingsGET(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		render(w, settingsTemplates, http.StatusOK, SettingsPage{
-			Page: makePage(r, &Page{Title: "Settings", Section: "settings"}),
-		})
+		page, _ := prepareSettingsPage(db, w, r)
+		if page != nil {
+			render(w, settingsTemplates, http.StatusOK, page)
+		}
 	})
 }
 
@@ -33,21 +70,81 @@ func handleSettingsPOST(db *database.DB) http.Handler {
 		if !verifyCSRFToken(w, r) {
 			return
 		}
-		darkMode := r.FormValue("dark-mode")
-		settings := model.Settings{
-			LightMode: darkMode != "1",
+		page, account := prepareSettingsPage(db, w, r)
+		if page == nil {
+			return
 		}
-		session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
-		session.Data.Settings = &settings
-		err := db.SaveAccountSettings(session.Data.Account, &settings)
-		if err != nil {
-			errorResponse(w, r, http.StatusInternalServerError, err)
+		switch r.FormValue("action") {
+		case "email":
+			page.Email = r.FormValue("email")
+			if page.Email != "" && !validEmail(page.Email) {
+				page.EmailInvalid = true
+				render(w, settingsTemplates, http.StatusBadRequest, page)
+				return
+			}
+			before := map[string]any{"email": account.Email}
+			account.Email = page.Email
+			if _, err := db.SaveAccount(account); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError,
+					fmt.Errorf("failed to save account: %w", err))
+				return
+			}
+			recordAuditEvent(db, r, model.AuditAccountEdit, model.AuditTargetAccount, account.Id, account.Username,
+				before, map[string]any{"email": account.Email})
+		case "settings", "":
+			darkMode := r.FormValue("dark-mode")
+			settings := model.Settings{
+				LightMode: darkMode != "1",
+			}
+			session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+			session.Data.Settings = &settings
+			err := db.SaveAccountSettings(session.Data.Account, &settings)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			ctx := context.WithValue(r.Context(), model.SessionContextKey{}, session)
+			page.Page = makePage(r.WithContext(ctx), &Page{Title: "Settings", Section: "settings"})
+			page.Settings = &settings
+		case "subscribe":
+			page.PathPrefix = r.FormValue("path-prefix")
+			if !validPath(page.PathPrefix) {
+				page.PathPrefixError = true
+				render(w, settingsTemplates, http.StatusBadRequest, page)
+				return
+			}
+			subscription, err := db.CreateSubscription(account, page.PathPrefix)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if subscription != nil {
+				page.Subscriptions = append(page.Subscriptions, *subscription)
+			}
+			page.PathPrefix = ""
+		case "unsubscribe":
+			var subscriptionId uuid.UUID
+			if err := subscriptionId.Parse(r.FormValue("subscription")); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			found, err := db.DeleteSubscription(account, subscriptionId)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if !found {
+				errorResponse(w, r, http.StatusNotFound,
+					fmt.Errorf("The subscription Id could not be found for this account."))
+				return
+			}
+			page.Subscriptions = slices.DeleteFunc(page.Subscriptions, func(subscription model.Subscription) bool {
+				return subscription.Id.Equal(subscriptionId)
+			})
+		default:
+			errorResponse(w, r, http.StatusBadRequest, nil)
 			return
 		}
-		ctx := context.WithValue(r.Context(), model.SessionContextKey{}, session)
-		render(w, settingsTemplates, http.StatusOK, SettingsPage{
-			Page:     makePage(r.WithContext(ctx), &Page{Title: "Settings", Section: "settings"}),
-			Settings: &settings,
-		})
+		render(w, settingsTemplates, http.StatusOK, page)
 	})
 }
@@ -1,11 +1,11 @@
 package webui
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
-	"net/url"
 	"path"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
@@ -13,6 +13,7 @@ import (
 )
 
 type StatesPage struct {
+	FileError     bool
 	Page          *Page
 	Path          string
 	PathError     bool
@@ -24,7 +25,7 @@ var statesTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "
 
 func handleStatesGET(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		states, err := db.LoadStates()
+		states, err := loadReadableStates(db, r)
 		if err != nil {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
@@ -38,7 +39,11 @@ func handleStatesGET(db *database.DB) http.Handler {
 
 func handleStatesPOST(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		states, err := db.LoadStates()
+		states, err := loadReadableStates(db, r)
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
 		// file upload limit of 20MB
 		if err := r.ParseMultipartForm(20 << 20); err != nil {
 			errorResponse(w, r, http.StatusBadRequest, err)
@@ -54,8 +59,7 @@ func handleStatesPOST(db *database.DB) http.Handler {
 		}
 		defer file.Close()
 		statePath := r.FormValue("path")
-		parsedStatePath, err := url.Parse(statePath)
-		if err != nil || path.Clean(parsedStatePath.Path) != statePath || statePath[0] != '/' {
+		if !validPath(statePath) {
 			render(w, statesTemplates, http.StatusBadRequest, StatesPage{
 				Page:      makePage(r, &Page{Title: "States", Section: "states"}),
 				Path:      statePath,
@@ -64,6 +68,9 @@ func handleStatesPOST(db *database.DB) http.Handler {
 			})
 			return
 		}
+		if !checkPermission(db, w, r, statePath, model.PermissionWrite) {
+			return
+		}
 		data, err := io.ReadAll(file)
 		if err != nil {
 			errorResponse(w, r, http.StatusBadRequest, fmt.Errorf("failed to read uploaded file: %w", err))
@@ -74,9 +81,18 @@ func handleStatesPOST(db *database.DB) http.Handler {
 			errorResponse(w, r, http.StatusBadRequest, fmt.Errorf("invalid file type: expected \"text/plain; charset=utf-8\" but got \"%s\"", fileType))
 			return
 		}
-		account := r.Context().Value(model.AccountContextKey{}).(*model.Account)
-		version, err := db.CreateState(statePath, account.Id, data)
+		session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+		version, err := db.CreateState(statePath, session.Data.Account.Id, data)
 		if err != nil {
+			if errors.Is(err, database.ErrInvalidState) {
+				render(w, statesTemplates, http.StatusBadRequest, StatesPage{
+					FileError: true,
+					Page:      makePage(r, &Page{Title: "States", Section: "states"}),
+					Path:      statePath,
+					States:    states,
+				})
+				return
+			}
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
@@ -93,3 +109,21 @@ func handleStatesPOST(db *database.DB) http.Handler {
 		http.Redirect(w, r, destination, http.StatusFound)
 	})
 }
+
+func loadReadableStates(db *database.DB, r *http.Request) ([]model.State, error) {
+	states, err := db.LoadStates()
+	if err != nil {
+		return nil, err
+	}
+	grants, err := loadSessionGrants(db, r)
+	if err != nil {
+		return nil, err
+	}
+	readable := make([]model.State, 0, len(states))
+	for _, state := range states {
+		if sessionAllows(r, grants, state.Path, model.PermissionRead) {
+			readable = append(readable, state)
+		}
+	}
+	return readable, nil
+}
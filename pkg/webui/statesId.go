@@ -1,10 +1,10 @@
 package webui
 
 import (
+	"fmt"
 	"html/template"
 	"net/http"
-	"net/url"
-	"path"
+	"time"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
@@ -12,13 +12,18 @@ import (
 )
 
 type StatesIdPage struct {
-	Page          *Page
-	Path          string
-	PathError     bool
-	PathDuplicate bool
-	State         *model.State
-	Usernames     map[string]string
-	Versions      []model.Version
+	DefaultLockTTL time.Duration
+	LockExpires    *time.Time
+	LockReleases   []model.LockRelease
+	LockTTL        string
+	LockTTLError   bool
+	Page           *Page
+	Path           string
+	PathError      bool
+	PathDuplicate  bool
+	State          *model.State
+	Usernames      map[string]string
+	Versions       []model.Version
 }
 
 var statesIdTemplate = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/statesId.html"))
@@ -35,28 +40,53 @@ func handleStatesIdGET(db *database.DB) http.Handler {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		versions, err := db.LoadVersionsByState(state)
-		if err != nil {
-			errorResponse(w, r, http.StatusInternalServerError, err)
+		if state == nil {
+			errorResponse(w, r, http.StatusNotFound, fmt.Errorf("The state Id could not be found."))
+			return
+		}
+		if !checkPermission(db, w, r, state.Path, model.PermissionRead) {
 			return
 		}
-		usernames, err := db.LoadAccountUsernames()
+		page, err := makeStatesIdPage(db, r, state)
 		if err != nil {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		render(w, statesIdTemplate, http.StatusOK, StatesIdPage{
-			Page: makePage(r, &Page{
-				Section: "states",
-				Title:   state.Path,
-			}),
-			State:     state,
-			Usernames: usernames,
-			Versions:  versions,
-		})
+		render(w, statesIdTemplate, http.StatusOK, page)
 	})
 }
 
+func makeStatesIdPage(db *database.DB, r *http.Request, state *model.State) (*StatesIdPage, error) {
+	releases, err := db.LoadLockReleasesByState(state)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := db.LoadVersionsByState(state)
+	if err != nil {
+		return nil, err
+	}
+	usernames, err := db.LoadAccountUsernames()
+	if err != nil {
+		return nil, err
+	}
+	page := &StatesIdPage{
+		DefaultLockTTL: db.LocksTTL(),
+		LockExpires:    db.LockExpires(state),
+		LockReleases:   releases,
+		Page: makePage(r, &Page{
+			Section: "states",
+			Title:   state.Path,
+		}),
+		State:     state,
+		Usernames: usernames,
+		Versions:  versions,
+	}
+	if state.LockTTL != nil {
+		page.LockTTL = state.LockTTL.String()
+	}
+	return page, nil
+}
+
 func handleStatesIdPOST(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
@@ -76,12 +106,14 @@ func handleStatesIdPOST(db *database.DB) http.Handler {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		versions, err := db.LoadVersionsByState(state)
-		if err != nil {
-			errorResponse(w, r, http.StatusInternalServerError, err)
+		if state == nil {
+			errorResponse(w, r, http.StatusNotFound, fmt.Errorf("The state Id could not be found."))
+			return
+		}
+		if !checkPermission(db, w, r, state.Path, model.PermissionRead) {
 			return
 		}
-		usernames, err := db.LoadAccountUsernames()
+		page, err := makeStatesIdPage(db, r, state)
 		if err != nil {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
@@ -92,19 +124,20 @@ func handleStatesIdPOST(db *database.DB) http.Handler {
 			errorResponse(w, r, http.StatusNotImplemented, nil)
 			return
 		case "edit":
+			if !checkPermission(db, w, r, state.Path, model.PermissionAdmin) {
+				return
+			}
 			statePath := r.FormValue("path")
-			parsedStatePath, err := url.Parse(statePath)
-			if err != nil || path.Clean(parsedStatePath.Path) != statePath || statePath[0] != '/' {
-				render(w, statesIdTemplate, http.StatusBadRequest, StatesIdPage{
-					Page:      makePage(r, &Page{Title: state.Path, Section: "states"}),
-					Path:      statePath,
-					PathError: true,
-					State:     state,
-					Usernames: usernames,
-					Versions:  versions,
-				})
+			if !validPath(statePath) {
+				page.Path = statePath
+				page.PathError = true
+				render(w, statesIdTemplate, http.StatusBadRequest, page)
+				return
+			}
+			if !checkPermission(db, w, r, statePath, model.PermissionAdmin) {
 				return
 			}
+			oldPath := state.Path
 			state.Path = statePath
 			success, err := db.SaveState(state)
 			if err != nil {
@@ -112,34 +145,72 @@ func handleStatesIdPOST(db *database.DB) http.Handler {
 				return
 			}
 			if !success {
-				render(w, statesIdTemplate, http.StatusBadRequest, StatesIdPage{
-					Page:          makePage(r, &Page{Title: state.Path, Section: "states"}),
-					Path:          statePath,
-					PathDuplicate: true,
-					State:         state,
-					Usernames:     usernames,
-					Versions:      versions,
-				})
+				state.Path = oldPath
+				page.Path = statePath
+				page.PathDuplicate = true
+				render(w, statesIdTemplate, http.StatusBadRequest, page)
 				return
 			}
+			recordAuditEvent(db, r, model.AuditStateRename, model.AuditTargetState, state.Id, state.Path,
+				map[string]any{"path": oldPath}, map[string]any{"path": state.Path})
+			page.Page.Title = state.Path
+		case "lock-ttl":
+			if !checkPermission(db, w, r, state.Path, model.PermissionAdmin) {
+				return
+			}
+			lockTTL := r.FormValue("lock-ttl")
+			var previousLockTTL *string
+			if state.LockTTL != nil {
+				s := state.LockTTL.String()
+				previousLockTTL = &s
+			}
+			if lockTTL == "" {
+				state.LockTTL = nil
+			} else {
+				ttl, err := time.ParseDuration(lockTTL)
+				if err != nil || ttl < 0 {
+					page.LockTTL = lockTTL
+					page.LockTTLError = true
+					render(w, statesIdTemplate, http.StatusBadRequest, page)
+					return
+				}
+				state.LockTTL = &ttl
+			}
+			if _, err := db.SaveState(state); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			var newLockTTL *string
+			if state.LockTTL != nil {
+				s := state.LockTTL.String()
+				newLockTTL = &s
+			}
+			recordAuditEvent(db, r, model.AuditStateLockTTL, model.AuditTargetState, state.Id, state.Path,
+				map[string]any{"lock_ttl": previousLockTTL}, map[string]any{"lock_ttl": newLockTTL})
+			page.LockExpires = db.LockExpires(state)
+			page.LockTTL = lockTTL
 		case "unlock":
-			if err := db.ForceUnlock(state); err != nil {
+			if !checkPermission(db, w, r, state.Path, model.PermissionWrite) {
+				return
+			}
+			account := r.Context().Value(model.SessionContextKey{}).(*model.Session).Data.Account
+			if err := db.ForceUnlock(state, account.Id); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if state.Lock != nil {
+				recordAuditEvent(db, r, model.AuditStateForceUnlock, model.AuditTargetState, state.Id, state.Path, state.Lock, nil)
+			}
+			if page.LockReleases, err = db.LoadLockReleasesByState(state); err != nil {
 				errorResponse(w, r, http.StatusInternalServerError, err)
 				return
 			}
+			page.LockExpires = nil
 			state.Lock = nil
 		default:
 			errorResponse(w, r, http.StatusBadRequest, nil)
 			return
 		}
-		render(w, statesIdTemplate, http.StatusOK, StatesIdPage{
-			Page: makePage(r, &Page{
-				Section: "states",
-				Title:   state.Path,
-			}),
-			State:     state,
-			Usernames: usernames,
-			Versions:  versions,
-		})
+		render(w, statesIdTemplate, http.StatusOK, page)
 	})
 }
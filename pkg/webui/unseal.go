@@ -0,0 +1,145 @@
+package webui
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/helpers"
+)
+
+var unsealTemplate = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/unseal.html"))
+
+type unsealPage struct {
+	Page      *Page
+	Err       error
+	Shares    int
+	Threshold int
+}
+
+// Sends every page to the unseal form while tfstated is sealed
+func sealedMiddleware(db *database.DB, requireSession func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if db.Sealed() {
+				if r.Method == http.MethodGet {
+					http.Redirect(w, r, "/unseal", http.StatusFound)
+				} else {
+					errorResponse(w, r, http.StatusServiceUnavailable, database.ErrSealed)
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+func renderUnseal(w http.ResponseWriter, r *http.Request, db *database.DB, status int, err error) {
+	shares, threshold := db.UnsealProgress()
+	render(w, unsealTemplate, status, unsealPage{
+		Page:      makePage(r, &Page{Title: "Unseal", Section: "unseal"}),
+		Err:       err,
+		Shares:    shares,
+		Threshold: threshold,
+	})
+}
+
+func handleUnsealGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, no-cache")
+		if !db.Sealed() {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		renderUnseal(w, r, db, http.StatusOK, nil)
+	})
+}
+
+func handleUnsealPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			errorResponse(w, r, http.StatusBadRequest,
+				fmt.Errorf("failed to parse form: %w", err))
+			return
+		}
+		if !verifyCSRFToken(w, r) {
+			return
+		}
+		unsealed, err := db.SubmitUnsealShare(r.FormValue("share"))
+		if err != nil {
+			if errors.Is(err, database.ErrUnsealFailed) {
+				renderUnseal(w, r, db, http.StatusBadRequest, err)
+			} else {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		if unsealed {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/unseal", http.StatusFound)
+	})
+}
+
+type unsealRequest struct {
+	Share string `json:"share"`
+}
+
+type unsealResponse struct {
+	Sealed    bool `json:"sealed"`
+	Shares    int  `json:"shares"`
+	Threshold int  `json:"threshold"`
+}
+
+// Accepts unseal shares from the tfstated unseal command, authenticated with
+// the password of an administrator. Requiring a JSON content type protects it
+// against cross site form submissions.
+func handleAPIUnsealPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tfstated", charset="UTF-8"`)
+			helpers.ErrorResponse(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		account, token, err := db.LoadAccountByCredentials(username, password)
+		if err != nil {
+			helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		if account == nil || token != nil || !account.IsAdmin {
+			helpers.ErrorResponse(w, http.StatusForbidden,
+				errors.New("only administrators can submit unseal shares, with their password"))
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			helpers.ErrorResponse(w, http.StatusUnsupportedMediaType,
+				errors.New("expected an application/json request body"))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 4096)
+		var req unsealRequest
+		if err := helpers.Decode(r, &req); err != nil {
+			helpers.ErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := db.SubmitUnsealShare(req.Share); err != nil {
+			if errors.Is(err, database.ErrUnsealFailed) {
+				helpers.ErrorResponse(w, http.StatusBadRequest, err)
+			} else {
+				helpers.ErrorResponse(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		shares, threshold := db.UnsealProgress()
+		_ = helpers.Encode(w, http.StatusOK, &unsealResponse{
+			Sealed:    db.Sealed(),
+			Shares:    shares,
+			Threshold: threshold,
+		})
+	})
+}
@@ -1,59 +1,258 @@
 package webui
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"git.adyxax.org/adyxax/tfstated/pkg/database"
 	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/tfstate"
 	"go.n16f.net/uuid"
 )
 
-var versionsTemplate = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/versions.html"))
+type VersionsPage struct {
+	Page             *Page
+	Account          *model.Account
+	CanRestore       bool
+	CanReveal        bool
+	Encrypted        bool
+	Explorer         *tfstate.Explorer
+	ExplorerError    error
+	PinnedBy         *model.Account
+	PinReasonError   bool
+	Revealed         bool
+	State            *model.State
+	Version          *model.Version
+	VersionData      string
+	VersionDataError error
+}
 
-func handleVersionsGET(db *database.DB) http.Handler {
-	type VersionsData struct {
-		Page        *Page
-		Account     *model.Account
-		State       *model.State
-		Version     *model.Version
-		VersionData string
+var versionsTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/versions.html", "html/stateNode.html"))
+
+// Loads the version referenced by the request path along with its state, or
+// writes an error response
+func loadVersion(db *database.DB, w http.ResponseWriter, r *http.Request) (*model.Version, *model.State, bool) {
+	var versionId uuid.UUID
+	if err := versionId.Parse(r.PathValue("id")); err != nil {
+		errorResponse(w, r, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	version, err := db.LoadVersionById(versionId)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	if version == nil {
+		errorResponse(w, r, http.StatusNotFound, fmt.Errorf("The version %s does not exist.", versionId))
+		return nil, nil, false
+	}
+	state, err := db.LoadStateById(version.StateId)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	return version, state, true
+}
+
+func makeVersionsPage(db *database.DB, r *http.Request, state *model.State, version *model.Version, reveal bool) (*VersionsPage, error) {
+	account, err := db.LoadAccountById(&version.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	session := r.Context().Value(model.SessionContextKey{}).(*model.Session)
+	canReveal, err := db.CheckPermission(session.Data.Account, state.Path, model.PermissionReveal)
+	if err != nil {
+		return nil, err
+	}
+	canRestore, err := db.CheckPermission(session.Data.Account, state.Path, model.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	page := &VersionsPage{
+		Page: makePage(r, &Page{
+			Section: "states",
+			Title:   state.Path,
+		}),
+		Account:    account,
+		CanRestore: canRestore,
+		CanReveal:  canReveal,
+		Revealed:   reveal,
+		State:      state,
+		Version:    version,
 	}
+	if version.PinnedBy != nil {
+		if page.PinnedBy, err = db.LoadAccountById(version.PinnedBy); err != nil {
+			return nil, err
+		}
+	}
+	if document, err := tfstate.ParseDocument(version.Data); err != nil {
+		page.ExplorerError = err
+	} else if document.Encrypted() {
+		page.Encrypted = true
+	} else if page.Explorer, err = document.Explore(reveal); err != nil {
+		page.ExplorerError = err
+	}
+	if reveal {
+		page.VersionData = string(version.Data)
+	} else if data, err := tfstate.MaskDocument(version.Data); err != nil {
+		slog.Error("failed to mask the sensitive values of a version", "version", version.Id, "err", err)
+		page.VersionDataError = err
+	} else {
+		page.VersionData = string(data)
+	}
+	return page, nil
+}
+
+func handleVersionsGET(db *database.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var versionId uuid.UUID
-		if err := versionId.Parse(r.PathValue("id")); err != nil {
-			errorResponse(w, r, http.StatusBadRequest, err)
+		version, state, ok := loadVersion(db, w, r)
+		if !ok {
 			return
 		}
-		version, err := db.LoadVersionById(versionId)
+		if !checkPermission(db, w, r, state.Path, model.PermissionRead) {
+			return
+		}
+		page, err := makeVersionsPage(db, r, state, version, false)
 		if err != nil {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		if version == nil {
-			errorResponse(w, r, http.StatusNotFound, err)
+		render(w, versionsTemplates, http.StatusOK, page)
+	})
+}
+
+// Reveals the sensitive values of a version, either on the version page or
+// as a download, restores a version as the current version of its state, or
+// pins and unpins a version
+func handleVersionsPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			errorResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if !verifyCSRFToken(w, r) {
 			return
 		}
-		state, err := db.LoadStateById(version.StateId)
+		version, state, ok := loadVersion(db, w, r)
+		if !ok {
+			return
+		}
+		action := r.FormValue("action")
+		switch action {
+		case "download", "reveal":
+			if !checkPermission(db, w, r, state.Path, model.PermissionReveal) {
+				return
+			}
+			recordAuditEvent(db, r, model.AuditStateReveal, model.AuditTargetState, state.Id, state.Path, nil, map[string]any{
+				"download":   action == "download",
+				"version_id": version.Id,
+			})
+			if action == "download" {
+				writeVersionDownload(w, version, version.Data)
+				return
+			}
+		case "restore":
+			if !checkPermission(db, w, r, state.Path, model.PermissionWrite) {
+				return
+			}
+			account := r.Context().Value(model.SessionContextKey{}).(*model.Session).Data.Account
+			if err := db.RestoreVersion(version, account.Id); err != nil {
+				switch {
+				case errors.Is(err, database.ErrStateLocked):
+					errorResponse(w, r, http.StatusConflict,
+						fmt.Errorf("The state %s is locked, it cannot be restored until it is unlocked.", state.Path))
+				case errors.Is(err, database.ErrInvalidState), errors.Is(err, database.ErrLineageMismatch):
+					errorResponse(w, r, http.StatusConflict, err)
+				default:
+					errorResponse(w, r, http.StatusInternalServerError, err)
+				}
+				return
+			}
+			recordAuditEvent(db, r, model.AuditStateRestore, model.AuditTargetState, state.Id, state.Path, nil, map[string]any{
+				"version_id": version.Id,
+			})
+			http.Redirect(w, r, "/states/"+state.Id.String(), http.StatusFound)
+			return
+		case "pin":
+			if !checkPermission(db, w, r, state.Path, model.PermissionWrite) {
+				return
+			}
+			reason := strings.TrimSpace(r.FormValue("reason"))
+			if reason == "" {
+				page, err := makeVersionsPage(db, r, state, version, false)
+				if err != nil {
+					errorResponse(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				page.PinReasonError = true
+				render(w, versionsTemplates, http.StatusBadRequest, page)
+				return
+			}
+			account := r.Context().Value(model.SessionContextKey{}).(*model.Session).Data.Account
+			if err := db.PinVersion(version, account.Id, reason); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditStatePin, model.AuditTargetState, state.Id, state.Path, nil, map[string]any{
+				"reason":     reason,
+				"version_id": version.Id,
+			})
+			http.Redirect(w, r, "/versions/"+version.Id.String(), http.StatusFound)
+			return
+		case "unpin":
+			if !checkPermission(db, w, r, state.Path, model.PermissionWrite) {
+				return
+			}
+			before := map[string]any{
+				"reason":     version.PinnedReason,
+				"version_id": version.Id,
+			}
+			if err := db.UnpinVersion(version); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditStateUnpin, model.AuditTargetState, state.Id, state.Path, before, nil)
+			http.Redirect(w, r, "/versions/"+version.Id.String(), http.StatusFound)
+			return
+		default:
+			errorResponse(w, r, http.StatusBadRequest, fmt.Errorf("invalid action"))
+			return
+		}
+		page, err := makeVersionsPage(db, r, state, version, true)
 		if err != nil {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		account, err := db.LoadAccountById(&version.AccountId)
+		render(w, versionsTemplates, http.StatusOK, page)
+	})
+}
+
+// Downloads a version with its sensitive values masked
+func handleVersionsDownloadGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, state, ok := loadVersion(db, w, r)
+		if !ok {
+			return
+		}
+		if !checkPermission(db, w, r, state.Path, model.PermissionRead) {
+			return
+		}
+		data, err := tfstate.MaskDocument(version.Data)
 		if err != nil {
 			errorResponse(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		versionData := string(version.Data[:])
-		render(w, versionsTemplate, http.StatusOK, VersionsData{
-			Page: makePage(r, &Page{
-				Section: "states",
-				Title:   state.Path,
-			}),
-			Account:     account,
-			State:       state,
-			Version:     version,
-			VersionData: versionData,
-		})
+		writeVersionDownload(w, version, data)
 	})
 }
+
+func writeVersionDownload(w http.ResponseWriter, version *model.Version, data []byte) {
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tfstate"`, version.Id))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
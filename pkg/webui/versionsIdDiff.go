@@ -0,0 +1,102 @@
+package webui
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"git.adyxax.org/adyxax/tfstated/pkg/tfstate"
+	"go.n16f.net/uuid"
+)
+
+type VersionsIdDiffPage struct {
+	Page      *Page
+	Against   *model.Version
+	Diff      *tfstate.Diff
+	Encrypted bool
+	State     *model.State
+	Version   *model.Version
+	Versions  []model.Version
+}
+
+var versionsIdDiffTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/versionsIdDiff.html"))
+
+func handleVersionsIdDiffGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, state, ok := loadVersion(db, w, r)
+		if !ok {
+			return
+		}
+		if !checkPermission(db, w, r, state.Path, model.PermissionRead) {
+			return
+		}
+		var (
+			against *model.Version
+			err     error
+		)
+		if s := r.URL.Query().Get("against"); s != "" {
+			var againstId uuid.UUID
+			if err := againstId.Parse(s); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			against, err = db.LoadVersionById(againstId)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if against == nil || against.StateId != state.Id {
+				errorResponse(w, r, http.StatusBadRequest,
+					fmt.Errorf("The version %s is not a version of %s.", againstId, state.Path))
+				return
+			}
+		} else {
+			against, err = db.LoadPreviousVersion(version)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		after, err := tfstate.ParseDocument(version.Data)
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		var before *tfstate.Document
+		if against != nil {
+			before, err = tfstate.ParseDocument(against.Data)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		encrypted := after.Encrypted() || (before != nil && before.Encrypted())
+		var diff *tfstate.Diff
+		if !encrypted {
+			diff, err = tfstate.Compare(before, after)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		versions, err := db.LoadVersionsByState(state)
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		render(w, versionsIdDiffTemplates, http.StatusOK, &VersionsIdDiffPage{
+			Page: makePage(r, &Page{
+				Section: "states",
+				Title:   state.Path,
+			}),
+			Against:   against,
+			Diff:      diff,
+			Encrypted: encrypted,
+			State:     state,
+			Version:   version,
+			Versions:  versions,
+		})
+	})
+}
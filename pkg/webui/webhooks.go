@@ -0,0 +1,114 @@
+package webui
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+)
+
+type WebhooksPage struct {
+	Events          []string
+	EventsInvalid   bool
+	Page            *Page
+	PathPrefix      string
+	PathPrefixError bool
+	URL             string
+	URLInvalid      bool
+	WebhookEvents   []string
+	Webhooks        []model.Webhook
+}
+
+var webhooksTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/webhooks.html", "html/webhookForm.html"))
+
+func (page *WebhooksPage) EventSelected(event string) bool {
+	return slices.Contains(page.Events, event)
+}
+
+func (page *WebhooksPage) WebhookAction() string {
+	return "/webhooks"
+}
+
+func (page *WebhooksPage) WebhookLegend() string {
+	return "New Webhook"
+}
+
+func validWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Returns the events selected in a webhook form, or nil if one is invalid
+func parseWebhookEvents(r *http.Request) []string {
+	events := r.Form["events"]
+	for _, event := range events {
+		if !slices.Contains(model.WebhookEvents, event) {
+			return nil
+		}
+	}
+	return events
+}
+
+func handleWebhooksGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhooks, err := db.LoadWebhooks()
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		render(w, webhooksTemplates, http.StatusOK, &WebhooksPage{
+			Events:        model.WebhookEvents,
+			Page:          makePage(r, &Page{Title: "Webhooks", Section: "webhooks"}),
+			PathPrefix:    "/",
+			WebhookEvents: model.WebhookEvents,
+			Webhooks:      webhooks,
+		})
+	})
+}
+
+func handleWebhooksPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			errorResponse(w, r, http.StatusBadRequest,
+				fmt.Errorf("failed to parse form: %w", err))
+			return
+		}
+		if !verifyCSRFToken(w, r) {
+			return
+		}
+		webhooks, err := db.LoadWebhooks()
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		page := WebhooksPage{
+			Events:        parseWebhookEvents(r),
+			Page:          makePage(r, &Page{Title: "New Webhook", Section: "webhooks"}),
+			PathPrefix:    r.FormValue("path-prefix"),
+			URL:           r.FormValue("url"),
+			WebhookEvents: model.WebhookEvents,
+			Webhooks:      webhooks,
+		}
+		page.EventsInvalid = len(page.Events) == 0
+		page.PathPrefixError = !validPath(page.PathPrefix)
+		page.URLInvalid = !validWebhookURL(page.URL)
+		if page.EventsInvalid || page.PathPrefixError || page.URLInvalid {
+			render(w, webhooksTemplates, http.StatusBadRequest, &page)
+			return
+		}
+		webhook, err := db.CreateWebhook(page.URL, page.PathPrefix, page.Events)
+		if err != nil {
+			errorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		recordAuditEvent(db, r, model.AuditWebhookCreate, model.AuditTargetWebhook, webhook.Id, webhook.URL,
+			nil, map[string]any{"events": webhook.Events, "path_prefix": webhook.PathPrefix, "url": webhook.URL})
+		destination := path.Join("/webhooks", webhook.Id.String())
+		http.Redirect(w, r, destination, http.StatusFound)
+	})
+}
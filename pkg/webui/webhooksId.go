@@ -0,0 +1,174 @@
+package webui
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+
+	"git.adyxax.org/adyxax/tfstated/pkg/database"
+	"git.adyxax.org/adyxax/tfstated/pkg/model"
+	"go.n16f.net/uuid"
+)
+
+type WebhooksIdPage struct {
+	Deliveries      []model.WebhookDelivery
+	Events          []string
+	EventsInvalid   bool
+	Page            *Page
+	PathPrefix      string
+	PathPrefixError bool
+	URL             string
+	URLInvalid      bool
+	Webhook         *model.Webhook
+	WebhookEvents   []string
+}
+
+var webhooksIdTemplates = template.Must(template.ParseFS(htmlFS, "html/base.html", "html/webhooksId.html", "html/webhookForm.html"))
+
+func (page *WebhooksIdPage) EventSelected(event string) bool {
+	return slices.Contains(page.Events, event)
+}
+
+func (page *WebhooksIdPage) WebhookAction() string {
+	return "/webhooks/" + page.Webhook.Id.String()
+}
+
+func (page *WebhooksIdPage) WebhookLegend() string {
+	return "Edit Webhook"
+}
+
+func prepareWebhooksIdPage(db *database.DB, w http.ResponseWriter, r *http.Request) *WebhooksIdPage {
+	var webhookId uuid.UUID
+	if err := webhookId.Parse(r.PathValue("id")); err != nil {
+		errorResponse(w, r, http.StatusBadRequest, err)
+		return nil
+	}
+	webhook, err := db.LoadWebhookById(webhookId)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	if webhook == nil {
+		errorResponse(w, r, http.StatusNotFound, fmt.Errorf("The webhook Id could not be found."))
+		return nil
+	}
+	deliveries, err := db.LoadWebhookDeliveries(webhook, 50)
+	if err != nil {
+		errorResponse(w, r, http.StatusInternalServerError, err)
+		return nil
+	}
+	return &WebhooksIdPage{
+		Deliveries: deliveries,
+		Events:     webhook.Events,
+		Page: makePage(r, &Page{
+			Section: "webhooks",
+			Title:   webhook.URL,
+		}),
+		PathPrefix:    webhook.PathPrefix,
+		URL:           webhook.URL,
+		Webhook:       webhook,
+		WebhookEvents: model.WebhookEvents,
+	}
+}
+
+func handleWebhooksIdGET(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := prepareWebhooksIdPage(db, w, r)
+		if page != nil {
+			render(w, webhooksIdTemplates, http.StatusOK, page)
+		}
+	})
+}
+
+func handleWebhooksIdPOST(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			errorResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if !verifyCSRFToken(w, r) {
+			return
+		}
+		page := prepareWebhooksIdPage(db, w, r)
+		if page == nil {
+			return
+		}
+		webhook := page.Webhook
+		before := map[string]any{
+			"enabled":     webhook.Enabled,
+			"events":      webhook.Events,
+			"path_prefix": webhook.PathPrefix,
+			"url":         webhook.URL,
+		}
+		action := r.FormValue("action")
+		switch action {
+		case "delete":
+			if err := db.DeleteWebhook(webhook); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditWebhookDelete, model.AuditTargetWebhook, webhook.Id, webhook.URL,
+				before, nil)
+			http.Redirect(w, r, "/webhooks", http.StatusFound)
+			return
+		case "disable", "enable":
+			webhook.Enabled = action == "enable"
+			if err := db.SaveWebhook(webhook); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditWebhookEdit, model.AuditTargetWebhook, webhook.Id, webhook.URL,
+				map[string]any{"enabled": !webhook.Enabled}, map[string]any{"enabled": webhook.Enabled})
+		case "edit":
+			page.Events = parseWebhookEvents(r)
+			page.PathPrefix = r.FormValue("path-prefix")
+			page.URL = r.FormValue("url")
+			page.EventsInvalid = len(page.Events) == 0
+			page.PathPrefixError = !validPath(page.PathPrefix)
+			page.URLInvalid = !validWebhookURL(page.URL)
+			if page.EventsInvalid || page.PathPrefixError || page.URLInvalid {
+				render(w, webhooksIdTemplates, http.StatusBadRequest, page)
+				return
+			}
+			webhook.Events = page.Events
+			webhook.PathPrefix = page.PathPrefix
+			webhook.URL = page.URL
+			if err := db.SaveWebhook(webhook); err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			recordAuditEvent(db, r, model.AuditWebhookEdit, model.AuditTargetWebhook, webhook.Id, webhook.URL,
+				before, map[string]any{
+					"enabled":     webhook.Enabled,
+					"events":      webhook.Events,
+					"path_prefix": webhook.PathPrefix,
+					"url":         webhook.URL,
+				})
+		case "retry":
+			var deliveryId uuid.UUID
+			if err := deliveryId.Parse(r.FormValue("delivery")); err != nil {
+				errorResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			found, err := db.RetryWebhookDelivery(webhook, deliveryId)
+			if err != nil {
+				errorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if !found {
+				errorResponse(w, r, http.StatusNotFound,
+					fmt.Errorf("The delivery Id could not be found for this webhook."))
+				return
+			}
+			recordAuditEvent(db, r, model.AuditWebhookRetry, model.AuditTargetWebhook, webhook.Id, webhook.URL,
+				nil, map[string]any{"delivery_id": deliveryId})
+			http.Redirect(w, r, "/webhooks/"+webhook.Id.String(), http.StatusFound)
+			return
+		default:
+			errorResponse(w, r, http.StatusBadRequest, nil)
+			return
+		}
+		render(w, webhooksIdTemplates, http.StatusOK, page)
+	})
+}